@@ -3,10 +3,42 @@ package commands
 import "github.com/spf13/cobra"
 
 var (
-	FlagConfigPath string
+	FlagConfigPath    string
+	FlagBackfillFrom  uint64
+	FlagBackfillTo    uint64
+	FlagMigrateSteps  int
+	FlagReencodeSize  int
+	FlagReindexSize   int
+	FlagRetryNoVerify bool
 )
 
 // addGlobalFlags defines flags to be used regardless of the command used
 func addGlobalFlags(cmd *cobra.Command) {
 	RootCmd.PersistentFlags().StringVarP(&FlagConfigPath, "config", "f", "", "configuration file")
 }
+
+// addBackfillFlags defines flags used by the ingest backfill command
+func addBackfillFlags(cmd *cobra.Command) {
+	cmd.Flags().Uint64Var(&FlagBackfillFrom, "from", 0, "first height to backfill (defaults to the lowest height retained by the node, for the blocks and the block results)")
+	cmd.Flags().Uint64Var(&FlagBackfillTo, "to", 0, "last height to backfill (defaults to the node latest height)")
+}
+
+// addMigrateDownFlags defines flags used by the storage migrate down command
+func addMigrateDownFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&FlagMigrateSteps, "steps", 1, "number of migrations to roll back")
+}
+
+// addReencodeFlags defines flags used by the storage reencode command
+func addReencodeFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&FlagReencodeSize, "batch-size", 1000, "number of rows re-encoded per transaction")
+}
+
+// addReindexFlags defines flags used by the storage reindex command
+func addReindexFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&FlagReindexSize, "batch-size", 1000, "number of heights reindexed per transaction")
+}
+
+// addQuarantineRetryFlags defines flags used by the storage quarantine retry command
+func addQuarantineRetryFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&FlagRetryNoVerify, "no-verify", false, "store the data fetched again without verifying it")
+}
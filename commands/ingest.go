@@ -1,12 +1,16 @@
 package commands
 
 import (
+	"context"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/cometbft/rpc-companion/config"
 	"github.com/cometbft/rpc-companion/ingest"
 	rpcos "github.com/cometbft/rpc-companion/libs/os"
+	"github.com/cometbft/rpc-companion/storage"
 	"github.com/spf13/cobra"
 )
 
@@ -24,6 +28,9 @@ There should be just one running instance of the Ingest Service targeting a full
 
 func init() {
 	IngestCmd.AddCommand(ingestStartCmd)
+	IngestCmd.AddCommand(ingestBackfillCmd)
+
+	addBackfillFlags(ingestBackfillCmd)
 }
 
 // StartCmd start ingest service
@@ -45,6 +52,7 @@ var ingestStartCmd = &cobra.Command{
 		service, err := ingest.NewIngestService(*logger, config)
 		if err != nil {
 			logger.Error("Create new ingest service", "error", err)
+			os.Exit(1)
 		}
 
 		err = service.Start()
@@ -73,3 +81,74 @@ var ingestStartCmd = &cobra.Command{
 		select {}
 	},
 }
+
+// ingestBackfillCmd backfill blocks missing in the storage
+var ingestBackfillCmd = &cobra.Command{
+	Use:   "backfill",
+	Short: "Backfill missing blocks",
+	Long: `The backfill command fetches the blocks in the [--from, --to] range that are missing in the storage.
+
+It can run while the Ingest Service is running, but the range must be retained by the node.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		textHandler := slog.NewTextHandler(os.Stdout, nil)
+		logger := slog.New(textHandler)
+
+		// Load configuration file
+		config, err := config.LoadConfig(FlagConfigPath)
+		if err != nil {
+			logger.Error("Read configuration file", "error", err)
+			os.Exit(1)
+		}
+
+		db, err := storage.NewStorage(config.Storage)
+		if err != nil {
+			logger.Error("Create new storage", "error", err)
+			os.Exit(1)
+		}
+
+		if err := ingest.PrepareSchema(cmd.Context(), *logger, config.Storage, db); err != nil {
+			logger.Error("Prepare storage schema", "error", err)
+			os.Exit(1)
+		}
+
+		fetcher, err := ingest.NewFetcher(*logger, &config, db)
+		if err != nil {
+			logger.Error("Create new fetcher", "error", err)
+			os.Exit(1)
+		}
+
+		// Stop backfilling upon receiving SIGTERM or CTRL-C, once the heights
+		// being fetched are stored
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		if err := backfill(ctx, fetcher); err != nil {
+			logger.Error("Backfill", "error", err)
+			os.Exit(1)
+		}
+		if err := fetcher.Close(); err != nil {
+			logger.Error("Close fetcher", "error", err)
+		}
+		if err := db.Disconnect(); err != nil {
+			logger.Error("Disconnect storage", "error", err)
+		}
+	},
+}
+
+// backfill backfills the range set by the flags, which defaults to the heights
+// retained by the node
+func backfill(ctx context.Context, fetcher *ingest.Fetcher) error {
+	to := FlagBackfillTo
+	if to == 0 {
+		latestHeight, err := fetcher.GetLatestHeight(ctx)
+		if err != nil {
+			return err
+		}
+		to = uint64(latestHeight)
+	}
+
+	if FlagBackfillFrom == 0 {
+		return fetcher.BackfillRetained(ctx, to)
+	}
+	return fetcher.Backfill(ctx, FlagBackfillFrom, to)
+}
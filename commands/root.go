@@ -31,6 +31,8 @@ func init() {
 	cobra.EnableCommandSorting = true
 
 	RootCmd.AddCommand(IngestCmd)
+	RootCmd.AddCommand(StorageCmd)
+	RootCmd.AddCommand(ServeCmd)
 }
 
 // RootCmd is the root command for CometBFT core.
@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/cometbft/rpc-companion/config"
+	"github.com/cometbft/rpc-companion/events"
+	"github.com/cometbft/rpc-companion/grpcserver"
+	"github.com/cometbft/rpc-companion/ingest"
+	rpcos "github.com/cometbft/rpc-companion/libs/os"
+	"github.com/cometbft/rpc-companion/rpc"
+	"github.com/cometbft/rpc-companion/storage"
+	"github.com/spf13/cobra"
+)
+
+// ServeCmd start the JSON-RPC and gRPC servers
+var ServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Start the JSON-RPC and gRPC servers",
+	Long: `The serve command runs the JSON-RPC server, which exposes the CometBFT RPC endpoints and answers them from the storage.
+If a gRPC listen address is configured, it also runs the CometBFT gRPC block and block results services.
+
+Clients can be pointed at the RPC Companion instead of the node. Several instances can run against the same storage.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		textHandler := slog.NewTextHandler(os.Stdout, nil)
+		logger := slog.New(textHandler)
+
+		// Load configuration file
+		config, err := config.LoadConfig(FlagConfigPath)
+		if err != nil {
+			logger.Error("Read configuration file", "error", err)
+			os.Exit(1)
+		}
+
+		db, err := storage.NewStorage(config.Storage)
+		if err != nil {
+			logger.Error("Create new storage", "error", err)
+			os.Exit(1)
+		}
+
+		if err := db.CheckSchema(cmd.Context()); err != nil {
+			logger.Error("Check storage schema, run `rpc-companion storage migrate up`", "error", err)
+			os.Exit(1)
+		}
+
+		// Events of the newly ingested blocks
+		publisher := events.NewPublisher(*logger, db, config.RPC.EventPollInterval, config.RPC.EventGapTimeout)
+		if err := publisher.Start(); err != nil {
+			logger.Error("Start the event publisher", "error", err)
+			os.Exit(1)
+		}
+
+		// Fetcher of the data missing in the storage
+		var fetcher *ingest.Fetcher
+		if config.RPC.Fallback {
+			fetcher, err = ingest.NewFetcher(*logger, &config, db)
+			if err != nil {
+				logger.Error("Create new fetcher", "error", err)
+				os.Exit(1)
+			}
+		}
+
+		server := rpc.NewServer(*logger, &config, db, publisher.EventBus(), fetcher)
+		if err := server.Start(); err != nil {
+			logger.Error("Start the JSON-RPC server", "error", err)
+			os.Exit(1)
+		}
+
+		var grpcServer *grpcserver.Server
+		if config.GRPCServer.ListenAddress != "" {
+			grpcServer = grpcserver.NewServer(*logger, &config, db, publisher.EventBus())
+			if err := grpcServer.Start(); err != nil {
+				logger.Error("Start the gRPC server", "error", err)
+				os.Exit(1)
+			}
+		}
+
+		// Stop upon receiving SIGTERM or CTRL-C.
+		rpcos.TrapSignal(*logger, func() {
+			if err := server.Stop(); err != nil {
+				logger.Error("Stopping JSON-RPC server", "error", err)
+			} else {
+				logger.Info("Stopped JSON-RPC server")
+			}
+			if grpcServer != nil {
+				if err := grpcServer.Stop(); err != nil {
+					logger.Error("Stopping gRPC server", "error", err)
+				} else {
+					logger.Info("Stopped gRPC server")
+				}
+			}
+			if fetcher != nil {
+				if err := fetcher.Close(); err != nil {
+					logger.Error("Close fallback fetcher", "error", err)
+				}
+			}
+			if err := publisher.Stop(); err != nil {
+				logger.Error("Stopping event publisher", "error", err)
+			}
+			if err := db.Disconnect(); err != nil {
+				logger.Error("Disconnect storage", "error", err)
+			}
+		})
+
+		// Loop - non-blocking
+		select {}
+	},
+}
@@ -0,0 +1,339 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cometbft/rpc-companion/config"
+	"github.com/cometbft/rpc-companion/ingest"
+	"github.com/cometbft/rpc-companion/storage"
+	"github.com/spf13/cobra"
+)
+
+// StorageCmd storage commands
+var StorageCmd = &cobra.Command{
+	Use:   "storage",
+	Short: "Storage commands",
+	Long:  `The storage commands manage the database used by the RPC Companion.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+// storageMigrateCmd schema migration commands
+var storageMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Schema migration commands",
+	Long: `The migrate commands apply and roll back the versioned schema migrations.
+
+The applied migrations are tracked in the schema_migrations table. The Ingest Service refuses to start if any migration is pending.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+// storageQuarantineCmd quarantined data commands
+var storageQuarantineCmd = &cobra.Command{
+	Use:   "quarantine",
+	Short: "Quarantined data commands",
+	Long: `The quarantine commands inspect and retry the blocks and block results that failed verification.
+
+The quarantined heights are missing in the storage, so the retain heights of the node don't advance past them until they are stored.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+func init() {
+	StorageCmd.AddCommand(storageMigrateCmd)
+	StorageCmd.AddCommand(storageReencodeCmd)
+	StorageCmd.AddCommand(storageReindexCmd)
+	StorageCmd.AddCommand(storageQuarantineCmd)
+
+	storageMigrateCmd.AddCommand(storageMigrateUpCmd)
+	storageMigrateCmd.AddCommand(storageMigrateDownCmd)
+	storageMigrateCmd.AddCommand(storageMigrateStatusCmd)
+
+	storageQuarantineCmd.AddCommand(storageQuarantineListCmd)
+	storageQuarantineCmd.AddCommand(storageQuarantineRetryCmd)
+
+	addMigrateDownFlags(storageMigrateDownCmd)
+	addReencodeFlags(storageReencodeCmd)
+	addReindexFlags(storageReindexCmd)
+	addQuarantineRetryFlags(storageQuarantineRetryCmd)
+}
+
+// storageMigrateUpCmd apply the pending migrations
+var storageMigrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply the pending migrations",
+	Long:  `The up command applies every pending migration in order, each one in a transaction.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, db, migrator := newMigrator()
+		defer db.Disconnect()
+
+		count, err := migrator.MigrateUp(cmd.Context())
+		if err != nil {
+			logger.Error("Apply migrations", "error", err, "applied", count)
+			os.Exit(1)
+		}
+		logger.Info("Applied migrations", "count", count)
+	},
+}
+
+// storageMigrateDownCmd roll back the last applied migrations
+var storageMigrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Roll back the last applied migrations",
+	Long: `The down command rolls back the last --steps applied migrations, newest first.
+
+Rolling back a migration may drop tables and the data stored in them.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, db, migrator := newMigrator()
+		defer db.Disconnect()
+
+		if FlagMigrateSteps <= 0 {
+			logger.Error("Invalid number of steps, it must be greater than zero", "steps", FlagMigrateSteps)
+			os.Exit(1)
+		}
+
+		count, err := migrator.MigrateDown(cmd.Context(), FlagMigrateSteps)
+		if err != nil {
+			logger.Error("Roll back migrations", "error", err, "rolled_back", count)
+			os.Exit(1)
+		}
+		logger.Info("Rolled back migrations", "count", count)
+	},
+}
+
+// storageMigrateStatusCmd show the status of the migrations
+var storageMigrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the status of the migrations",
+	Long:  `The status command lists the known migrations and whether they are applied.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, db, migrator := newMigrator()
+		defer db.Disconnect()
+
+		status, err := migrator.MigrationStatus(cmd.Context())
+		if err != nil {
+			logger.Error("Get migration status", "error", err)
+			os.Exit(1)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range status {
+			appliedAt := "pending"
+			if s.Applied {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		w.Flush()
+	},
+}
+
+// storageReencodeCmd re-encode the stored rows with the configured encoding
+var storageReencodeCmd = &cobra.Command{
+	Use:   "reencode",
+	Short: "Re-encode the stored blocks and block results",
+	Long: `The reencode command re-encodes the blocks and block results stored with another encoding than the configured one, e.g. after changing the encoding or the compression of the [storage] section.
+
+The rows are re-encoded in batches of --batch-size rows, each one in a transaction, so the command can be interrupted and run again. The Ingest Service can keep running meanwhile.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, config, db := openStorage()
+		defer db.Disconnect()
+
+		if FlagReencodeSize <= 0 {
+			logger.Error("Invalid batch size, it must be greater than zero", "batch_size", FlagReencodeSize)
+			os.Exit(1)
+		}
+
+		reencoder, ok := db.(storage.Reencoder)
+		if !ok {
+			logger.Error("Storage backend has no encoded rows", "backend", config.Storage.Backend)
+			os.Exit(1)
+		}
+		if err := db.CheckSchema(cmd.Context()); err != nil {
+			logger.Error("Check storage schema", "error", err)
+			os.Exit(1)
+		}
+
+		total := 0
+		for {
+			count, err := reencoder.Reencode(cmd.Context(), FlagReencodeSize)
+			if err != nil {
+				logger.Error("Re-encode rows", "error", err, "reencoded", total)
+				os.Exit(1)
+			}
+			if count == 0 {
+				break
+			}
+			total += count
+			logger.Info("Re-encoded rows", "count", count, "total", total)
+		}
+		logger.Info("Re-encoded all rows", "total", total, "encoding", config.Storage.Encoding, "compression", config.Storage.Compression)
+	},
+}
+
+// storageReindexCmd populate the normalized tables from the stored rows
+var storageReindexCmd = &cobra.Command{
+	Use:   "reindex",
+	Short: "Populate the normalized tables from the stored blocks and block results",
+	Long: `The reindex command inserts the normalized rows of the blocks and block results that have none, e.g. the ones ingested before the normalized_schema migration was applied.
+
+The heights are reindexed in batches of --batch-size heights, each one in a transaction, so the command can be interrupted and run again. The Ingest Service can keep running meanwhile.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, config, db := openStorage()
+		defer db.Disconnect()
+
+		if FlagReindexSize <= 0 {
+			logger.Error("Invalid batch size, it must be greater than zero", "batch_size", FlagReindexSize)
+			os.Exit(1)
+		}
+
+		reindexer, ok := db.(storage.Reindexer)
+		if !ok {
+			logger.Error("Storage backend has no normalized tables", "backend", config.Storage.Backend)
+			os.Exit(1)
+		}
+		if err := db.CheckSchema(cmd.Context()); err != nil {
+			logger.Error("Check storage schema", "error", err)
+			os.Exit(1)
+		}
+
+		for _, kind := range []struct {
+			name    string
+			reindex func(ctx context.Context, after uint64, limit int) (int, uint64, error)
+		}{
+			{"blocks", reindexer.ReindexBlocks},
+			{"block results", reindexer.ReindexBlockResults},
+		} {
+			total, after := 0, uint64(0)
+			for {
+				count, last, err := kind.reindex(cmd.Context(), after, FlagReindexSize)
+				if err != nil {
+					logger.Error("Reindex "+kind.name, "error", err, "reindexed", total)
+					os.Exit(1)
+				}
+				if count == 0 {
+					break
+				}
+				total += count
+				after = last
+				logger.Info("Reindexed "+kind.name, "count", count, "total", total, "height", last)
+			}
+			logger.Info("Reindexed all "+kind.name, "total", total)
+		}
+	},
+}
+
+// storageQuarantineListCmd list the quarantined data
+var storageQuarantineListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the quarantined blocks and block results",
+	Long:  `The list command lists the quarantined blocks and block results, with the failed check and the reason.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, _, db := openStorage()
+		defer db.Disconnect()
+
+		if err := db.CheckSchema(cmd.Context()); err != nil {
+			logger.Error("Check storage schema", "error", err)
+			os.Exit(1)
+		}
+		entries, err := db.GetQuarantined(cmd.Context())
+		if err != nil {
+			logger.Error("Get quarantined data", "error", err)
+			os.Exit(1)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "HEIGHT\tKIND\tCHECK\tQUARANTINED AT\tREASON")
+		for _, e := range entries {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", e.Height, e.Kind, e.Check, e.QuarantinedAt.Format(time.RFC3339), e.Reason)
+		}
+		w.Flush()
+	},
+}
+
+// storageQuarantineRetryCmd fetch the quarantined data again
+var storageQuarantineRetryCmd = &cobra.Command{
+	Use:   "retry",
+	Short: "Fetch the quarantined blocks and block results again",
+	Long: `The retry command fetches the quarantined blocks and block results from the node again, and stores the ones passing verification, which removes them from the quarantine and lets the retain heights of the node advance.
+
+With --no-verify, the data is stored without verification, e.g. once the failed checks were investigated and the data of the node is known to be correct. It can run while the Ingest Service is running.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger, config, db := openStorage()
+		defer db.Disconnect()
+
+		if err := db.CheckSchema(cmd.Context()); err != nil {
+			logger.Error("Check storage schema", "error", err)
+			os.Exit(1)
+		}
+		if FlagRetryNoVerify {
+			config.Ingest.Verify = false
+		}
+		fetcher, err := ingest.NewFetcher(*logger, &config, db)
+		if err != nil {
+			logger.Error("Create new fetcher", "error", err)
+			os.Exit(1)
+		}
+		defer fetcher.Close()
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		remaining, err := fetcher.RetryQuarantined(ctx)
+		if err != nil {
+			logger.Error("Retry quarantined data", "error", err)
+			os.Exit(1)
+		}
+		if remaining > 0 {
+			logger.Error("Data still failing verification, see the list command", "quarantined", remaining)
+			os.Exit(1)
+		}
+		logger.Info("Stored all quarantined data")
+	},
+}
+
+// newMigrator loads the configuration and opens the configured storage, it
+// exits if the storage backend doesn't support migrations
+func newMigrator() (*slog.Logger, storage.IStorage, storage.Migrator) {
+	logger, config, db := openStorage()
+
+	migrator, ok := db.(storage.Migrator)
+	if !ok {
+		logger.Error("Storage backend has no schema to migrate", "backend", config.Storage.Backend)
+		os.Exit(1)
+	}
+	return logger, db, migrator
+}
+
+// openStorage loads the configuration and opens the configured storage, it
+// exits on error
+func openStorage() (*slog.Logger, config.Config, storage.IStorage) {
+	textHandler := slog.NewTextHandler(os.Stdout, nil)
+	logger := slog.New(textHandler)
+
+	// Load configuration file
+	config, err := config.LoadConfig(FlagConfigPath)
+	if err != nil {
+		logger.Error("Read configuration file", "error", err)
+		os.Exit(1)
+	}
+
+	db, err := storage.NewStorage(config.Storage)
+	if err != nil {
+		logger.Error("Create new storage", "error", err)
+		os.Exit(1)
+	}
+	return logger, config, db
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -15,14 +16,47 @@ type Config struct {
 	// Options for services
 	Storage    *StorageConfig    `mapstructure:"storage"`
 	GRPCClient *GRPCClientConfig `mapstructure:"grpc_client"`
+	Ingest     *IngestConfig     `mapstructure:"ingest"`
+	RPC        *RPCConfig        `mapstructure:"rpc"`
+	GRPCServer *GRPCServerConfig `mapstructure:"grpc_server"`
+
+	Instrumentation *InstrumentationConfig `mapstructure:"instrumentation"`
+}
+
+// DefaultConfig returns a default configuration for the RPC Companion
+func DefaultConfig() *Config {
+	return &Config{
+		Storage:    DefaultStorageConfig(),
+		GRPCClient: DefaultGRPCClientConfig(),
+		Ingest:     DefaultIngestConfig(),
+		RPC:        DefaultRPCConfig(),
+		GRPCServer: &GRPCServerConfig{},
+
+		Instrumentation: DefaultInstrumentationConfig(),
+	}
 }
 
 // ValidateBasic performs basic validation and
 // returns an error if any check fails.
 func (cfg *Config) ValidateBasic() error {
+	if err := cfg.Storage.ValidateBasic(); err != nil {
+		return fmt.Errorf("error in [storage] section: %w", err)
+	}
 	if err := cfg.GRPCClient.ValidateBasic(); err != nil {
 		return fmt.Errorf("error in [grpc_client] section: %w", err)
 	}
+	if err := cfg.Ingest.ValidateBasic(); err != nil {
+		return fmt.Errorf("error in [ingest] section: %w", err)
+	}
+	if err := cfg.RPC.ValidateBasic(); err != nil {
+		return fmt.Errorf("error in [rpc] section: %w", err)
+	}
+	if err := cfg.GRPCServer.ValidateBasic(); err != nil {
+		return fmt.Errorf("error in [grpc_server] section: %w", err)
+	}
+	if err := cfg.Instrumentation.ValidateBasic(); err != nil {
+		return fmt.Errorf("error in [instrumentation] section: %w", err)
+	}
 	return nil
 }
 
@@ -35,27 +69,108 @@ type BaseConfig struct { //nolint: maligned
 //-----------------------------------------------------------------------------
 // StorageConfig
 
+const (
+	// StorageBackendPostgres stores the data in a Postgres database
+	StorageBackendPostgres = "postgres"
+	// StorageBackendSQLite stores the data in an embedded SQLite database file
+	StorageBackendSQLite = "sqlite"
+	// StorageBackendMemory stores the data in memory, it is lost on exit
+	StorageBackendMemory = "memory"
+
+	// StorageEncodingJSON stores the blocks and block results as JSON
+	StorageEncodingJSON = "json"
+	// StorageEncodingProtobuf stores the blocks and block results in their
+	// protobuf form, as served by the node
+	StorageEncodingProtobuf = "protobuf"
+
+	// StorageCompressionNone stores the encoded data as is
+	StorageCompressionNone = "none"
+	// StorageCompressionZstd compresses the encoded data with zstd
+	StorageCompressionZstd = "zstd"
+	// StorageCompressionSnappy compresses the encoded data with snappy
+	StorageCompressionSnappy = "snappy"
+)
+
 // StorageConfig defines the configuration options for the storage layer
 type StorageConfig struct { //nolint: maligned
-	// Connection credentials
+	// Storage backend, "postgres", "sqlite" or "memory"
+	Backend string `mapstructure:"backend"`
+	// Connection credentials, or the database file path for sqlite
 	Connection string `mapstructure:"connection"`
+	// Apply the pending schema migrations when the ingest service starts
+	AutoMigrate bool `mapstructure:"auto_migrate"`
+	// Encoding of the stored blocks and block results, "json" or "protobuf".
+	// The rows keep the encoding they were stored with, so changing it
+	// doesn't affect the rows already stored.
+	Encoding string `mapstructure:"encoding"`
+	// Compression of the encoded data, "none", "zstd" or "snappy"
+	Compression string `mapstructure:"compression"`
 }
 
 // DefaultStorageConfig returns a default configuration for the Storage layer
 func DefaultStorageConfig() *StorageConfig {
 	return &StorageConfig{
-		Connection: "",
+		Backend:     StorageBackendPostgres,
+		Connection:  "",
+		AutoMigrate: false,
+		Encoding:    StorageEncodingJSON,
+		Compression: StorageCompressionNone,
+	}
+}
+
+// ValidateBasic performs basic validation for the
+// [storage] config section
+func (cfg *StorageConfig) ValidateBasic() error {
+	switch cfg.Backend {
+	case StorageBackendMemory:
+		// No connection needed
+		return nil
+	case StorageBackendPostgres, StorageBackendSQLite:
+	default:
+		return fmt.Errorf("invalid storage backend %q, it must be %q, %q or %q", cfg.Backend, StorageBackendPostgres, StorageBackendSQLite, StorageBackendMemory)
+	}
+
+	if len(cfg.Connection) <= 0 {
+		return fmt.Errorf("invalid storage connection, cannot be blank, please ensure a value is set in the config")
+	}
+
+	switch cfg.Encoding {
+	case StorageEncodingJSON, StorageEncodingProtobuf:
+	default:
+		return fmt.Errorf("invalid storage encoding %q, it must be %q or %q", cfg.Encoding, StorageEncodingJSON, StorageEncodingProtobuf)
 	}
+
+	switch cfg.Compression {
+	case StorageCompressionNone, StorageCompressionZstd, StorageCompressionSnappy:
+	default:
+		return fmt.Errorf("invalid storage compression %q, it must be %q, %q or %q", cfg.Compression, StorageCompressionNone, StorageCompressionZstd, StorageCompressionSnappy)
+	}
+
+	return nil
 }
 
 //-----------------------------------------------------------------------------
-// StorageConfig
+// GRPCClientConfig
 
 // GRPCClientConfig defines the configuration options for the gRPC fetcher layer
 type GRPCClientConfig struct { //nolint: maligned
 	// GRPC service address
 	ListenAddress           string `mapstructure:"address"`
 	ListenAddressPrivileged string `mapstructure:"privileged_address"`
+
+	// Transport security of the connection to the regular and the
+	// privileged services
+	TLS           *GRPCClientTLSConfig `mapstructure:"tls"`
+	PrivilegedTLS *GRPCClientTLSConfig `mapstructure:"privileged_tls"`
+}
+
+// DefaultGRPCClientConfig returns a default configuration for the gRPC
+// fetcher layer, with insecure connections
+func DefaultGRPCClientConfig() *GRPCClientConfig {
+	return &GRPCClientConfig{
+		TLS:           &GRPCClientTLSConfig{},
+		PrivilegedTLS: &GRPCClientTLSConfig{},
+	}
 }
 
 // ValidateBasic performs basic validation for the
@@ -69,11 +184,324 @@ func (cfg *GRPCClientConfig) ValidateBasic() error {
 		return fmt.Errorf("invalid priviledged listening address, cannot be blank, please ensure a value is set in the config")
 	}
 
+	if err := cfg.TLS.ValidateBasic(); err != nil {
+		return fmt.Errorf("error in [grpc_client.tls] section: %w", err)
+	}
+
+	if err := cfg.PrivilegedTLS.ValidateBasic(); err != nil {
+		return fmt.Errorf("error in [grpc_client.privileged_tls] section: %w", err)
+	}
+
+	return nil
+}
+
+// GRPCClientTLSConfig defines the transport security of a gRPC connection.
+// The files are PEM encoded, and they are reloaded when they change.
+type GRPCClientTLSConfig struct { //nolint: maligned
+	// Connect with TLS, otherwise the connection is insecure
+	Enabled bool `mapstructure:"enabled"`
+	// CA bundle verifying the node certificate, the system CAs are used if
+	// empty
+	CAFile string `mapstructure:"ca_file"`
+	// Client certificate and key for mutual TLS, not sent if empty
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// Name expected in the node certificate, the host of the address is
+	// used if empty
+	ServerName string `mapstructure:"server_name"`
+}
+
+// ValidateBasic performs basic validation for the
+// [grpc_client.tls] and [grpc_client.privileged_tls] config sections
+func (cfg *GRPCClientTLSConfig) ValidateBasic() error {
+	if !cfg.Enabled {
+		if cfg.CAFile != "" || cfg.CertFile != "" || cfg.KeyFile != "" {
+			return fmt.Errorf("invalid TLS files, TLS must be enabled to use them")
+		}
+		return nil
+	}
+
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return fmt.Errorf("invalid client certificate, both cert_file and key_file must be set for mutual TLS")
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// IngestConfig
+
+// IngestConfig defines the configuration options for the ingest service
+type IngestConfig struct { //nolint: maligned
+	// Backfill the blocks retained by the node that are missing in the storage
+	Backfill bool `mapstructure:"backfill"`
+	// Number of blocks fetched concurrently while backfilling
+	BackfillConcurrency int `mapstructure:"backfill_concurrency"`
+	// Number of new blocks fetched concurrently, they are still stored in
+	// height order
+	FetchWorkers int `mapstructure:"fetch_workers"`
+	// Maximum number of heights waiting to be fetched or stored, the stream
+	// of new blocks blocks when it is full
+	QueueSize int `mapstructure:"queue_size"`
+	// Maximum number of heights stored in a single transaction, while
+	// backfilling or when the storage is behind the node
+	BatchSize int `mapstructure:"batch_size"`
+	// Maximum duration a height waits in an incomplete batch
+	BatchFlushInterval time.Duration `mapstructure:"batch_flush_interval"`
+	// Number of heights the storage must be behind the node for the new
+	// blocks to be stored in batches
+	BatchLag uint64 `mapstructure:"batch_lag"`
+	// Delay before the first attempt to re-open the new block stream, or to
+	// backfill again the heights missing in the storage
+	ReconnectInitialDelay time.Duration `mapstructure:"reconnect_initial_delay"`
+	// Maximum delay between attempts to re-open the new block stream, or to
+	// backfill the heights missing in the storage
+	ReconnectMaxDelay time.Duration `mapstructure:"reconnect_max_delay"`
+	// Verify the hashes chaining the blocks and block results before storing
+	// them, quarantining the data that fails verification
+	Verify bool `mapstructure:"verify"`
+
+	// Address of the /healthz and /readyz endpoints, disabled if empty
+	HealthListenAddress string `mapstructure:"health_listen_address"`
+	// Maximum number of heights the storage can be behind the node while
+	// ready
+	MaxLag uint64 `mapstructure:"max_lag"`
+	// Maximum duration without any height streamed by the node before the
+	// stream is considered stuck
+	StreamTimeout time.Duration `mapstructure:"stream_timeout"`
+
+	// Maximum duration to fetch and store the enqueued heights when the
+	// service stops, before aborting them
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+}
+
+// DefaultIngestConfig returns a default configuration for the ingest service
+func DefaultIngestConfig() *IngestConfig {
+	return &IngestConfig{
+		Backfill:              true,
+		BackfillConcurrency:   4,
+		FetchWorkers:          4,
+		QueueSize:             100,
+		BatchSize:             100,
+		BatchFlushInterval:    1 * time.Second,
+		BatchLag:              100,
+		ReconnectInitialDelay: 1 * time.Second,
+		ReconnectMaxDelay:     1 * time.Minute,
+		Verify:                true,
+		HealthListenAddress:   "",
+		MaxLag:                10,
+		StreamTimeout:         30 * time.Second,
+		ShutdownTimeout:       30 * time.Second,
+	}
+}
+
+// ValidateBasic performs basic validation for the
+// [ingest] config section
+func (cfg *IngestConfig) ValidateBasic() error {
+	if cfg.BackfillConcurrency <= 0 {
+		return fmt.Errorf("invalid backfill concurrency, it must be greater than zero")
+	}
+
+	if cfg.FetchWorkers <= 0 {
+		return fmt.Errorf("invalid fetch workers, it must be greater than zero")
+	}
+
+	if cfg.QueueSize <= 0 {
+		return fmt.Errorf("invalid queue size, it must be greater than zero")
+	}
+
+	if cfg.BatchSize <= 0 {
+		return fmt.Errorf("invalid batch size, it must be greater than zero")
+	}
+
+	if cfg.BatchFlushInterval <= 0 {
+		return fmt.Errorf("invalid batch flush interval, it must be greater than zero")
+	}
+
+	if cfg.ReconnectInitialDelay <= 0 {
+		return fmt.Errorf("invalid reconnect initial delay, it must be greater than zero")
+	}
+
+	if cfg.ReconnectMaxDelay < cfg.ReconnectInitialDelay {
+		return fmt.Errorf("invalid reconnect max delay, it cannot be lower than the initial delay")
+	}
+
+	if cfg.StreamTimeout <= 0 {
+		return fmt.Errorf("invalid stream timeout, it must be greater than zero")
+	}
+
+	if cfg.ShutdownTimeout <= 0 {
+		return fmt.Errorf("invalid shutdown timeout, it must be greater than zero")
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// RPCConfig
+
+// RPCConfig defines the configuration options for the JSON-RPC server
+type RPCConfig struct { //nolint: maligned
+	// TCP or UNIX socket address for the JSON-RPC server to listen on
+	ListenAddress string `mapstructure:"listen_address"`
+	// Maximum number of simultaneous connections, 0 means unlimited
+	MaxOpenConnections int `mapstructure:"max_open_connections"`
+	// Maximum size of a request body, in bytes
+	MaxBodyBytes int64 `mapstructure:"max_body_bytes"`
+	// Maximum size of request header, in bytes
+	MaxHeaderBytes int `mapstructure:"max_header_bytes"`
+	// Maximum duration for reading an entire request
+	ReadTimeout time.Duration `mapstructure:"read_timeout"`
+	// Maximum duration before timing out writes of a response
+	WriteTimeout time.Duration `mapstructure:"write_timeout"`
+
+	// Maximum number of unique clients that can subscribe to events through
+	// the /websocket endpoint
+	MaxSubscriptionClients int `mapstructure:"max_subscription_clients"`
+	// Maximum number of unique queries a client can subscribe to
+	MaxSubscriptionsPerClient int `mapstructure:"max_subscriptions_per_client"`
+	// Number of events buffered per subscription before it is canceled for
+	// not being read fast enough
+	SubscriptionBufferSize int `mapstructure:"subscription_buffer_size"`
+	// How often the storage is checked for newly ingested blocks to publish
+	EventPollInterval time.Duration `mapstructure:"event_poll_interval"`
+	// How long the events wait for a height missing in the storage while
+	// higher heights are stored, before the missing height is skipped
+	EventGapTimeout time.Duration `mapstructure:"event_gap_timeout"`
+
+	// Fetch the blocks and block results missing in the storage from the
+	// node, through the [grpc_client] connections
+	Fallback bool `mapstructure:"fallback"`
+	// Store the data fetched by the fallback
+	FallbackPersist bool `mapstructure:"fallback_persist"`
+}
+
+// DefaultRPCConfig returns a default configuration for the JSON-RPC server
+func DefaultRPCConfig() *RPCConfig {
+	return &RPCConfig{
+		ListenAddress:      "tcp://127.0.0.1:26657",
+		MaxOpenConnections: 900,
+		MaxBodyBytes:       int64(1000000), // 1MB
+		MaxHeaderBytes:     1 << 20,        // same as the net/http default
+		ReadTimeout:        10 * time.Second,
+		WriteTimeout:       10 * time.Second,
+
+		MaxSubscriptionClients:    100,
+		MaxSubscriptionsPerClient: 5,
+		SubscriptionBufferSize:    200,
+		EventPollInterval:         time.Second,
+		EventGapTimeout:           time.Minute,
+
+		Fallback:        false,
+		FallbackPersist: false,
+	}
+}
+
+// ValidateBasic performs basic validation for the
+// [rpc] config section
+func (cfg *RPCConfig) ValidateBasic() error {
+	if len(cfg.ListenAddress) <= 0 {
+		return fmt.Errorf("invalid listen address, cannot be blank, please ensure a value is set in the config")
+	}
+
+	if cfg.MaxOpenConnections < 0 {
+		return fmt.Errorf("invalid max open connections, it cannot be negative")
+	}
+
+	if cfg.MaxBodyBytes <= 0 {
+		return fmt.Errorf("invalid max body bytes, it must be greater than zero")
+	}
+
+	if cfg.MaxHeaderBytes <= 0 {
+		return fmt.Errorf("invalid max header bytes, it must be greater than zero")
+	}
+
+	if cfg.ReadTimeout <= 0 || cfg.WriteTimeout <= 0 {
+		return fmt.Errorf("invalid read or write timeout, it must be greater than zero")
+	}
+
+	if cfg.MaxSubscriptionClients < 0 || cfg.MaxSubscriptionsPerClient < 0 {
+		return fmt.Errorf("invalid max subscription clients or subscriptions per client, it cannot be negative")
+	}
+
+	if cfg.SubscriptionBufferSize <= 0 {
+		return fmt.Errorf("invalid subscription buffer size, it must be greater than zero")
+	}
+
+	if cfg.EventPollInterval <= 0 {
+		return fmt.Errorf("invalid event poll interval, it must be greater than zero")
+	}
+
+	if cfg.EventGapTimeout <= 0 {
+		return fmt.Errorf("invalid event gap timeout, it must be greater than zero")
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// GRPCServerConfig
+
+// GRPCServerConfig defines the configuration options for the gRPC server
+type GRPCServerConfig struct { //nolint: maligned
+	// TCP or UNIX socket address for the gRPC server to listen on, the
+	// server is disabled if empty
+	ListenAddress string `mapstructure:"listen_address"`
+}
+
+// ValidateBasic performs basic validation for the
+// [grpc_server] config section
+func (cfg *GRPCServerConfig) ValidateBasic() error {
+	if cfg.ListenAddress != "" && !strings.Contains(cfg.ListenAddress, "://") {
+		return fmt.Errorf("invalid listen address, it must include the tcp:// or unix:// prefix")
+	}
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// InstrumentationConfig
+
+// InstrumentationConfig defines the configuration for metrics reporting
+type InstrumentationConfig struct { //nolint: maligned
+	// When true, Prometheus metrics are served under /metrics on
+	// PrometheusListenAddr.
+	Prometheus bool `mapstructure:"prometheus"`
+	// Address to listen for Prometheus collector(s) connections
+	PrometheusListenAddr string `mapstructure:"prometheus_listen_addr"`
+	// Maximum number of simultaneous connections, 0 means unlimited
+	MaxOpenConnections int `mapstructure:"max_open_connections"`
+	// Instrumentation namespace
+	Namespace string `mapstructure:"namespace"`
+}
+
+// DefaultInstrumentationConfig returns a default configuration for metrics
+// reporting. The listen address differs from the node's default, so both can
+// run on the same host.
+func DefaultInstrumentationConfig() *InstrumentationConfig {
+	return &InstrumentationConfig{
+		Prometheus:           false,
+		PrometheusListenAddr: ":26670",
+		MaxOpenConnections:   3,
+		Namespace:            "rpc_companion",
+	}
+}
+
+// ValidateBasic performs basic validation for the
+// [instrumentation] config section
+func (cfg *InstrumentationConfig) ValidateBasic() error {
+	if cfg.MaxOpenConnections < 0 {
+		return fmt.Errorf("invalid max open connections, it cannot be negative")
+	}
+
+	if cfg.Prometheus && len(cfg.PrometheusListenAddr) <= 0 {
+		return fmt.Errorf("invalid prometheus listen address, cannot be blank when prometheus is enabled")
+	}
+
 	return nil
 }
 
 func LoadConfig(configPath string) (Config, error) {
-	config := Config{}
+	config := *DefaultConfig()
 	if configPath != "" {
 		p := filepath.Join(configPath)
 		filename := filepath.Base(p)
@@ -99,8 +527,8 @@ func LoadConfig(configPath string) (Config, error) {
 		if err != nil {
 			return config, fmt.Errorf("cannot unmarshall configuration file")
 		}
-		if err := config.GRPCClient.ValidateBasic(); err != nil {
-			return config, fmt.Errorf("error validating gRPC client configuration: %v", err)
+		if err := config.ValidateBasic(); err != nil {
+			return config, fmt.Errorf("error validating configuration: %v", err)
 		}
 		return config, nil
 	}
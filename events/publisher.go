@@ -0,0 +1,337 @@
+// Package events publishes the events of the newly ingested blocks to the
+// subscribers of the JSON-RPC and gRPC servers.
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	abci "github.com/cometbft/cometbft/abci/types"
+	"github.com/cometbft/cometbft/types"
+	"github.com/cometbft/rpc-companion/libs/pubsub"
+	"github.com/cometbft/rpc-companion/libs/service"
+	"github.com/cometbft/rpc-companion/storage"
+)
+
+// Number of heights checked at once when skipping the missing heights
+const maxGapHeights uint64 = 1000
+
+// Publisher publishes the NewBlock, NewBlockEvents and Tx events of the newly
+// ingested heights. The ingest service may run in another process, so the
+// storage is the source of the events: a height is published once its block
+// and its block results are both committed, in height order. A height missing
+// while higher heights are stored is skipped after the gap timeout, so a height
+// that is never stored doesn't stop the events.
+type Publisher struct {
+	service.BaseService
+	storage    storage.IStorage
+	eventBus   *pubsub.Server
+	interval   time.Duration
+	gapTimeout time.Duration
+	logger     slog.Logger
+	// Next height to publish, 0 until the first poll
+	next uint64
+	// The storage was empty at the first poll
+	empty bool
+	// When the next height was first found missing while higher heights are
+	// stored, zero if it is not
+	gapSince time.Time
+
+	// Canceled when the service stops, to abort the storage queries
+	cancel context.CancelFunc
+	// Closed when the polling loop returns
+	done chan struct{}
+}
+
+// NewPublisher creates a publisher polling the storage at the given interval,
+// and skipping the heights missing for the gap timeout
+func NewPublisher(logger slog.Logger, db storage.IStorage, interval time.Duration, gapTimeout time.Duration) *Publisher {
+	logger = *logger.With("service", "EventPublisher")
+
+	publisher := &Publisher{
+		storage:    db,
+		eventBus:   pubsub.NewServer(),
+		interval:   interval,
+		gapTimeout: gapTimeout,
+		logger:     logger,
+	}
+	publisher.BaseService = *service.NewBaseService(logger, "EventPublisher", publisher)
+	return publisher
+}
+
+// EventBus returns the server the events are published to
+func (p *Publisher) EventBus() *pubsub.Server {
+	return p.eventBus
+}
+
+func (p *Publisher) OnStart() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+	go p.run(ctx)
+	return nil
+}
+
+// OnStop stops polling the storage and waits for the polling loop to return
+func (p *Publisher) OnStop() {
+	p.cancel()
+	<-p.done
+}
+
+// run polls the storage for newly ingested heights until the context is
+// canceled
+func (p *Publisher) run(ctx context.Context) {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.publishStored(ctx); err != nil && ctx.Err() == nil {
+			p.logger.Error("Publish stored heights", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishStored publishes the events of the heights stored since the last
+// call. The heights stored before the first call are not published.
+func (p *Publisher) publishStored(ctx context.Context) error {
+	if p.next == 0 {
+		start, err := p.startHeight(ctx)
+		if err != nil || start == 0 {
+			return err
+		}
+		p.next = start
+	}
+
+	if err := p.publishContiguous(ctx); err != nil {
+		return err
+	}
+	skipped, err := p.skipGap(ctx)
+	if err != nil || !skipped {
+		return err
+	}
+	return p.publishContiguous(ctx)
+}
+
+// publishContiguous publishes the heights stored from the next height up to
+// the first missing one
+func (p *Publisher) publishContiguous(ctx context.Context) error {
+	blocksHeight, err := p.storage.GetContiguousHeight(ctx, p.next)
+	if err != nil {
+		return err
+	}
+	resultsHeight, err := p.storage.GetContiguousBlockResultsHeight(ctx, p.next)
+	if err != nil {
+		return err
+	}
+
+	for height := p.next; height <= min(blocksHeight, resultsHeight); height++ {
+		if err := p.publish(ctx, height); err != nil {
+			return err
+		}
+		p.next = height + 1
+		p.gapSince = time.Time{}
+	}
+	return nil
+}
+
+// skipGap moves the next height to publish to the first height stored above
+// it, once the next height has been missing for the gap timeout while higher
+// heights are stored. It reports whether heights were skipped.
+func (p *Publisher) skipGap(ctx context.Context) (bool, error) {
+	latest, err := p.storage.GetLatestHeight(ctx)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if latest <= p.next {
+		// The next height may be the one being ingested
+		p.gapSince = time.Time{}
+		return false, nil
+	}
+	if p.gapSince.IsZero() {
+		p.gapSince = time.Now()
+		return false, nil
+	}
+	if time.Since(p.gapSince) < p.gapTimeout {
+		return false, nil
+	}
+
+	to := min(latest, p.next+maxGapHeights-1)
+	missingBlocks, err := p.storage.GetMissingHeights(ctx, p.next, to)
+	if err != nil {
+		return false, err
+	}
+	missingResults, err := p.storage.GetMissingBlockResultsHeights(ctx, p.next, to)
+	if err != nil {
+		return false, err
+	}
+	missing := make(map[uint64]bool, len(missingBlocks)+len(missingResults))
+	for _, height := range append(missingBlocks, missingResults...) {
+		missing[height] = true
+	}
+
+	next := p.next
+	for next <= to && missing[next] {
+		next++
+	}
+	if next > to && to < latest {
+		// Every height checked is missing, the next ones are checked at the
+		// next poll
+		next = to + 1
+	} else if next > to {
+		// Only incomplete heights are stored above, e.g. being ingested
+		return false, nil
+	}
+
+	p.logger.Warn("Skip heights missing in the storage", "from", p.next, "to", next-1, "missing_since", p.gapSince)
+	p.next = next
+	p.gapSince = time.Time{}
+	return true, nil
+}
+
+// startHeight returns the first height to publish: the latest stored height
+// if its results are not stored yet, or the one after it. It returns 0 while
+// the storage is empty, and then the first height ingested.
+func (p *Publisher) startHeight(ctx context.Context) (uint64, error) {
+	if p.empty {
+		earliest, err := p.storage.GetEarliestHeight(ctx)
+		if errors.Is(err, storage.ErrNotFound) {
+			return 0, nil
+		}
+		return earliest, err
+	}
+
+	latest, err := p.storage.GetLatestHeight(ctx)
+	if errors.Is(err, storage.ErrNotFound) {
+		p.empty = true
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	resultsHeight, err := p.storage.GetContiguousBlockResultsHeight(ctx, latest)
+	if err != nil {
+		return 0, err
+	}
+	if resultsHeight == latest {
+		return latest + 1, nil
+	}
+	return latest, nil
+}
+
+// publish publishes the events of a height, in the order of CometBFT:
+// NewBlock, NewBlockEvents then a Tx event per transaction
+func (p *Publisher) publish(ctx context.Context, height uint64) error {
+	block, err := p.storage.GetBlock(ctx, height)
+	if err != nil {
+		return err
+	}
+	results, err := p.storage.GetBlockResults(ctx, height)
+	if err != nil {
+		return err
+	}
+	if block.Block == nil || block.BlockID == nil {
+		return fmt.Errorf("block at height %d is empty", height)
+	}
+
+	finalizeBlockEvents := make([]abci.Event, 0, len(results.FinalizeBlockEvents))
+	for _, event := range results.FinalizeBlockEvents {
+		if event != nil {
+			finalizeBlockEvents = append(finalizeBlockEvents, *event)
+		}
+	}
+	validatorUpdates := make([]abci.ValidatorUpdate, 0, len(results.ValidatorUpdates))
+	for _, update := range results.ValidatorUpdates {
+		if update != nil {
+			validatorUpdates = append(validatorUpdates, *update)
+		}
+	}
+
+	// NewBlock
+	events := stringifyEvents(finalizeBlockEvents)
+	events[types.EventTypeKey] = append(events[types.EventTypeKey], types.EventNewBlock)
+	p.eventBus.Publish(pubsub.Message{
+		Data: types.EventDataNewBlock{
+			Block:   block.Block,
+			BlockID: *block.BlockID,
+			ResultFinalizeBlock: abci.FinalizeBlockResponse{
+				Events:                finalizeBlockEvents,
+				TxResults:             results.TxResults,
+				ValidatorUpdates:      validatorUpdates,
+				ConsensusParamUpdates: results.ConsensusParamUpdates,
+				AppHash:               results.AppHash,
+			},
+		},
+		Events: events,
+	})
+
+	// NewBlockEvents
+	events = stringifyEvents(finalizeBlockEvents)
+	events[types.EventTypeKey] = append(events[types.EventTypeKey], types.EventNewBlockEvents)
+	events[types.BlockHeightKey] = append(events[types.BlockHeightKey], fmt.Sprint(height))
+	p.eventBus.Publish(pubsub.Message{
+		Data: types.EventDataNewBlockEvents{
+			Height: int64(height),
+			Events: finalizeBlockEvents,
+			NumTxs: int64(len(block.Block.Txs)),
+		},
+		Events: events,
+	})
+
+	// Tx
+	for i, tx := range block.Block.Txs {
+		if i >= len(results.TxResults) || results.TxResults[i] == nil {
+			p.logger.Error("Stored tx result is missing", "height", height, "index", i)
+			continue
+		}
+		events := stringifyEvents(results.TxResults[i].Events)
+		events[types.EventTypeKey] = append(events[types.EventTypeKey], types.EventTx)
+		events[types.TxHashKey] = append(events[types.TxHashKey], fmt.Sprintf("%X", tx.Hash()))
+		events[types.TxHeightKey] = append(events[types.TxHeightKey], fmt.Sprint(height))
+		p.eventBus.Publish(pubsub.Message{
+			Data: types.EventDataTx{TxResult: abci.TxResult{
+				Height: int64(height),
+				Index:  uint32(i),
+				Tx:     tx,
+				Result: *results.TxResults[i],
+			}},
+			Events: events,
+		})
+	}
+
+	p.logger.Debug("Published events", "height", height, "txs", len(block.Block.Txs))
+	return nil
+}
+
+// stringifyEvents maps the composite keys of the event attributes to their
+// values. As in CometBFT, every attribute can be subscribed to, not only the
+// indexed ones.
+func stringifyEvents(events []abci.Event) map[string][]string {
+	result := make(map[string][]string)
+	for _, event := range events {
+		if len(event.Type) == 0 {
+			continue
+		}
+		for _, attr := range event.Attributes {
+			if len(attr.Key) == 0 {
+				continue
+			}
+			compositeTag := event.Type + "." + attr.Key
+			result[compositeTag] = append(result[compositeTag], attr.Value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,180 @@
+package events
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/cometbft/cometbft/types"
+	"github.com/cometbft/rpc-companion/config"
+	"github.com/cometbft/rpc-companion/ingest"
+	"github.com/cometbft/rpc-companion/libs/query"
+	"github.com/cometbft/rpc-companion/storage"
+	"github.com/cometbft/rpc-companion/test/fakenode"
+	"github.com/stretchr/testify/require"
+)
+
+// testStorage is a memory storage filled with the heights of a fake node
+type testStorage struct {
+	*storage.MemoryStorage
+	fetcher *ingest.Fetcher
+}
+
+// newTestStorage returns a memory storage fetching from a fake node producing
+// the given number of blocks
+func newTestStorage(t *testing.T, produced int) *testStorage {
+	t.Helper()
+	node := fakenode.New("test-chain")
+	require.NoError(t, node.Start())
+	t.Cleanup(node.Stop)
+	node.ProduceBlocks(produced)
+
+	cfg := config.DefaultConfig()
+	cfg.GRPCClient.ListenAddress = node.Address()
+	cfg.GRPCClient.ListenAddressPrivileged = node.PrivilegedAddress()
+
+	db := storage.NewMemoryStorage()
+	fetcher, err := ingest.NewFetcher(testLogger(), cfg, db)
+	require.NoError(t, err)
+	t.Cleanup(func() { fetcher.Close() }) //nolint:errcheck
+	return &testStorage{MemoryStorage: db, fetcher: fetcher}
+}
+
+// storeBlock stores the block of the node at a height
+func (s *testStorage) storeBlock(t *testing.T, height uint64) {
+	t.Helper()
+	block, err := s.fetcher.GetBlock(context.Background(), int64(height))
+	require.NoError(t, err)
+	require.NoError(t, s.InsertBlock(context.Background(), height, block))
+}
+
+// storeBlockResults stores the block results of the node at a height
+func (s *testStorage) storeBlockResults(t *testing.T, height uint64) {
+	t.Helper()
+	blockResults, err := s.fetcher.GetBlockResults(context.Background(), int64(height))
+	require.NoError(t, err)
+	require.NoError(t, s.InsertBlockResults(context.Background(), height, blockResults))
+}
+
+// storeHeights stores the blocks and block results in the [from, to] range
+func (s *testStorage) storeHeights(t *testing.T, from uint64, to uint64) {
+	t.Helper()
+	for height := from; height <= to; height++ {
+		s.storeBlock(t, height)
+		s.storeBlockResults(t, height)
+	}
+}
+
+func testLogger() slog.Logger {
+	return *slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// startPublisher starts a publisher polling the storage every 10ms, and
+// returns the heights of its NewBlock events
+func startPublisher(t *testing.T, db storage.IStorage, gapTimeout time.Duration) <-chan int64 {
+	t.Helper()
+	publisher := NewPublisher(testLogger(), db, 10*time.Millisecond, gapTimeout)
+	sub, err := publisher.EventBus().Subscribe("test", query.MustCompile("tm.event = 'NewBlock'"), 100)
+	require.NoError(t, err)
+	require.NoError(t, publisher.Start())
+	t.Cleanup(func() { publisher.Stop() }) //nolint:errcheck
+
+	heights := make(chan int64, 100)
+	go func() {
+		for msg := range sub.Out() {
+			heights <- msg.Data.(types.EventDataNewBlock).Block.Height
+		}
+	}()
+	return heights
+}
+
+// requireHeights waits for the published heights, and checks that no other
+// height is published
+func requireHeights(t *testing.T, heights <-chan int64, want ...int64) {
+	t.Helper()
+	for _, height := range want {
+		select {
+		case got := <-heights:
+			require.Equal(t, height, got)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("height %d was not published", height)
+		}
+	}
+	select {
+	case got := <-heights:
+		t.Fatalf("unexpected height %d published", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	db := newTestStorage(t, 10)
+	db.storeHeights(t, 1, 2)
+	heights := startPublisher(t, db, time.Minute)
+
+	// The heights stored before the start are not published
+	requireHeights(t, heights)
+
+	// A height is published once its block and its block results are stored
+	db.storeBlock(t, 3)
+	requireHeights(t, heights)
+	db.storeBlockResults(t, 3)
+	requireHeights(t, heights, 3)
+
+	// The heights stored out of order are published in height order
+	db.storeHeights(t, 5, 5)
+	requireHeights(t, heights)
+	db.storeHeights(t, 4, 4)
+	requireHeights(t, heights, 4, 5)
+}
+
+func TestPublisherGap(t *testing.T) {
+	db := newTestStorage(t, 10)
+	db.storeHeights(t, 1, 2)
+	heights := startPublisher(t, db, 200*time.Millisecond)
+	requireHeights(t, heights)
+
+	// Height 3 is never stored, and height 4 only has its block: the next
+	// heights are published after the gap timeout
+	db.storeBlock(t, 4)
+	db.storeHeights(t, 5, 6)
+	requireHeights(t, heights, 5, 6)
+
+	// Height 4 is not published once skipped
+	db.storeBlockResults(t, 4)
+	db.storeHeights(t, 7, 7)
+	requireHeights(t, heights, 7)
+}
+
+func TestPublisherQuarantinedHeight(t *testing.T) {
+	db := newTestStorage(t, 10)
+	db.storeHeights(t, 1, 2)
+	heights := startPublisher(t, db, 200*time.Millisecond)
+	requireHeights(t, heights)
+
+	// The block at height 3 failed verification, its block results are stored
+	block, err := db.fetcher.GetBlock(context.Background(), 3)
+	require.NoError(t, err)
+	require.NoError(t, db.QuarantineHeight(context.Background(), storage.QuarantinedData{
+		Height: 3, Block: block, Check: "test", Reason: "tampered",
+	}))
+	db.storeBlockResults(t, 3)
+	db.storeHeights(t, 4, 5)
+	requireHeights(t, heights, 4, 5)
+}
+
+func TestPublisherLatestIncomplete(t *testing.T) {
+	db := newTestStorage(t, 10)
+	db.storeHeights(t, 1, 2)
+	heights := startPublisher(t, db, 50*time.Millisecond)
+	requireHeights(t, heights)
+
+	// The latest height waits for its block results, whatever the gap timeout
+	db.storeBlock(t, 3)
+	time.Sleep(200 * time.Millisecond)
+	requireHeights(t, heights)
+	db.storeBlockResults(t, 3)
+	requireHeights(t, heights, 3)
+}
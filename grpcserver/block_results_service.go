@@ -0,0 +1,98 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	brs "github.com/cometbft/cometbft/api/cometbft/services/block_results/v1"
+	"github.com/cometbft/rpc-companion/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// blockResultsService implements the CometBFT BlockResultsService
+type blockResultsService struct {
+	brs.UnimplementedBlockResultsServiceServer
+	storage storage.IStorage
+	logger  slog.Logger
+}
+
+// GetBlockResults returns the block results stored at a height
+func (s *blockResultsService) GetBlockResults(ctx context.Context, req *brs.GetBlockResultsRequest) (*brs.GetBlockResultsResponse, error) {
+	if err := validateHeight(ctx, s.logger, s.storage, req.Height); err != nil {
+		return nil, err
+	}
+	results, err := s.storage.GetBlockResults(ctx, uint64(req.Height))
+	if err != nil {
+		return nil, storageError(s.logger, "GetBlockResults", err, req.Height)
+	}
+	return &brs.GetBlockResultsResponse{
+		Height:                results.Height,
+		TxResults:             results.TxResults,
+		FinalizeBlockEvents:   results.FinalizeBlockEvents,
+		ValidatorUpdates:      results.ValidatorUpdates,
+		ConsensusParamUpdates: results.ConsensusParamUpdates,
+		AppHash:               results.AppHash,
+	}, nil
+}
+
+// GetLatestBlockResults returns the block results of the latest stored block
+func (s *blockResultsService) GetLatestBlockResults(ctx context.Context, _ *brs.GetLatestBlockResultsRequest) (*brs.GetLatestBlockResultsResponse, error) {
+	height, err := s.storage.GetLatestHeight(ctx)
+	if err != nil {
+		return nil, storageError(s.logger, "GetLatestHeight", err, 0)
+	}
+	results, err := s.storage.GetBlockResults(ctx, height)
+	if err != nil {
+		return nil, storageError(s.logger, "GetBlockResults", err, int64(height))
+	}
+	return &brs.GetLatestBlockResultsResponse{
+		Height:                results.Height,
+		TxResults:             results.TxResults,
+		FinalizeBlockEvents:   results.FinalizeBlockEvents,
+		ValidatorUpdates:      results.ValidatorUpdates,
+		ConsensusParamUpdates: results.ConsensusParamUpdates,
+		AppHash:               results.AppHash,
+	}, nil
+}
+
+// validateHeight returns an InvalidArgument error if the height is out of the
+// stored range, as the node does for the heights it doesn't have
+func validateHeight(ctx context.Context, logger slog.Logger, db storage.IStorage, height int64) error {
+	if height <= 0 {
+		return status.Error(codes.InvalidArgument, "height cannot be zero or negative")
+	}
+	earliest, err := db.GetEarliestHeight(ctx)
+	if err != nil {
+		return storageError(logger, "GetEarliestHeight", err, 0)
+	}
+	latest, err := db.GetLatestHeight(ctx)
+	if err != nil {
+		return storageError(logger, "GetLatestHeight", err, 0)
+	}
+
+	switch {
+	case height < int64(earliest):
+		return status.Errorf(codes.InvalidArgument, "requested height %d is below base height %d", height, earliest)
+	case height > int64(latest):
+		return status.Errorf(codes.InvalidArgument, "requested height %d is higher than latest height %d", height, latest)
+	}
+	return nil
+}
+
+// storageError maps a storage error to a gRPC status. The details of
+// unexpected errors are logged but not returned.
+func storageError(logger slog.Logger, method string, err error, height int64) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		if height == 0 {
+			return status.Error(codes.NotFound, "no block data yet")
+		}
+		return status.Errorf(codes.NotFound, "data at height %d not found", height)
+	}
+	logger.Error("Storage", "method", method, "error", err, "height", height)
+	return status.Error(codes.Internal, "internal server error")
+}
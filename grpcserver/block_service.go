@@ -0,0 +1,124 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+
+	blocksvc "github.com/cometbft/cometbft/api/cometbft/services/block/v1"
+	ptypes "github.com/cometbft/cometbft/api/cometbft/types/v1"
+	"github.com/cometbft/cometbft/types"
+	"github.com/cometbft/rpc-companion/libs/pubsub"
+	"github.com/cometbft/rpc-companion/libs/query"
+	"github.com/cometbft/rpc-companion/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Number of heights buffered per GetLatestHeight stream, a stream that falls
+// behind is ended and the client is expected to reconnect
+const streamBufferSize = 100
+
+// Sequence of the GetLatestHeight streams, used as their subscriber ID
+var streamSeq atomic.Uint64
+
+// blockService implements the CometBFT BlockService
+type blockService struct {
+	blocksvc.UnimplementedBlockServiceServer
+	storage  storage.IStorage
+	eventBus *pubsub.Server
+	logger   slog.Logger
+}
+
+// GetByHeight returns the block stored at a height
+func (s *blockService) GetByHeight(ctx context.Context, req *blocksvc.GetByHeightRequest) (*blocksvc.GetByHeightResponse, error) {
+	if err := validateHeight(ctx, s.logger, s.storage, req.Height); err != nil {
+		return nil, err
+	}
+	blockID, block, err := s.getBlock(ctx, req.Height)
+	if err != nil {
+		return nil, err
+	}
+	return &blocksvc.GetByHeightResponse{
+		BlockId: blockID,
+		Block:   block,
+	}, nil
+}
+
+// GetLatest returns the latest stored block
+func (s *blockService) GetLatest(ctx context.Context, _ *blocksvc.GetLatestRequest) (*blocksvc.GetLatestResponse, error) {
+	height, err := s.storage.GetLatestHeight(ctx)
+	if err != nil {
+		return nil, storageError(s.logger, "GetLatestHeight", err, 0)
+	}
+	blockID, block, err := s.getBlock(ctx, int64(height))
+	if err != nil {
+		return nil, err
+	}
+	return &blocksvc.GetLatestResponse{
+		BlockId: blockID,
+		Block:   block,
+	}, nil
+}
+
+// GetLatestHeight streams the heights as they are ingested. As for the events
+// of the JSON-RPC server, a height is streamed once its block and its block
+// results are both stored, so the clients can fetch both.
+func (s *blockService) GetLatestHeight(_ *blocksvc.GetLatestHeightRequest, stream blocksvc.BlockService_GetLatestHeightServer) error {
+	logger := *s.logger.With("method", "GetLatestHeight")
+
+	subscriberID := fmt.Sprintf("grpc-stream-%d", streamSeq.Add(1))
+	sub, err := s.eventBus.Subscribe(subscriberID, query.MustCompile(fmt.Sprintf("%s = '%s'", types.EventTypeKey, types.EventNewBlock)), streamBufferSize)
+	if err != nil {
+		logger.Error("Subscribe to new block events", "error", err)
+		return status.Error(codes.Internal, "cannot subscribe to new block events")
+	}
+	defer func() {
+		if err := s.eventBus.UnsubscribeAll(subscriberID); err != nil && !errors.Is(err, pubsub.ErrSubscriptionNotFound) {
+			logger.Error("Unsubscribe from new block events", "error", err)
+		}
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case msg := <-sub.Out():
+			data, ok := msg.Data.(types.EventDataNewBlock)
+			if !ok {
+				logger.Error("Unexpected event type", "type", fmt.Sprintf("%T", msg.Data))
+				return status.Error(codes.Internal, "internal server error")
+			}
+			if err := stream.Send(&blocksvc.GetLatestHeightResponse{Height: data.Block.Height}); err != nil {
+				logger.Error("Send height", "error", err, "height", data.Block.Height)
+				return status.Error(codes.Unavailable, "cannot send stream response")
+			}
+		case <-sub.Canceled():
+			logger.Info("Subscription canceled", "reason", sub.Err())
+			return status.Errorf(codes.Canceled, "subscription canceled: %s", sub.Err())
+		}
+	}
+}
+
+// getBlock returns the block stored at a height and its ID in their protobuf
+// representation
+func (s *blockService) getBlock(ctx context.Context, height int64) (*ptypes.BlockID, *ptypes.Block, error) {
+	block, err := s.storage.GetBlock(ctx, uint64(height))
+	if err != nil {
+		return nil, nil, storageError(s.logger, "GetBlock", err, height)
+	}
+	if block.Block == nil || block.BlockID == nil {
+		s.logger.Error("Stored block is empty", "height", height)
+		return nil, nil, status.Error(codes.Internal, "internal server error")
+	}
+
+	blockProto, err := block.Block.ToProto()
+	if err != nil {
+		s.logger.Error("Convert block to protobuf", "error", err, "height", height)
+		return nil, nil, status.Error(codes.Internal, "internal server error")
+	}
+	blockIDProto := block.BlockID.ToProto()
+	return &blockIDProto, blockProto, nil
+}
@@ -0,0 +1,105 @@
+// Package grpcserver serves the CometBFT gRPC BlockService and
+// BlockResultsService from the storage, so the tools written against the
+// CometBFT gRPC client can use the companion as a data source, including
+// another companion.
+package grpcserver
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+
+	blocksvc "github.com/cometbft/cometbft/api/cometbft/services/block/v1"
+	brs "github.com/cometbft/cometbft/api/cometbft/services/block_results/v1"
+	"github.com/cometbft/rpc-companion/config"
+	"github.com/cometbft/rpc-companion/libs/pubsub"
+	"github.com/cometbft/rpc-companion/libs/service"
+	"github.com/cometbft/rpc-companion/storage"
+	"google.golang.org/grpc"
+)
+
+// Server serves the CometBFT gRPC services from the storage
+type Server struct {
+	service.BaseService
+	config   *config.Config
+	storage  storage.IStorage
+	eventBus *pubsub.Server
+	logger   slog.Logger
+	server   *grpc.Server
+	listener net.Listener
+}
+
+func NewServer(
+	logger slog.Logger,
+	cfg *config.Config,
+	db storage.IStorage,
+	eventBus *pubsub.Server,
+) *Server {
+	logger = *logger.With("service", "gRPC")
+
+	server := &Server{
+		config:   cfg,
+		storage:  db,
+		eventBus: eventBus,
+		logger:   logger,
+	}
+	server.BaseService = *service.NewBaseService(logger, "gRPC", server)
+	return server
+}
+
+func (s *Server) OnStart() error {
+	logger := *s.logger.With("method", "OnStart")
+
+	listener, err := listen(s.config.GRPCServer.ListenAddress)
+	if err != nil {
+		logger.Error("Listen", "error", err, "address", s.config.GRPCServer.ListenAddress)
+		return fmt.Errorf("error listening on %s", s.config.GRPCServer.ListenAddress)
+	}
+	s.listener = listener
+
+	s.server = grpc.NewServer()
+	blocksvc.RegisterBlockServiceServer(s.server, &blockService{
+		storage:  s.storage,
+		eventBus: s.eventBus,
+		logger:   *s.logger.With("module", "BlockService"),
+	})
+	brs.RegisterBlockResultsServiceServer(s.server, &blockResultsService{
+		storage: s.storage,
+		logger:  *s.logger.With("module", "BlockResultsService"),
+	})
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil {
+			logger.Error("Serve", "error", err)
+		}
+	}()
+
+	logger.Info("Serving gRPC", "address", listener.Addr().String())
+	return nil
+}
+
+func (s *Server) OnStop() {
+	if s.server != nil {
+		// Ends the GetLatestHeight streams, the clients reconnect
+		s.server.Stop()
+	}
+	s.BaseService.OnStop()
+}
+
+// Address returns the address the server is listening on
+func (s *Server) Address() string {
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// listen listens on a fully formed address, e.g. tcp://127.0.0.1:26090
+func listen(addr string) (net.Listener, error) {
+	protocol, address, found := strings.Cut(addr, "://")
+	if !found {
+		return nil, fmt.Errorf("invalid listening address %s (use fully formed addresses, including the tcp:// or unix:// prefix)", addr)
+	}
+	return net.Listen(protocol, address)
+}
@@ -0,0 +1,193 @@
+package grpcserver
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	blocksvc "github.com/cometbft/cometbft/api/cometbft/services/block/v1"
+	brs "github.com/cometbft/cometbft/api/cometbft/services/block_results/v1"
+	"github.com/cometbft/cometbft/types"
+	"github.com/cometbft/rpc-companion/config"
+	"github.com/cometbft/rpc-companion/ingest"
+	"github.com/cometbft/rpc-companion/libs/pubsub"
+	"github.com/cometbft/rpc-companion/storage"
+	"github.com/cometbft/rpc-companion/test/fakenode"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testServer serves the services in memory
+type testServer struct {
+	blocks   blocksvc.BlockServiceClient
+	results  brs.BlockResultsServiceClient
+	eventBus *pubsub.Server
+}
+
+// newTestServer serves the services from a memory storage holding the heights
+// in the [from, to] range of a fake node, over an in-memory connection
+func newTestServer(t *testing.T, from uint64, to uint64) *testServer {
+	t.Helper()
+	node := fakenode.New("test-chain")
+	require.NoError(t, node.Start())
+	t.Cleanup(node.Stop)
+	node.ProduceBlocks(10)
+
+	cfg := config.DefaultConfig()
+	cfg.GRPCClient.ListenAddress = node.Address()
+	cfg.GRPCClient.ListenAddressPrivileged = node.PrivilegedAddress()
+
+	logger := *slog.New(slog.NewTextHandler(io.Discard, nil))
+	db := storage.NewMemoryStorage()
+	fetcher, err := ingest.NewFetcher(logger, cfg, db)
+	require.NoError(t, err)
+	t.Cleanup(func() { fetcher.Close() }) //nolint:errcheck
+
+	ctx := context.Background()
+	for height := from; height <= to && height > 0; height++ {
+		block, err := fetcher.GetBlock(ctx, int64(height))
+		require.NoError(t, err)
+		blockResults, err := fetcher.GetBlockResults(ctx, int64(height))
+		require.NoError(t, err)
+		require.NoError(t, db.InsertHeight(ctx, height, block, blockResults))
+	}
+
+	eventBus := pubsub.NewServer()
+	server := grpc.NewServer()
+	blocksvc.RegisterBlockServiceServer(server, &blockService{storage: db, eventBus: eventBus, logger: logger})
+	brs.RegisterBlockResultsServiceServer(server, &blockResultsService{storage: db, logger: logger})
+
+	listener := bufconn.Listen(1024 * 1024)
+	go server.Serve(listener) //nolint:errcheck
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() }) //nolint:errcheck
+
+	return &testServer{
+		blocks:   blocksvc.NewBlockServiceClient(conn),
+		results:  brs.NewBlockResultsServiceClient(conn),
+		eventBus: eventBus,
+	}
+}
+
+// requireCode checks the gRPC status code of an error
+func requireCode(t *testing.T, code codes.Code, err error) {
+	t.Helper()
+	require.Error(t, err)
+	require.Equal(t, code, status.Code(err), err.Error())
+}
+
+func TestGetByHeight(t *testing.T) {
+	testCases := []struct {
+		name   string
+		height int64
+		code   codes.Code
+	}{
+		{name: "stored", height: 5, code: codes.OK},
+		{name: "zero", height: 0, code: codes.InvalidArgument},
+		{name: "below earliest", height: 2, code: codes.InvalidArgument},
+		{name: "above latest", height: 9, code: codes.InvalidArgument},
+	}
+	server := newTestServer(t, 3, 8)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := server.blocks.GetByHeight(context.Background(), &blocksvc.GetByHeightRequest{Height: tc.height})
+			if tc.code != codes.OK {
+				requireCode(t, tc.code, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.height, res.Block.Header.Height)
+			block, err := types.BlockFromProto(res.Block)
+			require.NoError(t, err)
+			require.Equal(t, []byte(block.Hash()), res.BlockId.Hash)
+		})
+	}
+}
+
+func TestGetBlockResults(t *testing.T) {
+	server := newTestServer(t, 3, 8)
+
+	res, err := server.results.GetBlockResults(context.Background(), &brs.GetBlockResultsRequest{Height: 4})
+	require.NoError(t, err)
+	require.Equal(t, int64(4), res.Height)
+	require.Len(t, res.TxResults, 1)
+	require.Equal(t, []byte("4"), res.TxResults[0].Data)
+
+	_, err = server.results.GetBlockResults(context.Background(), &brs.GetBlockResultsRequest{Height: 9})
+	requireCode(t, codes.InvalidArgument, err)
+}
+
+func TestGetLatest(t *testing.T) {
+	server := newTestServer(t, 3, 8)
+
+	block, err := server.blocks.GetLatest(context.Background(), &blocksvc.GetLatestRequest{})
+	require.NoError(t, err)
+	require.Equal(t, int64(8), block.Block.Header.Height)
+
+	results, err := server.results.GetLatestBlockResults(context.Background(), &brs.GetLatestBlockResultsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, int64(8), results.Height)
+}
+
+func TestNotFound(t *testing.T) {
+	server := newTestServer(t, 0, 0)
+
+	_, err := server.blocks.GetLatest(context.Background(), &blocksvc.GetLatestRequest{})
+	requireCode(t, codes.NotFound, err)
+	_, err = server.blocks.GetByHeight(context.Background(), &blocksvc.GetByHeightRequest{Height: 1})
+	requireCode(t, codes.NotFound, err)
+	_, err = server.results.GetLatestBlockResults(context.Background(), &brs.GetLatestBlockResultsRequest{})
+	requireCode(t, codes.NotFound, err)
+	_, err = server.results.GetBlockResults(context.Background(), &brs.GetBlockResultsRequest{Height: 1})
+	requireCode(t, codes.NotFound, err)
+}
+
+func TestGetLatestHeight(t *testing.T) {
+	server := newTestServer(t, 3, 8)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := server.blocks.GetLatestHeight(ctx, &blocksvc.GetLatestHeightRequest{})
+	require.NoError(t, err)
+	// The server subscribes the stream once it handles the call
+	require.Eventually(t, func() bool { return server.eventBus.NumClients() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	for height := int64(9); height <= 11; height++ {
+		server.eventBus.Publish(pubsub.Message{
+			Data:   types.EventDataNewBlock{Block: &types.Block{Header: types.Header{Height: height}}},
+			Events: map[string][]string{types.EventTypeKey: {types.EventNewBlock}},
+		})
+	}
+	// Not streamed
+	server.eventBus.Publish(pubsub.Message{
+		Data:   types.EventDataNewBlockEvents{Height: 12},
+		Events: map[string][]string{types.EventTypeKey: {types.EventNewBlockEvents}},
+	})
+
+	for height := int64(9); height <= 11; height++ {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, height, res.Height)
+	}
+
+	// The subscription is removed when the client cancels the stream
+	cancel()
+	_, err = stream.Recv()
+	requireCode(t, codes.Canceled, err)
+	require.Eventually(t, func() bool { return server.eventBus.NumClients() == 0 }, 5*time.Second, 10*time.Millisecond)
+}
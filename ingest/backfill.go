@@ -0,0 +1,288 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/cometbft/cometbft/rpc/grpc/client"
+	"github.com/cometbft/rpc-companion/storage"
+)
+
+var (
+	backfillChunkSize uint64 = 1000 // Number of heights checked against the storage at once
+)
+
+// GetLatestHeight waits for the next height streamed by the node and returns it
+func (f *Fetcher) GetLatestHeight(ctx context.Context) (int64, error) {
+	logger := *f.logger.With("method", "GetLatestHeight")
+
+	// Close the stream once the height is received
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	newHeightCh, err := f.GetNewBlockStream(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	latestHeightResult, ok := <-newHeightCh
+	if !ok {
+		logger.Error("Get latest height", "error", "stream closed")
+		return 0, fmt.Errorf("error getting latest height")
+	}
+	if latestHeightResult.Error != nil {
+		logger.Error("Get latest height", "error", latestHeightResult.Error)
+		return 0, fmt.Errorf("error getting latest height")
+	}
+	logger.Info("Get latest height", "height", latestHeightResult.Height)
+	return latestHeightResult.Height, nil
+}
+
+// GetBackfillLowerBounds returns the lowest block height and the lowest block
+// results height that can be backfilled, that is the lowest heights retained by
+// the node that the companion must store.
+func (f *Fetcher) GetBackfillLowerBounds(ctx context.Context) (uint64, uint64, error) {
+	blockFrom, err := f.retain.LowestBlockHeight(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	blockResultsFrom, err := f.retain.LowestBlockResultsHeight(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	return blockFrom, blockResultsFrom, nil
+}
+
+// Backfill fetches and stores the blocks and block results in the [from, to] range
+// that are missing in the storage. The range is processed in chunks, and the
+// retain heights are updated after each chunk so the node can prune the
+// backfilled data.
+//
+// Once the context is canceled, no more heights are fetched: the heights being
+// fetched are still stored, and an error is returned. The requests and queries
+// are only aborted when the Fetcher is closed.
+func (f *Fetcher) Backfill(ctx context.Context, from uint64, to uint64) error {
+	return f.backfillRange(ctx, from, from, to)
+}
+
+// BackfillRetained backfills the blocks and block results retained by the node
+// up to the given height, each from its own lower bound, see Backfill.
+func (f *Fetcher) BackfillRetained(ctx context.Context, to uint64) error {
+	blockFrom, blockResultsFrom, err := f.GetBackfillLowerBounds(ctx)
+	if err != nil {
+		return err
+	}
+	return f.backfillRange(ctx, blockFrom, blockResultsFrom, to)
+}
+
+// backfillRange backfills the blocks in the [blockFrom, to] range and the block
+// results in the [blockResultsFrom, to] range, see Backfill
+func (f *Fetcher) backfillRange(ctx context.Context, blockFrom uint64, blockResultsFrom uint64, to uint64) error {
+	logger := *f.logger.With("method", "Backfill")
+
+	from := min(blockFrom, blockResultsFrom)
+	if from > to {
+		logger.Info("Nothing to backfill", "from", from, "to", to)
+		return nil
+	}
+
+	logger.Info("Starting backfill", "block_from", blockFrom, "block_results_from", blockResultsFrom, "to", to)
+	failed := 0
+	for start := from; start <= to; start += backfillChunkSize {
+		end := min(start+backfillChunkSize-1, to)
+
+		var heights, resultsHeights []uint64
+		var err error
+		if blockFrom <= end {
+			heights, err = f.storage.GetMissingHeights(f.context, max(start, blockFrom), end)
+			if err != nil {
+				logger.Error("Get missing heights", "error", err, "from", start, "to", end)
+				return fmt.Errorf("error getting missing heights")
+			}
+			failed += backfillHeights(ctx, f, heights, f.fetchBlock, blockData)
+			if err := f.retain.UpdateBlockRetainHeight(f.context); err != nil {
+				logger.Error("Update block retain height", "error", err)
+			}
+		}
+
+		if blockResultsFrom <= end {
+			resultsHeights, err = f.storage.GetMissingBlockResultsHeights(f.context, max(start, blockResultsFrom), end)
+			if err != nil {
+				logger.Error("Get missing block results heights", "error", err, "from", start, "to", end)
+				return fmt.Errorf("error getting missing block results heights")
+			}
+			failed += backfillHeights(ctx, f, resultsHeights, f.fetchBlockResults, blockResultsData)
+			if err := f.retain.UpdateBlockResultsRetainHeight(f.context); err != nil {
+				logger.Error("Update block results retain height", "error", err)
+			}
+		}
+
+		if ctx.Err() != nil {
+			logger.Info("Backfill interrupted", "from", from, "to", to, "chunk_from", start)
+			return fmt.Errorf("backfill interrupted: %w", ctx.Err())
+		}
+
+		logger.Info("Backfilled chunk", "from", start, "to", end, "missing_blocks", len(heights), "missing_block_results", len(resultsHeights))
+	}
+
+	if failed > 0 {
+		logger.Error("Backfill incomplete", "from", from, "to", to, "failed", failed)
+		return fmt.Errorf("error backfilling %d heights", failed)
+	}
+	logger.Info("Backfill completed", "from", from, "to", to)
+	return nil
+}
+
+// backfillHeights fetches the data at the given heights using a pool of
+// concurrent workers, stores it in height order in batches, and returns the
+// number of heights that failed. No more heights are fetched once the context is
+// canceled.
+func backfillHeights[T any](
+	ctx context.Context,
+	f *Fetcher,
+	heights []uint64,
+	fetch func(ctx context.Context, height uint64) (T, error),
+	toData func(height uint64, data T) storage.HeightData,
+) int {
+	logger := *f.logger.With("method", "backfillHeights")
+
+	// Updated by the pool committer and by the batcher
+	var failed atomic.Int64
+	batcher := newHeightBatcher(f.config.Ingest.BatchSize, f.config.Ingest.BatchFlushInterval, func(batch []storage.HeightData) {
+		count, _ := f.insertBatch(f.context, batch)
+		failed.Add(int64(count))
+	})
+
+	fetchHeight := func(height uint64) (T, error) {
+		return fetch(f.context, height)
+	}
+	commit := func(height uint64, data T, err error) {
+		if err != nil {
+			logger.Error("Backfill height", "error", err, "height", height)
+			failed.Add(1)
+			return
+		}
+		batcher.Add(toData(height, data))
+	}
+
+	pool := newFetchPool(f.config.Ingest.BackfillConcurrency, f.config.Ingest.QueueSize, fetchHeight, commit,
+		f.metrics.FetchQueueDepth, f.metrics.CommitQueueDepth)
+	for _, height := range heights {
+		if ctx.Err() != nil {
+			break
+		}
+		pool.Submit(height)
+	}
+	pool.Close()
+	batcher.Flush()
+
+	return int(failed.Load())
+}
+
+// fetchBlock fetches the block at the given height
+func (f *Fetcher) fetchBlock(ctx context.Context, height uint64) (*client.Block, error) {
+	return f.GetBlock(ctx, int64(height))
+}
+
+// fetchBlockResults fetches the block results at the given height
+func (f *Fetcher) fetchBlockResults(ctx context.Context, height uint64) (*client.BlockResults, error) {
+	return f.GetBlockResults(ctx, int64(height))
+}
+
+func blockData(height uint64, block *client.Block) storage.HeightData {
+	return storage.HeightData{Height: height, Block: block}
+}
+
+func blockResultsData(height uint64, blockResults *client.BlockResults) storage.HeightData {
+	return storage.HeightData{Height: height, BlockResults: blockResults}
+}
+
+// requestRepair requests the heights missing in the storage up to the given
+// height to be backfilled, see repairMissing. It doesn't block.
+func (f *Fetcher) requestRepair(to uint64) {
+	f.repairMtx.Lock()
+	f.repairTo = max(f.repairTo, to)
+	f.repairMtx.Unlock()
+
+	select {
+	case f.repair <- struct{}{}:
+	default:
+		// A request is already pending
+	}
+}
+
+// repairMissing backfills the heights missing in the storage up to the
+// requested height, until the context is canceled. A failed backfill is retried
+// with an exponential backoff between reconnect_initial_delay and
+// reconnect_max_delay, so the heights the node failed to serve, e.g. while it
+// was unreachable, are eventually stored. The quarantined heights are missing
+// too: they are verified again on each retry, and stored once the node serves
+// valid data.
+func (f *Fetcher) repairMissing(ctx context.Context) {
+	logger := *f.logger.With("method", "repairMissing")
+
+	delay := f.config.Ingest.ReconnectInitialDelay
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-f.repair:
+		}
+
+		f.repairMtx.Lock()
+		to := f.repairTo
+		f.repairMtx.Unlock()
+
+		err := f.backfillMissing(ctx, to)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			delay = f.config.Ingest.ReconnectInitialDelay
+			continue
+		}
+
+		logger.Error("Backfill missing heights, retrying", "error", err, "to", to, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, f.config.Ingest.ReconnectMaxDelay)
+		f.requestRepair(to)
+	}
+}
+
+// backfillMissing backfills the heights missing in the storage up to the given
+// height. With the backfill enabled, they are checked from the lowest heights
+// retained by the node, otherwise from the first height streamed. The heights
+// contiguously stored from there are not checked again.
+func (f *Fetcher) backfillMissing(ctx context.Context, to uint64) error {
+	logger := *f.logger.With("method", "backfillMissing")
+
+	blockFrom := uint64(f.firstHeight.Load())
+	blockResultsFrom := blockFrom
+	if f.config.Ingest.Backfill {
+		var err error
+		if blockFrom, blockResultsFrom, err = f.GetBackfillLowerBounds(ctx); err != nil {
+			return err
+		}
+	}
+
+	stored, err := f.storage.GetContiguousHeight(ctx, blockFrom)
+	if err != nil {
+		logger.Error("Get contiguous height", "error", err, "from", blockFrom)
+		return fmt.Errorf("error getting contiguous stored height")
+	}
+	blockFrom = max(blockFrom, stored+1)
+	stored, err = f.storage.GetContiguousBlockResultsHeight(ctx, blockResultsFrom)
+	if err != nil {
+		logger.Error("Get contiguous block results height", "error", err, "from", blockResultsFrom)
+		return fmt.Errorf("error getting contiguous stored block results height")
+	}
+	blockResultsFrom = max(blockResultsFrom, stored+1)
+
+	return f.backfillRange(ctx, blockFrom, blockResultsFrom, to)
+}
@@ -0,0 +1,67 @@
+package ingest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cometbft/rpc-companion/storage"
+)
+
+// heightBatcher collects heights to store them together, once the batch is
+// full or the flush interval elapsed since the first height was added. The
+// batches are written one at a time, in the order the heights were added.
+type heightBatcher struct {
+	size     int
+	interval time.Duration
+	write    func(batch []storage.HeightData)
+
+	mtx   sync.Mutex
+	batch []storage.HeightData
+	timer *time.Timer
+
+	// Held while a batch is written
+	writeMtx sync.Mutex
+}
+
+func newHeightBatcher(size int, interval time.Duration, write func(batch []storage.HeightData)) *heightBatcher {
+	return &heightBatcher{
+		size:     size,
+		interval: interval,
+		write:    write,
+	}
+}
+
+// Add adds a height to the batch, writing it if it is full
+func (b *heightBatcher) Add(height storage.HeightData) {
+	b.mtx.Lock()
+	b.batch = append(b.batch, height)
+	full := len(b.batch) >= b.size
+	if !full && b.timer == nil {
+		b.timer = time.AfterFunc(b.interval, b.Flush)
+	}
+	b.mtx.Unlock()
+
+	if full {
+		b.Flush()
+	}
+}
+
+// Flush writes the heights of the batch, if any, and returns once they are
+// written
+func (b *heightBatcher) Flush() {
+	b.writeMtx.Lock()
+	defer b.writeMtx.Unlock()
+
+	b.mtx.Lock()
+	batch := b.batch
+	b.batch = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mtx.Unlock()
+
+	if len(batch) > 0 {
+		b.write(batch)
+	}
+}
@@ -2,61 +2,117 @@ package ingest
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cometbft/cometbft/rpc/grpc/client"
 	"github.com/cometbft/cometbft/rpc/grpc/client/privileged"
 	"github.com/cometbft/rpc-companion/config"
+	"github.com/cometbft/rpc-companion/libs/certs"
+	"github.com/cometbft/rpc-companion/libs/service"
 	"github.com/cometbft/rpc-companion/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 var (
 	requestDefaultTimeout = 10 * time.Second
-	blockQueue            = make(chan Job[client.Block]) // Queue to process blocks
 )
 
-type CometType interface {
-	client.Block | client.BlockResults
-}
-
 type Fetcher struct {
-	BaseService
+	service.BaseService
 	config   *config.Config
 	services *ServiceClient
-	context  context.Context
 	logger   slog.Logger
-	storage  *storage.Storage
+	storage  storage.IStorage
+	retain   *RetainHeightController
+	metrics  *Metrics
+
+	// Context of the requests to the node and of the storage queries, the
+	// in-flight ones are aborted once it is canceled
+	context context.Context
+	cancel  context.CancelFunc
+	// Stops streaming and backfilling new heights, the first step of a
+	// graceful stop
+	stopStream context.CancelFunc
+	// Tracks the stream and backfill goroutines
+	wg sync.WaitGroup
+
+	// Fetches the new heights concurrently and stores them in height order
+	pool *fetchPool[storage.HeightData]
+	// Stores the new heights in batches while the storage is behind the node
+	batcher *heightBatcher
+
+	// Last height streamed by the node, and whether the stream was re-opened
+	// since, only accessed by the stream goroutine
+	lastHeight  int64
+	reconnected bool
+	// First height streamed by the node, the lowest height checked for missing
+	// data when the backfill is disabled
+	firstHeight atomic.Int64
+
+	// Signals a request to backfill the heights missing in the storage up to
+	// repairTo, see repairMissing
+	repair    chan struct{}
+	repairMtx sync.Mutex
+	repairTo  uint64
+
+	// Latest heights streamed by the node and stored, to compute the lag
+	nodeHeight   atomic.Int64
+	storedHeight atomic.Int64
+
+	// State of the new block stream, for the health checks. The activity is
+	// the time of the last height streamed or of the last connection, in
+	// unix nanoseconds.
+	streamConnected atomic.Bool
+	streamActivity  atomic.Int64
 }
 
-type Job[T CometType] struct {
-	done      bool
-	cometType T
-}
+// FetcherOption sets an optional parameter on the Fetcher
+type FetcherOption func(*Fetcher)
 
-func NewJob[T CometType](cType T) Job[T] {
-	return Job[T]{
-		done:      false,
-		cometType: cType,
-	}
+// WithMetrics sets the metrics of the Fetcher
+func WithMetrics(metrics *Metrics) FetcherOption {
+	return func(f *Fetcher) { f.metrics = metrics }
 }
 
-func NewFetcher(logger slog.Logger, cfg *config.Config) (*Fetcher, error) {
+func NewFetcher(logger slog.Logger, cfg *config.Config, db storage.IStorage, options ...FetcherOption) (*Fetcher, error) {
 	logger = *logger.With("module", "Fetcher")
 
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Transport security of both connections
+	creds, err := transportCredentials(logger, cfg.GRPCClient.TLS)
+	if err != nil {
+		cancel()
+		logger.Error("Load TLS certificates", "error", err)
+		return nil, fmt.Errorf("error loading TLS certificates")
+	}
+	privCreds, err := transportCredentials(logger, cfg.GRPCClient.PrivilegedTLS)
+	if err != nil {
+		cancel()
+		logger.Error("Load privileged TLS certificates", "error", err)
+		return nil, fmt.Errorf("error loading privileged TLS certificates")
+	}
 
 	// Service
-	conn, err := client.New(ctx, cfg.GRPCClient.ListenAddress, client.WithBlockServiceEnabled(true), client.WithInsecure()) //TODO: In the future support secure connections
+	conn, err := client.New(ctx, cfg.GRPCClient.ListenAddress, client.WithBlockServiceEnabled(true), client.WithGRPCDialOption(grpc.WithTransportCredentials(creds)))
 	if err != nil {
+		cancel()
 		logger.Error("New client", "error", err)
 		return nil, fmt.Errorf("error creating new client")
 	}
 
 	// Privileged ServiceClient
-	privConn, err := privileged.New(ctx, cfg.GRPCClient.ListenAddressPrivileged, privileged.WithPruningServiceEnabled(true), privileged.WithInsecure())
+	privConn, err := privileged.New(ctx, cfg.GRPCClient.ListenAddressPrivileged, privileged.WithPruningServiceEnabled(true), privileged.WithGRPCDialOption(grpc.WithTransportCredentials(privCreds)))
 	if err != nil {
+		cancel()
+		conn.Close()
 		logger.Error("New privileged client", "error", err)
 		return nil, fmt.Errorf("error creating new privileged client")
 	}
@@ -67,32 +123,56 @@ func NewFetcher(logger slog.Logger, cfg *config.Config) (*Fetcher, error) {
 		privilegedClient: privConn,
 	}
 
-	// Storage
-	db, err := storage.NewStorage(cfg.Storage.Connection)
-	if err != nil {
-		logger.Error("New storage", "error", err)
-		return nil, fmt.Errorf("error creating new storage")
-	}
-
-	return &Fetcher{
+	fetcher := &Fetcher{
 		logger:   logger,
 		config:   cfg,
-		context:  ctx,
 		services: &services,
-		storage:  &db,
-	}, nil
+		storage:  db,
+		metrics:  NopMetrics(),
+		repair:   make(chan struct{}, 1),
+		context:  ctx,
+		cancel:   cancel,
+	}
+	for _, option := range options {
+		option(fetcher)
+	}
+	fetcher.retain = NewRetainHeightController(logger, fetcher)
+
+	return fetcher, nil
+}
+
+// transportCredentials returns the credentials of a connection to the node, the
+// certificates are reloaded when their files change
+func transportCredentials(logger slog.Logger, cfg *config.GRPCClientTLSConfig) (credentials.TransportCredentials, error) {
+	if !cfg.Enabled {
+		return insecure.NewCredentials(), nil
+	}
+	reloader, err := certs.NewReloader(logger, certs.Files{
+		CAFile:     cfg.CAFile,
+		CertFile:   cfg.CertFile,
+		KeyFile:    cfg.KeyFile,
+		ServerName: cfg.ServerName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reloader.TransportCredentials(), nil
 }
 
 //----------------------------------------------------------------------------------------------------------------------
 // Requests
 
 // GetBlock returns block at a specific height
-func (f *Fetcher) GetBlock(height int64) (*client.Block, error) {
+func (f *Fetcher) GetBlock(ctx context.Context, height int64) (*client.Block, error) {
 	logger := *f.logger.With("method", "GetBlock")
 
-	block, err := f.services.client.GetBlockByHeight(f.context, height)
+	ctx, cancel := context.WithTimeout(ctx, requestDefaultTimeout)
+	defer cancel()
+
+	block, err := f.services.client.GetBlockByHeight(ctx, height)
 	if err != nil {
 		logger.Error("Get block", "error", err, "height", height)
+		f.metrics.GRPCErrors.With("method", "GetBlock").Add(1)
 		return nil, fmt.Errorf("error getting block")
 	}
 	logger.Info("Get block", "height", height)
@@ -100,163 +180,465 @@ func (f *Fetcher) GetBlock(height int64) (*client.Block, error) {
 }
 
 // GetBlockResults returns block results at a specific height
-func (f *Fetcher) GetBlockResults(height int64) (*client.BlockResults, error) {
+func (f *Fetcher) GetBlockResults(ctx context.Context, height int64) (*client.BlockResults, error) {
 	logger := *f.logger.With("method", "GetBlockResults")
 
-	blockResults, err := f.services.client.GetBlockResults(f.context, height)
+	ctx, cancel := context.WithTimeout(ctx, requestDefaultTimeout)
+	defer cancel()
+
+	blockResults, err := f.services.client.GetBlockResults(ctx, height)
 	if err != nil {
 		logger.Error("Get block results", "error", err)
+		f.metrics.GRPCErrors.With("method", "GetBlockResults").Add(1)
 		return nil, fmt.Errorf("error getting block results")
 	}
 	logger.Info("Get block results", "height", height)
 	return blockResults, nil
 }
 
+// GetLatestBlockHeight returns the height of the latest block committed by the
+// node
+func (f *Fetcher) GetLatestBlockHeight(ctx context.Context) (uint64, error) {
+	logger := *f.logger.With("method", "GetLatestBlockHeight")
+
+	ctx, cancel := context.WithTimeout(ctx, requestDefaultTimeout)
+	defer cancel()
+
+	block, err := f.services.client.GetLatestBlock(ctx)
+	if err != nil || block.Block == nil {
+		logger.Error("Get latest block", "error", err)
+		f.metrics.GRPCErrors.With("method", "GetLatestBlock").Add(1)
+		return 0, fmt.Errorf("error getting the latest block")
+	}
+	logger.Debug("Get latest block", "height", block.Block.Height)
+	return uint64(block.Block.Height), nil
+}
+
 // GetBlockRetainHeight Get Block Retain Height value
-func (f *Fetcher) GetBlockRetainHeight() (privileged.RetainHeights, error) {
+func (f *Fetcher) GetBlockRetainHeight(ctx context.Context) (privileged.RetainHeights, error) {
 	logger := *f.logger.With("method", "GetBlockRetainHeight")
 
-	retainHeight, err := f.services.privilegedClient.GetBlockRetainHeight(f.context)
+	ctx, cancel := context.WithTimeout(ctx, requestDefaultTimeout)
+	defer cancel()
+
+	retainHeight, err := f.services.privilegedClient.GetBlockRetainHeight(ctx)
 	if err != nil {
 		logger.Error("Get block retain height", "error", err)
+		f.metrics.GRPCErrors.With("method", "GetBlockRetainHeight").Add(1)
 		return privileged.RetainHeights{
 			App:            0,
 			PruningService: 0,
 		}, fmt.Errorf("error getting the block retain height")
 	}
 	logger.Info("Get block retain height", "retain_height", retainHeight.PruningService, "app_retain_height", retainHeight.App)
+	f.metrics.BlockRetainHeight.Set(float64(retainHeight.PruningService))
 	return retainHeight, nil
 }
 
 // SetBlockRetainHeight Set Block Retain Height value
-func (f *Fetcher) SetBlockRetainHeight(height uint64) error {
+func (f *Fetcher) SetBlockRetainHeight(ctx context.Context, height uint64) error {
 	logger := *f.logger.With("method", "SetBlockRetainHeight")
 
-	err := f.services.privilegedClient.SetBlockRetainHeight(f.context, height)
+	ctx, cancel := context.WithTimeout(ctx, requestDefaultTimeout)
+	defer cancel()
+
+	err := f.services.privilegedClient.SetBlockRetainHeight(ctx, height)
 	if err != nil {
 		logger.Error("Set block retain height", "error", err)
+		f.metrics.GRPCErrors.With("method", "SetBlockRetainHeight").Add(1)
 		return fmt.Errorf("error setting the block retain height")
 	}
 	logger.Info("Set block retain height", "height", height)
+	f.metrics.BlockRetainHeight.Set(float64(height))
 	return nil
 }
 
 // GetBlockResultsRetainHeight Get Block Retain Height value
-func (f *Fetcher) GetBlockResultsRetainHeight() (uint64, error) {
+func (f *Fetcher) GetBlockResultsRetainHeight(ctx context.Context) (uint64, error) {
 	logger := *f.logger.With("method", "GetBlockResultsRetainHeight")
 
-	retainHeight, err := f.services.privilegedClient.GetBlockResultsRetainHeight(f.context)
+	ctx, cancel := context.WithTimeout(ctx, requestDefaultTimeout)
+	defer cancel()
+
+	retainHeight, err := f.services.privilegedClient.GetBlockResultsRetainHeight(ctx)
 	if err != nil {
 		logger.Error("Get block results retain height", "error", err)
+		f.metrics.GRPCErrors.With("method", "GetBlockResultsRetainHeight").Add(1)
 		return 0, fmt.Errorf("error getting block results retain height")
 	}
 	logger.Info("Get block results retain height", "height", retainHeight)
+	f.metrics.BlockResultsRetainHeight.Set(float64(retainHeight))
 	return retainHeight, nil
 }
 
 // SetBlockResultsRetainHeight Set Block Results Retain Height value
-func (f *Fetcher) SetBlockResultsRetainHeight(height uint64) error {
+func (f *Fetcher) SetBlockResultsRetainHeight(ctx context.Context, height uint64) error {
 	logger := *f.logger.With("method", "SetBlockResultsRetainHeight")
 
-	err := f.services.privilegedClient.SetBlockResultsRetainHeight(f.context, height)
+	ctx, cancel := context.WithTimeout(ctx, requestDefaultTimeout)
+	defer cancel()
+
+	err := f.services.privilegedClient.SetBlockResultsRetainHeight(ctx, height)
 	if err != nil {
 		logger.Error("Set block results retain height", "error", err)
+		f.metrics.GRPCErrors.With("method", "SetBlockResultsRetainHeight").Add(1)
 		return fmt.Errorf("error setting block results retain height")
 	}
 	logger.Info("Set block results retain height", "height", height)
+	f.metrics.BlockResultsRetainHeight.Set(float64(height))
 	return nil
 }
 
-func (f *Fetcher) GetNewBlockStream() (<-chan client.LatestHeightResult, error) {
+func (f *Fetcher) GetNewBlockStream(ctx context.Context) (<-chan client.LatestHeightResult, error) {
 	logger := *f.logger.With("method", "GetNewBlockStream")
 
-	newHeightCh, err := f.services.client.GetLatestHeight(f.context)
+	newHeightCh, err := f.services.client.GetLatestHeight(ctx)
 	if err != nil {
 		logger.Error("Get new block stream", "error", err)
+		f.metrics.GRPCErrors.With("method", "GetLatestHeight").Add(1)
 		return nil, fmt.Errorf("error get new block stream")
 	}
 	logger.Info("Get new block stream")
 	return newHeightCh, nil
 }
 
-// WatchNewBlock watch for new block events streamed from the cometBFT server
-func (f *Fetcher) WatchNewBlock() {
+// WatchNewBlock watch for new block events streamed from the cometBFT server,
+// until the context is canceled. If the stream is closed, it is re-opened with
+// an exponential backoff, and the heights missing in the storage are backfilled
+// once the stream is back.
+func (f *Fetcher) WatchNewBlock(ctx context.Context) {
 	logger := *f.logger.With("method", "WatchNewBlock")
-	ctx := context.Background()
-	newHeightCh, err := f.GetNewBlockStream()
-	if err != nil {
-		logger.Error("New block stream", "error", err)
-		ctx.Done()
-	} else {
-		logger.Info("Stream ready")
-	}
 
-	// Start the queue processor
-	f.ProcessBlockJob()
+	f.wg.Add(2)
+	go func() {
+		defer f.wg.Done()
+		f.repairMissing(ctx)
+	}()
+	go func(f *Fetcher, l slog.Logger) {
+		defer f.wg.Done()
 
-	go func(f *Fetcher, c context.Context, ch <-chan client.LatestHeightResult, l slog.Logger) {
+		delay := f.config.Ingest.ReconnectInitialDelay
 		for {
-			select {
-			case <-c.Done():
-				l.Info("Connection not available to stream new blocks")
-			case latestHeightResult, ok := <-ch:
-				if ok {
+			ch, err := f.GetNewBlockStream(ctx)
+			if err != nil {
+				l.Error("New block stream", "error", err)
+			} else {
+				l.Info("Stream ready")
+				f.reconnected = f.lastHeight > 0
+				f.streamConnected.Store(true)
+				f.streamActivity.Store(time.Now().UnixNano())
+				for latestHeightResult := range ch {
 					if latestHeightResult.Error != nil {
-						l.Error("Error in new block", "error", latestHeightResult.Error)
-					} else {
-						l.Info("New block", "height", latestHeightResult.Height)
-						block, err := f.GetBlock(latestHeightResult.Height)
-						if err != nil {
-							l.Error("Get block from storage", "error", err)
-						} else {
-							job := NewJob(*block)
-							blockQueue <- job
+						if ctx.Err() != nil {
+							break
 						}
+						l.Error("Error in new block", "error", latestHeightResult.Error)
+						f.metrics.GRPCErrors.With("method", "GetLatestHeight").Add(1)
+						continue
 					}
-				} else {
-					l.Info("New block streaming closed")
-					//TODO: Instead of returning and exit, keep trying to connect or listening ?
-					return
+					// The stream is healthy again
+					delay = f.config.Ingest.ReconnectInitialDelay
+					f.enqueueNewHeight(ctx, latestHeightResult.Height, l)
 				}
+				l.Info("New block streaming closed")
+				f.streamConnected.Store(false)
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+			l.Info("Reconnecting new block stream", "delay", delay)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
 			}
+			delay = min(delay*2, f.config.Ingest.ReconnectMaxDelay)
 		}
-	}(f, ctx, newHeightCh, logger)
+	}(f, logger)
 }
 
-func (f *Fetcher) ProcessBlockJob() {
-	logger := *f.logger.With("method", "ProcessBlockJob")
-	logger.Info("Starting Worker")
-	go func(fetcher *Fetcher) {
-		for {
-			job := <-blockQueue
-			fetcher.logger.Info("Processing job", "height", job.cometType.Block.Height)
-			err := fetcher.storage.InsertBlock(uint64(job.cometType.Block.Height), &job.cometType)
-			if err != nil {
-				logger.Error("Process block job", "error", err)
-			} else {
-				// Update block retain height if lower
-				// Get latest block retain height
-				rh, err := f.GetBlockRetainHeight()
-				if err != nil {
-					logger.Error("Get block retain height", "error", err)
-				} else {
-					if rh.PruningService < uint64(job.cometType.Block.Height) {
-						// This is a naive way of setting the retain height,
-						// ideally there should be a process that checks the storage
-						// to query inserted blocks and if there's a gap in the last
-						// inserted block and the block in the job. Setting to the job
-						// height will prune previous blocks that were not inserted yet.
-						err := f.SetBlockRetainHeight(uint64(job.cometType.Block.Height))
-						if err != nil {
-							logger.Error("Set block retain height", "error", err)
-						}
-					}
-				}
-				job.done = true
-				logger.Info("Processed block job", "height", job.cometType.Block.Height)
+// enqueueNewHeight enqueues the jobs for a height streamed by the node, along
+// with any height between the last streamed height and this one, which the
+// stream skipped. After the stream is re-opened, the heights below this one
+// that are missing in the storage are backfilled instead, as the heights
+// streamed before the disconnection may have failed to be fetched too. Nothing
+// is enqueued once the context is canceled.
+func (f *Fetcher) enqueueNewHeight(ctx context.Context, height int64, l slog.Logger) {
+	l.Info("New block", "height", height)
+	f.streamActivity.Store(time.Now().UnixNano())
+	f.setNodeHeight(height)
+
+	if f.lastHeight == 0 {
+		f.firstHeight.Store(height)
+		if f.config.Ingest.Backfill && height > 1 {
+			// Backfill the blocks produced before the service started
+			f.requestRepair(uint64(height - 1))
+		}
+	}
+	if f.reconnected {
+		f.reconnected = false
+		if height > 1 {
+			l.Info("Backfill missing heights after reconnecting", "to", height-1)
+			f.requestRepair(uint64(height - 1))
+			f.lastHeight = max(f.lastHeight, height-1)
+		}
+	}
+
+	lastHeight := f.lastHeight
+	if lastHeight >= height {
+		l.Info("Height already enqueued", "height", height, "last_height", lastHeight)
+		return
+	}
+	if lastHeight > 0 && height > lastHeight+1 {
+		l.Info("Enqueue missed heights", "from", lastHeight+1, "to", height-1)
+		for missed := lastHeight + 1; missed < height; missed++ {
+			if ctx.Err() != nil {
+				return
 			}
+			f.pool.Submit(uint64(missed))
+			f.lastHeight = missed
 		}
-	}(f)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	f.pool.Submit(uint64(height))
+	f.lastHeight = height
+}
+
+// fetchHeight fetches the block and block results at a given height, run by
+// the fetch pool workers. The data that couldn't be fetched is nil.
+func (f *Fetcher) fetchHeight(height uint64) (storage.HeightData, error) {
+	var errs []error
+	data := storage.HeightData{Height: height}
+	block, err := f.GetBlock(f.context, int64(height))
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		data.Block = block
+	}
+	blockResults, err := f.GetBlockResults(f.context, int64(height))
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		data.BlockResults = blockResults
+	}
+	return data, errors.Join(errs...)
+}
+
+// commitHeight stores the data fetched at a height in a single transaction,
+// called by the fetch pool in height order. The retain heights are then
+// updated, only up to the highest height that is contiguously stored, so that
+// any gap left by a failed fetch or insert is not pruned from the node before it
+// is backfilled: the failed heights are requested to the repair loop, see
+// repairMissing. While the storage is more than batch_lag heights behind the
+// node, the heights are stored in batches instead. The data failing verification
+// is quarantined instead of stored, and requested to the repair loop too.
+func (f *Fetcher) commitHeight(height uint64, data storage.HeightData, err error) {
+	logger := *f.logger.With("method", "commitHeight")
+
+	if f.context.Err() != nil {
+		logger.Info("Aborted height", "height", height)
+		return
+	}
+	if err != nil {
+		logger.Error("Fetch height", "error", err, "height", height)
+		f.requestRepair(height)
+	}
+	if data.Block == nil && data.BlockResults == nil {
+		return
+	}
+	if f.Lag() > f.config.Ingest.BatchLag {
+		f.batcher.Add(data)
+		return
+	}
+
+	// The batched heights are stored first, to keep the height order
+	f.batcher.Flush()
+	verified, quarantined, err := f.verifyHeights(f.context, []storage.HeightData{data})
+	if err != nil {
+		logger.Error("Verify height", "error", err, "height", height)
+		f.requestRepair(height)
+		return
+	}
+	if quarantined > 0 {
+		// Fetched again until the node serves data passing verification
+		f.requestRepair(height)
+	}
+	if len(verified) == 0 {
+		return
+	}
+	data = verified[0]
+	if err := f.insertHeight(f.context, data); err != nil {
+		logger.Error("Insert height", "error", err, "height", height)
+		f.requestRepair(height)
+		return
+	}
+	f.updateRetainHeights(logger, data.Block != nil, data.BlockResults != nil)
+	logger.Info("Committed height", "height", height)
+}
+
+// commitBatch stores a batch of heights, called by the batcher in height
+// order. The retain heights are then updated like after a single height, only
+// for the kinds of data stored, and the failed heights are requested to the
+// repair loop.
+func (f *Fetcher) commitBatch(batch []storage.HeightData) {
+	logger := *f.logger.With("method", "commitBatch")
+	from, to := batch[0].Height, batch[len(batch)-1].Height
+
+	if f.context.Err() != nil {
+		logger.Info("Aborted batch", "from", from, "to", to)
+		return
+	}
+	failed, stored := f.insertBatch(f.context, batch)
+	if failed > 0 {
+		f.requestRepair(to)
+	}
+	if !stored.block && !stored.blockResults {
+		return
+	}
+	f.updateRetainHeights(logger, stored.block, stored.blockResults)
+	logger.Info("Committed batch", "from", from, "to", to, "heights", len(batch), "failed", failed)
+}
+
+// storedKinds reports whether blocks or block results were stored
+type storedKinds struct {
+	block        bool
+	blockResults bool
+}
+
+// add records the kinds of data stored at a height
+func (k *storedKinds) add(data storage.HeightData) {
+	k.block = k.block || data.Block != nil
+	k.blockResults = k.blockResults || data.BlockResults != nil
+}
+
+// updateRetainHeights updates the block or block results retain heights
+func (f *Fetcher) updateRetainHeights(logger slog.Logger, block bool, blockResults bool) {
+	if block {
+		if err := f.retain.UpdateBlockRetainHeight(f.context); err != nil {
+			logger.Error("Update block retain height", "error", err)
+		}
+	}
+	if blockResults {
+		if err := f.retain.UpdateBlockResultsRetainHeight(f.context); err != nil {
+			logger.Error("Update block results retain height", "error", err)
+		}
+	}
+}
+
+// insertHeight stores the data fetched at a height, recording the insert
+// metrics
+func (f *Fetcher) insertHeight(ctx context.Context, data storage.HeightData) error {
+	start := time.Now()
+	err := f.storage.InsertHeight(ctx, data.Height, data.Block, data.BlockResults)
+	f.metrics.InsertDuration.With("type", "height").Observe(time.Since(start).Seconds())
+	if err == nil && data.Block != nil {
+		f.setStoredHeight(int64(data.Height))
+	}
+	return err
+}
+
+// StoreHeight verifies the data fetched at a height and stores it, like the
+// ingested heights. If the data fails verification, it is quarantined and the
+// returned error wraps ErrQuarantined.
+func (f *Fetcher) StoreHeight(ctx context.Context, data storage.HeightData) error {
+	verified, _, err := f.verifyHeights(ctx, []storage.HeightData{data})
+	if err != nil {
+		return fmt.Errorf("error verifying height %d: %w", data.Height, err)
+	}
+	if len(verified) == 0 || (data.Block != nil && verified[0].Block == nil) ||
+		(data.BlockResults != nil && verified[0].BlockResults == nil) {
+		return fmt.Errorf("height %d %w", data.Height, ErrQuarantined)
+	}
+	return f.insertHeight(ctx, verified[0])
+}
+
+// insertBatch verifies a batch of heights and stores them in a single
+// transaction, recording the insert metrics. It returns the number of heights
+// that couldn't be stored or were quarantined, and the kinds of data stored. If the batch can't be stored at
+// once, e.g. because a height is stored with different data, the heights are
+// stored one by one.
+func (f *Fetcher) insertBatch(ctx context.Context, batch []storage.HeightData) (int, storedKinds) {
+	logger := *f.logger.With("method", "insertBatch")
+
+	verified, quarantined, err := f.verifyHeights(ctx, batch)
+	if err != nil {
+		logger.Error("Verify batch", "error", err, "from", batch[0].Height, "to", batch[len(batch)-1].Height)
+		return len(batch), storedKinds{}
+	}
+	if len(verified) == 0 {
+		return quarantined, storedKinds{}
+	}
+	failed, stored := f.storeBatch(ctx, logger, verified)
+	return quarantined + failed, stored
+}
+
+// storeBatch stores a batch of verified heights, see insertBatch
+func (f *Fetcher) storeBatch(ctx context.Context, logger slog.Logger, batch []storage.HeightData) (int, storedKinds) {
+	var stored storedKinds
+	start := time.Now()
+	err := f.storage.InsertHeights(ctx, batch)
+	f.metrics.InsertDuration.With("type", "batch").Observe(time.Since(start).Seconds())
+	if err == nil {
+		for _, data := range batch {
+			if data.Block != nil {
+				f.setStoredHeight(int64(data.Height))
+			}
+			stored.add(data)
+		}
+		return 0, stored
+	}
+	if ctx.Err() != nil {
+		logger.Error("Insert batch", "error", err, "from", batch[0].Height, "to", batch[len(batch)-1].Height)
+		return len(batch), stored
+	}
+
+	logger.Error("Insert batch, inserting the heights one by one", "error", err,
+		"from", batch[0].Height, "to", batch[len(batch)-1].Height)
+	failed := 0
+	for _, data := range batch {
+		if err := f.insertHeight(ctx, data); err != nil {
+			logger.Error("Insert height", "error", err, "height", data.Height)
+			failed++
+			continue
+		}
+		stored.add(data)
+	}
+	return failed, stored
+}
+
+// setNodeHeight records the latest height streamed by the node
+func (f *Fetcher) setNodeHeight(height int64) {
+	f.nodeHeight.Store(height)
+	f.metrics.NodeHeight.Set(float64(height))
+	f.updateLag()
+}
+
+// setStoredHeight records a stored height if it is the highest one. Backfilled
+// heights are lower and don't change it.
+func (f *Fetcher) setStoredHeight(height int64) {
+	for {
+		stored := f.storedHeight.Load()
+		if height <= stored {
+			return
+		}
+		if f.storedHeight.CompareAndSwap(stored, height) {
+			break
+		}
+	}
+	f.metrics.StoredHeight.Set(float64(height))
+	f.updateLag()
+}
+
+// Lag returns the number of heights the storage is behind the node
+func (f *Fetcher) Lag() uint64 {
+	return uint64(max(f.nodeHeight.Load()-f.storedHeight.Load(), 0))
+}
+
+func (f *Fetcher) updateLag() {
+	f.metrics.Lag.Set(float64(f.Lag()))
 }
 
 //----------------------------------------------------------------------------------------------------------------------
@@ -264,12 +646,75 @@ func (f *Fetcher) ProcessBlockJob() {
 
 func (f *Fetcher) OnStart() error {
 	f.logger.Info("Service running")
+
+	// Catch up the stored height metric with data stored before a restart
+	if height, err := f.storage.GetLatestHeight(f.context); err == nil {
+		f.setStoredHeight(int64(height))
+	}
+
+	// Catch up the retain heights with data stored before a restart
+	f.flushRetainHeights(f.context)
+
 	// Stream new block events
-	f.WatchNewBlock()
+	streamCtx, stopStream := context.WithCancel(f.context)
+	f.stopStream = stopStream
+	f.pool = newFetchPool(f.config.Ingest.FetchWorkers, f.config.Ingest.QueueSize, f.fetchHeight, f.commitHeight,
+		f.metrics.FetchQueueDepth, f.metrics.CommitQueueDepth)
+	f.batcher = newHeightBatcher(f.config.Ingest.BatchSize, f.config.Ingest.BatchFlushInterval, f.commitBatch)
+	f.WatchNewBlock(streamCtx)
 
 	return nil
 }
 
+// OnStop stops the Fetcher gracefully: it stops streaming and backfilling new
+// heights, waits for the enqueued heights to be fetched and stored, flushes
+// the retain heights and closes the connections to the node. The enqueued
+// heights still in-flight after the shutdown timeout are aborted.
 func (f *Fetcher) OnStop() {
 	f.logger.Info("Service stopping")
+	f.stopStream()
+
+	drained := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		f.pool.Close()
+		f.batcher.Flush()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		f.logger.Info("Drained enqueued heights")
+	case <-time.After(f.config.Ingest.ShutdownTimeout):
+		f.logger.Error("Timeout draining enqueued heights, aborting them", "timeout", f.config.Ingest.ShutdownTimeout)
+		f.cancel()
+		<-drained
+	}
+
+	// The retain heights may not be up to date if the last updates failed or
+	// were aborted
+	ctx, cancel := context.WithTimeout(context.Background(), requestDefaultTimeout)
+	defer cancel()
+	f.flushRetainHeights(ctx)
+
+	if err := f.Close(); err != nil {
+		f.logger.Error("Close connections", "error", err)
+	}
+	f.logger.Info("Service stopped")
+}
+
+// Close aborts the in-flight requests and closes the connections to the node
+func (f *Fetcher) Close() error {
+	f.cancel()
+	return errors.Join(f.services.client.Close(), f.services.privilegedClient.Close())
+}
+
+// flushRetainHeights sets the retain heights of the node to the heights
+// contiguously stored
+func (f *Fetcher) flushRetainHeights(ctx context.Context) {
+	if err := f.retain.UpdateBlockRetainHeight(ctx); err != nil {
+		f.logger.Error("Update block retain height", "error", err)
+	}
+	if err := f.retain.UpdateBlockResultsRetainHeight(ctx); err != nil {
+		f.logger.Error("Update block results retain height", "error", err)
+	}
 }
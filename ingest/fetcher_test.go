@@ -0,0 +1,207 @@
+package ingest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ptypes "github.com/cometbft/cometbft/api/cometbft/types/v1"
+	"github.com/cometbft/rpc-companion/config"
+	"github.com/cometbft/rpc-companion/libs/service"
+	"github.com/cometbft/rpc-companion/storage"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/credentials"
+)
+
+// testCertificate is a certificate and its key, written in PEM files
+type testCertificate struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCertificate creates a certificate signed by the parent, or
+// self-signed if the parent is nil, and writes it in the directory
+func newTestCertificate(t *testing.T, dir string, name string, template *x509.Certificate, parent *testCertificate) *testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.Subject = pkix.Name{CommonName: name}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	c := &testCertificate{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".pem"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	require.NoError(t, os.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return c
+}
+
+func (c *testCertificate) tlsCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestIngestTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, dir, "ca", &x509.Certificate{
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	server := newTestCertificate(t, dir, "node", &x509.Certificate{
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	client := newTestCertificate(t, dir, "companion", &x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+
+	// The regular services use TLS, the privileged service mutual TLS
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	serverCert := server.tlsCertificate(t)
+	node := newTestNodeWithTLS(t,
+		credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{serverCert}, MinVersion: tls.VersionTLS12}),
+		credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientCAs:    clientCAs,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			MinVersion:   tls.VersionTLS12,
+		}),
+	)
+
+	cfg := testConfig(node)
+	cfg.GRPCClient.TLS = &config.GRPCClientTLSConfig{Enabled: true, CAFile: ca.certFile}
+	cfg.GRPCClient.PrivilegedTLS = &config.GRPCClientTLSConfig{
+		Enabled:  true,
+		CAFile:   ca.certFile,
+		CertFile: client.certFile,
+		KeyFile:  client.keyFile,
+	}
+	require.NoError(t, cfg.ValidateBasic())
+
+	s := startIngest(t, node, cfg)
+	latest := produceBlocks(t, node, s, 3)
+	requireStored(t, s.storage, 1, latest)
+	requireRetainHeights(t, node, latest, latest)
+
+	// The privileged service refuses a client without certificate
+	cfg.GRPCClient.PrivilegedTLS = &config.GRPCClientTLSConfig{Enabled: true, CAFile: ca.certFile}
+	logger := *slog.New(slog.NewTextHandler(io.Discard, nil))
+	f, err := NewFetcher(logger, &cfg, nil)
+	require.NoError(t, err)
+	f.BaseService = *service.NewBaseService(logger, "Fetcher", f)
+	defer f.Close()
+
+	ctx := context.Background()
+	require.NoError(t, f.PingNode(ctx))
+	require.Error(t, f.PingPrivilegedNode(ctx))
+}
+
+func TestFetcherStoreHeight(t *testing.T) {
+	node := newTestNode(t)
+	node.ProduceBlocks(6)
+	// The block ID doesn't match the tampered header
+	node.TamperBlock(6, func(block *ptypes.Block, _ *ptypes.BlockID) {
+		block.Header.ChainID = "tampered-chain"
+	})
+
+	cfg := testConfig(node)
+	db, err := storage.NewStorage(cfg.Storage)
+	require.NoError(t, err)
+	logger := *slog.New(slog.NewTextHandler(io.Discard, nil))
+	f, err := NewFetcher(logger, &cfg, db)
+	require.NoError(t, err)
+	defer f.Close()
+
+	ctx := context.Background()
+	for height, want := range map[int64]error{5: nil, 6: ErrQuarantined} {
+		block, err := f.GetBlock(ctx, height)
+		require.NoError(t, err)
+		err = f.StoreHeight(ctx, storage.HeightData{Height: uint64(height), Block: block})
+		if want == nil {
+			require.NoError(t, err)
+			_, err = db.GetBlock(ctx, uint64(height))
+			require.NoError(t, err)
+			continue
+		}
+		require.ErrorIs(t, err, want)
+		_, err = db.GetBlock(ctx, uint64(height))
+		require.ErrorIs(t, err, storage.ErrNotFound)
+	}
+}
+
+func TestFetcherRetryQuarantined(t *testing.T) {
+	node := newTestNode(t)
+	node.ProduceBlocks(6)
+	node.TamperBlock(6, func(block *ptypes.Block, _ *ptypes.BlockID) {
+		block.Header.ChainID = "tampered-chain"
+	})
+
+	cfg := testConfig(node)
+	db, err := storage.NewStorage(cfg.Storage)
+	require.NoError(t, err)
+	logger := *slog.New(slog.NewTextHandler(io.Discard, nil))
+	f, err := NewFetcher(logger, &cfg, db)
+	require.NoError(t, err)
+	defer f.Close()
+
+	ctx := context.Background()
+	block, err := f.GetBlock(ctx, 6)
+	require.NoError(t, err)
+	require.ErrorIs(t, f.StoreHeight(ctx, storage.HeightData{Height: 6, Block: block}), ErrQuarantined)
+
+	// The node still serves the tampered block
+	remaining, err := f.RetryQuarantined(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, remaining)
+	quarantined, err := db.GetQuarantined(ctx)
+	require.NoError(t, err)
+	require.Len(t, quarantined, 1)
+
+	// The block served once the node is fixed is stored
+	node.TamperBlock(6, func(block *ptypes.Block, _ *ptypes.BlockID) {
+		block.Header.ChainID = "test-chain"
+	})
+	remaining, err = f.RetryQuarantined(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, remaining)
+	_, err = db.GetBlock(ctx, 6)
+	require.NoError(t, err)
+	quarantined, err = db.GetQuarantined(ctx)
+	require.NoError(t, err)
+	require.Empty(t, quarantined)
+}
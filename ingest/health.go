@@ -0,0 +1,156 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// healthCheckTimeout bounds the time spent by each request of a health check
+const healthCheckTimeout = 5 * time.Second
+
+// healthResponse is the body of the health endpoints, the checks map the name
+// of each check to "ok" or its error
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// healthCheck is a named check of the service state
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// startHealthServer starts an HTTP server answering the /healthz and /readyz
+// endpoints on the configured address.
+//
+// The liveness endpoint, /healthz, fails if the service is stopped or the new
+// block stream is stuck, which a restart may fix. The readiness endpoint,
+// /readyz, also checks the storage, the gRPC connectivity to the node and the
+// ingestion lag.
+func (s *IngestService) startHealthServer() *http.Server {
+	live := []healthCheck{
+		{"service", s.checkRunning},
+		{"stream", s.checkStream},
+	}
+	ready := append(live,
+		healthCheck{"storage", s.storage.Ping},
+		healthCheck{"grpc", s.fetcher.PingNode},
+		healthCheck{"grpc_privileged", s.fetcher.PingPrivilegedNode},
+		healthCheck{"lag", s.checkLag},
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.healthHandler(live))
+	mux.HandleFunc("/readyz", s.healthHandler(ready))
+
+	srv := &http.Server{
+		Addr:              s.config.Ingest.HealthListenAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			// Error starting or closing listener
+			s.logger.Error("Health HTTP server ListenAndServe", "error", err)
+		}
+	}()
+	s.logger.Info("Serving health checks", "address", srv.Addr)
+	return srv
+}
+
+// healthHandler runs the checks and answers 200 if they all pass, or 503
+func (s *IngestService) healthHandler(checks []healthCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := healthResponse{Status: "ok", Checks: make(map[string]string, len(checks))}
+		code := http.StatusOK
+		for _, c := range checks {
+			if err := c.check(r.Context()); err != nil {
+				resp.Checks[c.name] = err.Error()
+				resp.Status = "error"
+				code = http.StatusServiceUnavailable
+			} else {
+				resp.Checks[c.name] = "ok"
+			}
+		}
+		if code != http.StatusOK {
+			s.logger.Info("Health check failed", "path", r.URL.Path, "checks", resp.Checks)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			s.logger.Error("Write health response", "error", err)
+		}
+	}
+}
+
+func (s *IngestService) checkRunning(_ context.Context) error {
+	if !s.IsRunning() || !s.fetcher.IsRunning() {
+		return fmt.Errorf("service is not running")
+	}
+	return nil
+}
+
+func (s *IngestService) checkStream(_ context.Context) error {
+	return s.fetcher.CheckStream(s.config.Ingest.StreamTimeout)
+}
+
+func (s *IngestService) checkLag(_ context.Context) error {
+	if lag := s.fetcher.Lag(); lag > s.config.Ingest.MaxLag {
+		return fmt.Errorf("storage is %d heights behind the node, the maximum is %d", lag, s.config.Ingest.MaxLag)
+	}
+	return nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Fetcher checks
+
+// CheckStream returns an error if the node didn't stream any height, nor the
+// stream reconnected, for longer than the timeout
+func (f *Fetcher) CheckStream(timeout time.Duration) error {
+	activity := f.streamActivity.Load()
+	if activity == 0 {
+		return fmt.Errorf("new block stream not opened yet")
+	}
+	idle := time.Since(time.Unix(0, activity))
+	if idle <= timeout {
+		return nil
+	}
+	if !f.streamConnected.Load() {
+		return fmt.Errorf("new block stream disconnected for %s", idle.Round(time.Second))
+	}
+	return fmt.Errorf("no height streamed for %s", idle.Round(time.Second))
+}
+
+// PingNode checks the regular gRPC endpoint of the node is reachable, by
+// requesting its version. A node with the version service disabled answers
+// Unimplemented, which still proves connectivity.
+func (f *Fetcher) PingNode(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	_, err := f.services.client.GetVersion(ctx)
+	if err != nil && status.Code(err) != codes.Unimplemented {
+		return fmt.Errorf("node gRPC endpoint is unreachable: %s", status.Code(err))
+	}
+	return nil
+}
+
+// PingPrivilegedNode checks the privileged gRPC endpoint of the node is
+// reachable, by requesting the block retain height
+func (f *Fetcher) PingPrivilegedNode(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	if _, err := f.services.privilegedClient.GetBlockRetainHeight(ctx); err != nil {
+		return fmt.Errorf("node privileged gRPC endpoint is unreachable: %s", status.Code(err))
+	}
+	return nil
+}
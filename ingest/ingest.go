@@ -1,20 +1,38 @@
 package ingest
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"time"
 
 	"github.com/cometbft/cometbft/rpc/grpc/client"
 	"github.com/cometbft/cometbft/rpc/grpc/client/privileged"
 	"github.com/cometbft/rpc-companion/config"
+	"github.com/cometbft/rpc-companion/libs/service"
+	"github.com/cometbft/rpc-companion/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// readHeaderTimeout bounds the time to read the headers of a metrics or health
+// request
+const readHeaderTimeout = 10 * time.Second
+
 // IngestService orchestrates the ingest services
 type IngestService struct {
-	BaseService
+	service.BaseService
 	config  *config.Config
 	fetcher *Fetcher
-	//storage storage.IStorage
+	storage storage.IStorage
+	logger  slog.Logger
+
+	// Serves the Prometheus metrics, nil if disabled
+	metricsServer *http.Server
+	// Serves the health checks, nil if disabled
+	healthServer *http.Server
 }
 
 // ServiceClient GRPC clients
@@ -29,38 +47,133 @@ func NewIngestService(
 ) (*IngestService, error) {
 	logger = *logger.With("service", "Ingest")
 
+	// Storage
+	db, err := storage.NewStorage(config.Storage)
+	if err != nil {
+		logger.Error("New storage", "error", err)
+		return nil, fmt.Errorf("error creating new storage")
+	}
+
+	// Schema
+	if err := PrepareSchema(context.Background(), logger, config.Storage, db); err != nil {
+		disconnectStorage(logger, db)
+		return nil, err
+	}
+
+	// Metrics
+	metrics := NopMetrics()
+	if config.Instrumentation.Prometheus {
+		metrics = PrometheusMetrics(config.Instrumentation.Namespace)
+	}
+
 	// Instantiate new fetcher (gRPC client)
-	fetcher, err := NewFetcher(logger, &config)
+	fetcher, err := NewFetcher(logger, &config, db, WithMetrics(metrics))
 	if err != nil {
 		logger.Error("Creating new fetcher", "error", err)
+		disconnectStorage(logger, db)
 		return nil, fmt.Errorf("error creating new fetcher")
 	}
 
 	// Configure Fetcher service
-	fetcher.BaseService = *NewBaseService(logger, "Fetcher", fetcher)
+	fetcher.BaseService = *service.NewBaseService(logger, "Fetcher", fetcher)
 
 	// Ingest Service
 	ingest := &IngestService{
 		config:  &config,
 		fetcher: fetcher,
-		//storage: &db,
+		storage: db,
+		logger:  logger,
 	}
 
-	ingest.BaseService = *NewBaseService(logger, "Ingest", ingest)
+	ingest.BaseService = *service.NewBaseService(logger, "Ingest", ingest)
 
 	return ingest, nil
 }
 
+// disconnectStorage disconnects the storage of a service that failed to be
+// created
+func disconnectStorage(logger slog.Logger, db storage.IStorage) {
+	if err := db.Disconnect(); err != nil {
+		logger.Error("Disconnect storage", "error", err)
+	}
+}
+
 func (s *IngestService) OnStart() error {
+	if s.config.Instrumentation.Prometheus {
+		s.metricsServer = s.startPrometheusServer()
+	}
+	if s.config.Ingest.HealthListenAddress != "" {
+		s.healthServer = s.startHealthServer()
+	}
 	if s.IsRunning() {
 		s.fetcher.Start()
 	}
 	return nil
 }
 
+// OnStop stops the fetcher, which drains the enqueued heights, then the HTTP
+// servers, and closes the storage
 func (s *IngestService) OnStop() {
 	if s.fetcher.IsRunning() {
 		s.fetcher.Stop()
 	}
+	if s.metricsServer != nil {
+		if err := s.metricsServer.Shutdown(context.Background()); err != nil {
+			s.logger.Error("Prometheus HTTP server Shutdown", "error", err)
+		}
+	}
+	if s.healthServer != nil {
+		if err := s.healthServer.Shutdown(context.Background()); err != nil {
+			s.logger.Error("Health HTTP server Shutdown", "error", err)
+		}
+	}
+	if err := s.storage.Disconnect(); err != nil {
+		s.logger.Error("Disconnect storage", "error", err)
+	}
 	s.BaseService.OnStop()
 }
+
+// startPrometheusServer starts a Prometheus HTTP server, listening for metrics
+// collectors on the configured address.
+func (s *IngestService) startPrometheusServer() *http.Server {
+	srv := &http.Server{
+		Addr: s.config.Instrumentation.PrometheusListenAddr,
+		Handler: promhttp.InstrumentMetricHandler(
+			prometheus.DefaultRegisterer, promhttp.HandlerFor(
+				prometheus.DefaultGatherer,
+				promhttp.HandlerOpts{MaxRequestsInFlight: s.config.Instrumentation.MaxOpenConnections},
+			),
+		),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+	go func() {
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			// Error starting or closing listener
+			s.logger.Error("Prometheus HTTP server ListenAndServe", "error", err)
+		}
+	}()
+	s.logger.Info("Serving Prometheus metrics", "address", srv.Addr)
+	return srv
+}
+
+// PrepareSchema applies the pending migrations if auto migrate is enabled, and
+// returns an error if the storage schema doesn't match this version.
+func PrepareSchema(ctx context.Context, logger slog.Logger, cfg *config.StorageConfig, db storage.IStorage) error {
+	if migrator, ok := db.(storage.Migrator); ok && cfg.AutoMigrate {
+		count, err := migrator.MigrateUp(ctx)
+		if err != nil {
+			logger.Error("Apply migrations", "error", err)
+			return fmt.Errorf("error applying storage migrations")
+		}
+		logger.Info("Applied migrations", "count", count)
+	}
+
+	if err := db.CheckSchema(ctx); err != nil {
+		logger.Error("Check storage schema", "error", err)
+		if errors.Is(err, storage.ErrSchemaOutdated) {
+			return fmt.Errorf("storage schema is outdated, run `rpc-companion storage migrate up`")
+		}
+		return fmt.Errorf("error checking storage schema")
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	brs "github.com/cometbft/cometbft/api/cometbft/services/block_results/v1"
+	ptypes "github.com/cometbft/cometbft/api/cometbft/types/v1"
+	"github.com/cometbft/rpc-companion/config"
+	"github.com/cometbft/rpc-companion/storage"
+	"github.com/cometbft/rpc-companion/test/fakenode"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/credentials"
+)
+
+const waitTimeout = 10 * time.Second
+
+// newTestNode starts a fake node, stopped at the end of the test
+func newTestNode(t *testing.T) *fakenode.Node {
+	t.Helper()
+	return newTestNodeWithTLS(t, nil, nil)
+}
+
+// newTestNodeWithTLS starts a fake node serving the regular and the privileged
+// services with the credentials, insecure if nil
+func newTestNodeWithTLS(t *testing.T, creds credentials.TransportCredentials, privilegedCreds credentials.TransportCredentials) *fakenode.Node {
+	t.Helper()
+	node := fakenode.New("test-chain")
+	node.SetTLS(creds, privilegedCreds)
+	require.NoError(t, node.Start())
+	t.Cleanup(node.Stop)
+	return node
+}
+
+// testConfig returns a configuration ingesting from the node in memory, with
+// short delays
+func testConfig(node *fakenode.Node) config.Config {
+	cfg := config.DefaultConfig()
+	cfg.GRPCClient.ListenAddress = node.Address()
+	cfg.GRPCClient.ListenAddressPrivileged = node.PrivilegedAddress()
+	cfg.Storage.Backend = config.StorageBackendMemory
+	cfg.Ingest.Backfill = false
+	cfg.Ingest.BatchFlushInterval = 10 * time.Millisecond
+	cfg.Ingest.ReconnectInitialDelay = 20 * time.Millisecond
+	cfg.Ingest.ReconnectMaxDelay = 100 * time.Millisecond
+	cfg.Ingest.ShutdownTimeout = 5 * time.Second
+	return *cfg
+}
+
+// startIngest starts the ingest service, stopped at the end of the test, and
+// waits for the new block stream
+func startIngest(t *testing.T, node *fakenode.Node, cfg config.Config) *IngestService {
+	t.Helper()
+	logger := *slog.New(slog.NewTextHandler(io.Discard, nil))
+	s, err := NewIngestService(logger, cfg)
+	require.NoError(t, err)
+	require.NoError(t, s.Start())
+	t.Cleanup(func() { s.Stop() }) //nolint:errcheck
+
+	require.Eventually(t, func() bool { return node.StreamCount() == 1 }, waitTimeout, 10*time.Millisecond)
+	return s
+}
+
+// produceBlocks commits the blocks one at a time, each once the fetcher
+// received the previous height. Like the node, the stream skips the heights
+// sent while its reader is busy, so another block is produced if a height is
+// not received. It returns the latest height.
+func produceBlocks(t *testing.T, node *fakenode.Node, s *IngestService, count int) uint64 {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		for attempt := 0; ; attempt++ {
+			require.Less(t, attempt, 10, "heights not received from the stream")
+			node.ProduceBlocks(1)
+			height := node.LatestHeight()
+			if eventually(time.Second, func() bool { return s.fetcher.nodeHeight.Load() >= height }) {
+				break
+			}
+		}
+	}
+	return uint64(node.LatestHeight())
+}
+
+// eventually reports whether the condition is met before the timeout
+func eventually(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// requireStored waits for the blocks and block results in the [from, to] range
+// to be stored
+func requireStored(t *testing.T, db storage.IStorage, from uint64, to uint64) {
+	t.Helper()
+	ctx := context.Background()
+	require.Eventually(t, func() bool {
+		blocks, err := db.GetContiguousHeight(ctx, from)
+		require.NoError(t, err)
+		blockResults, err := db.GetContiguousBlockResultsHeight(ctx, from)
+		require.NoError(t, err)
+		return blocks >= to && blockResults >= to
+	}, waitTimeout, 10*time.Millisecond)
+}
+
+// requireRetainHeights waits for the node retain heights
+func requireRetainHeights(t *testing.T, node *fakenode.Node, block uint64, blockResults uint64) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		b, r := node.RetainHeights()
+		return b == block && r == blockResults
+	}, waitTimeout, 10*time.Millisecond)
+}
+
+func TestIngestReconnect(t *testing.T) {
+	node := newTestNode(t)
+	s := startIngest(t, node, testConfig(node))
+
+	produceBlocks(t, node, s, 2)
+
+	// The heights produced while the stream is down are ingested once the
+	// next height is streamed
+	node.DisconnectStreams()
+	node.ProduceBlocks(3)
+	require.Eventually(t, func() bool { return node.StreamCount() == 1 }, waitTimeout, 10*time.Millisecond)
+	latest := produceBlocks(t, node, s, 1)
+
+	requireStored(t, s.storage, 1, latest)
+	requireRetainHeights(t, node, latest, latest)
+}
+
+func TestIngestReconnectMissingHeights(t *testing.T) {
+	ctx := context.Background()
+	node := newTestNode(t)
+	s := startIngest(t, node, testConfig(node))
+
+	// The block at height 3 fails to be fetched before the stream is closed
+	produceBlocks(t, node, s, 2)
+	node.InjectError(fakenode.MethodGetByHeight, nil, 1)
+	produceBlocks(t, node, s, 1)
+	require.Eventually(t, func() bool {
+		blockResults, err := s.storage.GetContiguousBlockResultsHeight(ctx, 1)
+		require.NoError(t, err)
+		return blockResults == 3
+	}, waitTimeout, 10*time.Millisecond)
+
+	// The missing heights are backfilled once the stream is re-opened, along
+	// with the heights produced while it was down
+	node.DisconnectStreams()
+	node.ProduceBlocks(3)
+	require.Eventually(t, func() bool { return node.StreamCount() == 1 }, waitTimeout, 10*time.Millisecond)
+	latest := produceBlocks(t, node, s, 1)
+
+	requireStored(t, s.storage, 1, latest)
+	requireRetainHeights(t, node, latest, latest)
+}
+
+func TestIngestStreamErrorRetry(t *testing.T) {
+	node := newTestNode(t)
+	node.InjectError(fakenode.MethodGetLatestHeight, nil, 2)
+
+	// The stream is re-opened after the failed attempts
+	s := startIngest(t, node, testConfig(node))
+	latest := produceBlocks(t, node, s, 3)
+
+	requireStored(t, s.storage, 1, latest)
+	requireRetainHeights(t, node, latest, latest)
+}
+
+func TestIngestFetchFailureRepaired(t *testing.T) {
+	node := newTestNode(t)
+	s := startIngest(t, node, testConfig(node))
+
+	produceBlocks(t, node, s, 2)
+	requireRetainHeights(t, node, 2, 2)
+
+	// The block at height 3 fails to be fetched once, it is fetched again
+	// without waiting for another height or a reconnection
+	node.InjectError(fakenode.MethodGetByHeight, nil, 1)
+	latest := produceBlocks(t, node, s, 1)
+
+	requireStored(t, s.storage, 1, latest)
+	requireRetainHeights(t, node, latest, latest)
+}
+
+func TestIngestRetainHeightsStopAtGap(t *testing.T) {
+	ctx := context.Background()
+	node := newTestNode(t)
+	cfg := testConfig(node)
+	cfg.Ingest.ReconnectInitialDelay = time.Second
+	s := startIngest(t, node, cfg)
+
+	produceBlocks(t, node, s, 2)
+	requireRetainHeights(t, node, 2, 2)
+
+	// The block at height 3 fails to be fetched, and to be repaired the first
+	// time, its block results are stored
+	node.InjectError(fakenode.MethodGetByHeight, nil, 2)
+	latest := produceBlocks(t, node, s, 1)
+	require.Eventually(t, func() bool {
+		blockResults, err := s.storage.GetContiguousBlockResultsHeight(ctx, 1)
+		require.NoError(t, err)
+		return blockResults == latest
+	}, waitTimeout, 10*time.Millisecond)
+
+	missing, err := s.storage.GetMissingHeights(ctx, 1, latest)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{3}, missing)
+
+	// The block retain height doesn't advance past the gap
+	requireRetainHeights(t, node, 2, latest)
+
+	// The repair is retried after the backoff
+	requireStored(t, s.storage, 1, latest)
+	requireRetainHeights(t, node, latest, latest)
+}
+
+func TestIngestBackfill(t *testing.T) {
+	node := newTestNode(t)
+	node.ProduceBlocks(25)
+
+	// The heights produced before the service started are backfilled once a
+	// new height is streamed
+	cfg := testConfig(node)
+	cfg.Ingest.Backfill = true
+	cfg.Ingest.BatchSize = 8
+	s := startIngest(t, node, cfg)
+	latest := produceBlocks(t, node, s, 1)
+
+	requireStored(t, s.storage, 1, latest)
+	requireRetainHeights(t, node, latest, latest)
+}
+
+func TestIngestBackfillAboveNodeBase(t *testing.T) {
+	node := newTestNode(t)
+	node.ProduceBlocks(25)
+	// The node only has the heights from 10, e.g. after a state sync, and
+	// reports no retain heights
+	node.Prune(10)
+
+	cfg := testConfig(node)
+	cfg.Ingest.Backfill = true
+	s := startIngest(t, node, cfg)
+	latest := produceBlocks(t, node, s, 1)
+
+	requireStored(t, s.storage, 10, latest)
+	requireRetainHeights(t, node, latest, latest)
+}
+
+func TestIngestQuarantine(t *testing.T) {
+	node := newTestNode(t)
+	node.ProduceBlocks(12)
+	// The app hash of the block results doesn't match the next block
+	node.TamperBlockResults(5, func(blockResults *brs.GetBlockResultsResponse) {
+		blockResults.AppHash = []byte("tampered")
+	})
+	// The block ID doesn't match the tampered header
+	node.TamperBlock(8, func(block *ptypes.Block, _ *ptypes.BlockID) {
+		block.Header.ChainID = "tampered-chain"
+	})
+
+	path := filepath.Join(t.TempDir(), "companion.db")
+	cfg := testConfig(node)
+	cfg.Storage.Backend = config.StorageBackendSQLite
+	cfg.Storage.Connection = path
+	cfg.Storage.AutoMigrate = true
+	cfg.Ingest.Backfill = true
+	s := startIngest(t, node, cfg)
+	latest := produceBlocks(t, node, s, 1)
+
+	ctx := context.Background()
+	require.Eventually(t, func() bool {
+		blocks, err := s.storage.GetMissingHeights(ctx, 1, latest)
+		require.NoError(t, err)
+		blockResults, err := s.storage.GetMissingBlockResultsHeights(ctx, 1, latest)
+		require.NoError(t, err)
+		return len(blocks) == 1 && len(blockResults) == 1
+	}, waitTimeout, 10*time.Millisecond)
+
+	// The tampered data is quarantined instead of stored
+	_, err := s.storage.GetBlock(ctx, 8)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = s.storage.GetBlockResults(ctx, 5)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+	rows, err := db.Query("SELECT height, kind, failed_check FROM quarantine ORDER BY height")
+	require.NoError(t, err)
+	defer rows.Close()
+	var quarantined [][3]any
+	for rows.Next() {
+		var height int64
+		var kind, check string
+		require.NoError(t, rows.Scan(&height, &kind, &check))
+		quarantined = append(quarantined, [3]any{height, kind, check})
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, [][3]any{
+		{int64(5), "block_results", checkAppHash},
+		{int64(8), "block", checkBlockHash},
+	}, quarantined)
+
+	// The retain heights stop below the quarantined data
+	requireRetainHeights(t, node, 7, 4)
+
+	// Once the node serves valid data, the quarantined heights are fetched
+	// again by the retried backfill, and removed from the quarantine
+	node.TamperBlockResults(5, func(blockResults *brs.GetBlockResultsResponse) {
+		blockResults.AppHash = []byte("app-hash-5")
+	})
+	node.TamperBlock(8, func(block *ptypes.Block, _ *ptypes.BlockID) {
+		block.Header.ChainID = "test-chain"
+	})
+	requireStored(t, s.storage, 1, latest)
+	requireRetainHeights(t, node, latest, latest)
+	entries, err := s.storage.GetQuarantined(ctx)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
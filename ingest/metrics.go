@@ -0,0 +1,133 @@
+package ingest
+
+import (
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// MetricsSubsystem is a subsystem shared by all metrics exposed by this
+	// package.
+	MetricsSubsystem = "ingest"
+)
+
+// Metrics contains the metrics exposed by the ingest service
+type Metrics struct {
+	// Latest height streamed by the node
+	NodeHeight metrics.Gauge
+	// Latest height with a block stored
+	StoredHeight metrics.Gauge
+	// Number of heights the storage is behind the node
+	Lag metrics.Gauge
+
+	// Number of heights waiting to be fetched
+	FetchQueueDepth metrics.Gauge
+	// Number of heights waiting to be stored in height order
+	CommitQueueDepth metrics.Gauge
+
+	// Time spent inserting a height or a batch of heights in the storage, in
+	// seconds
+	InsertDuration metrics.Histogram `metrics_labels:"type"`
+
+	// Number of failed gRPC requests to the node
+	GRPCErrors metrics.Counter `metrics_labels:"method"`
+	// Number of blocks and block results quarantined because they failed a
+	// verification check
+	VerificationFailures metrics.Counter `metrics_labels:"check"`
+
+	// Block retain height of the node
+	BlockRetainHeight metrics.Gauge
+	// Block results retain height of the node
+	BlockResultsRetainHeight metrics.Gauge
+}
+
+// PrometheusMetrics returns the metrics of the ingest service registered in the
+// default Prometheus registry. Optionally, labels can be provided along with
+// their values ("foo", "fooValue").
+func PrometheusMetrics(namespace string, labelsAndValues ...string) *Metrics {
+	labels := []string{}
+	for i := 0; i < len(labelsAndValues); i += 2 {
+		labels = append(labels, labelsAndValues[i])
+	}
+	return &Metrics{
+		NodeHeight: prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "node_height",
+			Help:      "Latest height streamed by the node",
+		}, labels).With(labelsAndValues...),
+		StoredHeight: prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "stored_height",
+			Help:      "Latest height with a block stored",
+		}, labels).With(labelsAndValues...),
+		Lag: prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "lag",
+			Help:      "Number of heights the storage is behind the node",
+		}, labels).With(labelsAndValues...),
+		FetchQueueDepth: prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "fetch_queue_depth",
+			Help:      "Number of heights waiting to be fetched",
+		}, labels).With(labelsAndValues...),
+		CommitQueueDepth: prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "commit_queue_depth",
+			Help:      "Number of heights waiting to be stored in height order",
+		}, labels).With(labelsAndValues...),
+		InsertDuration: prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "insert_duration_seconds",
+			Help:      "Time spent inserting a height or a batch of heights in the storage, in seconds",
+			Buckets:   stdprometheus.ExponentialBuckets(0.001, 2, 14),
+		}, append(labels, "type")).With(labelsAndValues...),
+		GRPCErrors: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "grpc_errors",
+			Help:      "Number of failed gRPC requests to the node",
+		}, append(labels, "method")).With(labelsAndValues...),
+		VerificationFailures: prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "verification_failures",
+			Help:      "Number of blocks and block results quarantined because they failed a verification check",
+		}, append(labels, "check")).With(labelsAndValues...),
+		BlockRetainHeight: prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "block_retain_height",
+			Help:      "Block retain height of the node",
+		}, labels).With(labelsAndValues...),
+		BlockResultsRetainHeight: prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      "block_results_retain_height",
+			Help:      "Block results retain height of the node",
+		}, labels).With(labelsAndValues...),
+	}
+}
+
+// NopMetrics returns metrics that are discarded
+func NopMetrics() *Metrics {
+	return &Metrics{
+		NodeHeight:               discard.NewGauge(),
+		StoredHeight:             discard.NewGauge(),
+		Lag:                      discard.NewGauge(),
+		FetchQueueDepth:          discard.NewGauge(),
+		CommitQueueDepth:         discard.NewGauge(),
+		InsertDuration:           discard.NewHistogram(),
+		GRPCErrors:               discard.NewCounter(),
+		VerificationFailures:     discard.NewCounter(),
+		BlockRetainHeight:        discard.NewGauge(),
+		BlockResultsRetainHeight: discard.NewGauge(),
+	}
+}
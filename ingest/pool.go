@@ -0,0 +1,102 @@
+package ingest
+
+import (
+	"sync"
+
+	"github.com/go-kit/kit/metrics"
+)
+
+// fetchJob is a height submitted to a fetchPool, along with its fetched data
+type fetchJob[T any] struct {
+	height uint64
+	data   T
+	err    error
+	// Closed once the data is fetched
+	fetched chan struct{}
+}
+
+// fetchPool fetches heights with a pool of concurrent workers, and commits the
+// fetched data one height at a time, strictly in the order the heights were
+// submitted. Up to queueSize heights wait to be fetched or committed, beyond
+// that Submit blocks.
+type fetchPool[T any] struct {
+	fetch  func(height uint64) (T, error)
+	commit func(height uint64, data T, err error)
+
+	// Heights waiting for a worker
+	jobs chan *fetchJob[T]
+	// Heights waiting to be committed, in submission order
+	pending chan *fetchJob[T]
+
+	fetchDepth  metrics.Gauge
+	commitDepth metrics.Gauge
+
+	workers   sync.WaitGroup
+	committed chan struct{}
+}
+
+// newFetchPool starts the workers and the committer of a fetchPool. The queue
+// depths are reported to the fetchDepth and commitDepth gauges.
+func newFetchPool[T any](
+	workers int,
+	queueSize int,
+	fetch func(height uint64) (T, error),
+	commit func(height uint64, data T, err error),
+	fetchDepth metrics.Gauge,
+	commitDepth metrics.Gauge,
+) *fetchPool[T] {
+	p := &fetchPool[T]{
+		fetch:       fetch,
+		commit:      commit,
+		jobs:        make(chan *fetchJob[T], queueSize),
+		pending:     make(chan *fetchJob[T], queueSize),
+		fetchDepth:  fetchDepth,
+		commitDepth: commitDepth,
+		committed:   make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		p.workers.Add(1)
+		go p.work()
+	}
+	go p.commitInOrder()
+	return p
+}
+
+// Submit enqueues a height to be fetched and committed, it blocks while the
+// queue is full
+func (p *fetchPool[T]) Submit(height uint64) {
+	job := &fetchJob[T]{height: height, fetched: make(chan struct{})}
+	// The job is queued for commit first, so the committer always waits for
+	// the oldest height
+	p.commitDepth.Add(1)
+	p.pending <- job
+	p.fetchDepth.Add(1)
+	p.jobs <- job
+}
+
+// Close waits for the submitted heights to be fetched and committed, and stops
+// the pool. Submit must not be called afterwards.
+func (p *fetchPool[T]) Close() {
+	close(p.jobs)
+	close(p.pending)
+	p.workers.Wait()
+	<-p.committed
+}
+
+func (p *fetchPool[T]) work() {
+	defer p.workers.Done()
+	for job := range p.jobs {
+		p.fetchDepth.Add(-1)
+		job.data, job.err = p.fetch(job.height)
+		close(job.fetched)
+	}
+}
+
+func (p *fetchPool[T]) commitInOrder() {
+	defer close(p.committed)
+	for job := range p.pending {
+		<-job.fetched
+		p.commitDepth.Add(-1)
+		p.commit(job.height, job.data, job.err)
+	}
+}
@@ -0,0 +1,254 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/cometbft/cometbft/rpc/grpc/client/privileged"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetainHeightController advances the node's block retain height only up to
+// the highest height that is contiguously stored, so a crash or a failed insert
+// never lets the node prune a block that the companion does not have.
+type RetainHeightController struct {
+	fetcher *Fetcher
+	logger  slog.Logger
+	mtx     sync.Mutex
+
+	// Lowest heights known to be retained by the node, found once and then
+	// only raised by the retain heights. Zero if not known yet.
+	blockBase        uint64
+	blockResultsBase uint64
+}
+
+func NewRetainHeightController(logger slog.Logger, fetcher *Fetcher) *RetainHeightController {
+	return &RetainHeightController{
+		fetcher: fetcher,
+		logger:  *logger.With("module", "RetainHeightController"),
+	}
+}
+
+// UpdateBlockRetainHeight sets the block retain height to the contiguous stored
+// watermark if it is higher than the current retain height.
+func (r *RetainHeightController) UpdateBlockRetainHeight(ctx context.Context) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	logger := *r.logger.With("method", "UpdateBlockRetainHeight")
+
+	rh, err := r.fetcher.GetBlockRetainHeight(ctx)
+	if err != nil {
+		return err
+	}
+
+	from, err := r.lowestBlockHeight(ctx, rh)
+	if err != nil {
+		return err
+	}
+	watermark, err := r.fetcher.storage.GetContiguousHeight(ctx, from)
+	if err != nil {
+		logger.Error("Get contiguous height", "error", err, "from", from)
+		return fmt.Errorf("error getting contiguous stored height")
+	}
+
+	if watermark <= rh.PruningService {
+		logger.Debug("Retain height up to date", "retain_height", rh.PruningService, "watermark", watermark)
+		return nil
+	}
+
+	return r.fetcher.SetBlockRetainHeight(ctx, watermark)
+}
+
+// UpdateBlockResultsRetainHeight sets the block results retain height to the
+// contiguous stored watermark if it is higher than the current retain height.
+func (r *RetainHeightController) UpdateBlockResultsRetainHeight(ctx context.Context) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	logger := *r.logger.With("method", "UpdateBlockResultsRetainHeight")
+
+	rh, err := r.fetcher.GetBlockResultsRetainHeight(ctx)
+	if err != nil {
+		return err
+	}
+
+	from, err := r.lowestBlockResultsHeight(ctx, rh)
+	if err != nil {
+		return err
+	}
+	watermark, err := r.fetcher.storage.GetContiguousBlockResultsHeight(ctx, from)
+	if err != nil {
+		logger.Error("Get contiguous block results height", "error", err, "from", from)
+		return fmt.Errorf("error getting contiguous stored block results height")
+	}
+
+	if watermark <= rh {
+		logger.Debug("Retain height up to date", "retain_height", rh, "watermark", watermark)
+		return nil
+	}
+
+	return r.fetcher.SetBlockResultsRetainHeight(ctx, watermark)
+}
+
+// lowestRetainedHeight returns the lowest height that the companion has not
+// released to the node for pruning yet. If the companion never set a retain
+// height, every block from the first height must be stored before pruning.
+func lowestRetainedHeight(retainHeight uint64) uint64 {
+	if retainHeight == 0 {
+		return 1
+	}
+	return retainHeight
+}
+
+// LowestBlockHeight returns the lowest block height that the node retains and
+// the companion must store
+func (r *RetainHeightController) LowestBlockHeight(ctx context.Context) (uint64, error) {
+	rh, err := r.fetcher.GetBlockRetainHeight(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.lowestBlockHeight(ctx, rh)
+}
+
+// LowestBlockResultsHeight returns the lowest block results height that the
+// node retains and the companion must store
+func (r *RetainHeightController) LowestBlockResultsHeight(ctx context.Context) (uint64, error) {
+	rh, err := r.fetcher.GetBlockResultsRetainHeight(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.lowestBlockResultsHeight(ctx, rh)
+}
+
+// lowestBlockHeight returns the lowest block height that the node retains and
+// the companion must store before releasing it: the node doesn't serve blocks
+// below its base height, and the application allows pruning below its own
+// retain height.
+func (r *RetainHeightController) lowestBlockHeight(ctx context.Context, rh privileged.RetainHeights) (uint64, error) {
+	from := max(lowestRetainedHeight(rh.PruningService), rh.App)
+	return r.firstRetainedHeight(ctx, from, &r.blockBase, r.fetcher.blockAvailable)
+}
+
+// lowestBlockResultsHeight returns the lowest block results height that the
+// node retains and the companion must store before releasing it
+func (r *RetainHeightController) lowestBlockResultsHeight(ctx context.Context, rh uint64) (uint64, error) {
+	return r.firstRetainedHeight(ctx, lowestRetainedHeight(rh), &r.blockResultsBase, r.fetcher.blockResultsAvailable)
+}
+
+// firstRetainedHeight returns the first height from `from` that the node still
+// serves. The node serves every height from its base height, which is above 1
+// after a state sync or when it pruned before the companion was enabled, up to
+// its latest height, so the base height is found with a binary search and
+// cached in `base`.
+func (r *RetainHeightController) firstRetainedHeight(ctx context.Context, from uint64, base *uint64,
+	available func(context.Context, uint64) (bool, error),
+) (uint64, error) {
+	logger := *r.logger.With("method", "firstRetainedHeight")
+
+	if *base != 0 {
+		return max(from, *base), nil
+	}
+
+	ok, err := available(ctx, from)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		*base = from
+		return from, nil
+	}
+
+	latest, err := r.fetcher.GetLatestBlockHeight(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if latest <= from {
+		// Nothing is retained yet from this height
+		return from, nil
+	}
+	ok, err = available(ctx, latest)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		logger.Error("Latest height not retained", "height", latest)
+		return 0, fmt.Errorf("error finding the node base height")
+	}
+
+	// The height `lo` is not retained, the height `hi` is
+	lo, hi := from, latest
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		ok, err := available(ctx, mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	logger.Info("Found node base height", "from", from, "base", hi)
+	*base = hi
+	return hi, nil
+}
+
+// blockAvailable reports whether the node serves the block at a height
+func (f *Fetcher) blockAvailable(ctx context.Context, height uint64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestDefaultTimeout)
+	defer cancel()
+
+	_, err := f.services.client.GetBlockByHeight(ctx, int64(height))
+	return f.available(height, "GetBlock", err)
+}
+
+// blockResultsAvailable reports whether the node serves the block results at
+// a height
+func (f *Fetcher) blockResultsAvailable(ctx context.Context, height uint64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestDefaultTimeout)
+	defer cancel()
+
+	_, err := f.services.client.GetBlockResults(ctx, int64(height))
+	return f.available(height, "GetBlockResults", err)
+}
+
+// available interprets the error of a request for the data at a height: the
+// node answers NotFound or InvalidArgument for heights it doesn't serve, any
+// other error is a failed request.
+func (f *Fetcher) available(height uint64, method string, err error) (bool, error) {
+	if err == nil {
+		return true, nil
+	}
+	if notServed(err) {
+		return false, nil
+	}
+	logger := *f.logger.With("method", method)
+	logger.Error("Probe height", "error", err, "height", height)
+	f.metrics.GRPCErrors.With("method", method).Add(1)
+	return false, fmt.Errorf("error probing height %d", height)
+}
+
+// notServed reports whether the error is the node refusing a height below its
+// base height or above its latest height. The block results client flattens
+// the gRPC status into the error message.
+func notServed(err error) bool {
+	switch status.Code(err) {
+	case codes.NotFound, codes.InvalidArgument:
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "code = "+codes.NotFound.String()) ||
+		strings.Contains(msg, "code = "+codes.InvalidArgument.String())
+}
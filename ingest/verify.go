@@ -0,0 +1,317 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/cometbft/cometbft/rpc/grpc/client"
+	"github.com/cometbft/cometbft/types"
+	"github.com/cometbft/rpc-companion/storage"
+)
+
+// Verification checks, reported in the quarantine and in the metrics
+const (
+	// The block or block results are at another height than requested
+	checkHeight = "height"
+	// The block ID hash is not the hash of the block header
+	checkBlockHash = "block_hash"
+	// The block is inconsistent, e.g. the data hash doesn't match the
+	// transactions
+	checkBlock = "block"
+	// The last block ID of a block is not the ID of the previous block
+	checkLastBlockID = "last_block_id"
+	// The last results hash of a block doesn't match the previous block
+	// results
+	checkLastResultsHash = "last_results_hash"
+	// The app hash of a block is not the app hash of the previous block
+	// results
+	checkAppHash = "app_hash"
+)
+
+// ErrQuarantined is returned when the data failed verification and was
+// quarantined instead of stored
+var ErrQuarantined = errors.New("data failed verification and was quarantined")
+
+// verificationError is a failed verification check
+type verificationError struct {
+	check  string
+	reason string
+}
+
+func (e *verificationError) Error() string {
+	return fmt.Sprintf("%s check failed: %s", e.check, e.reason)
+}
+
+func checkFailed(check string, format string, args ...any) error {
+	return &verificationError{check: check, reason: fmt.Sprintf(format, args...)}
+}
+
+// heightVerifier verifies the blocks and block results of heights against each
+// other, and against the data stored at the neighbouring heights. The heights
+// are verified in order, so that a height is only verified against heights
+// that passed verification.
+type heightVerifier struct {
+	ctx     context.Context
+	storage storage.IStorage
+
+	// Data of the heights being verified that passed verification
+	blocks       map[uint64]*client.Block
+	blockResults map[uint64]*client.BlockResults
+	// Heights being verified, their data is not looked up in the storage
+	pendingBlocks       map[uint64]bool
+	pendingBlockResults map[uint64]bool
+}
+
+func newHeightVerifier(ctx context.Context, db storage.IStorage, heights []storage.HeightData) *heightVerifier {
+	v := &heightVerifier{
+		ctx:                 ctx,
+		storage:             db,
+		blocks:              make(map[uint64]*client.Block),
+		blockResults:        make(map[uint64]*client.BlockResults),
+		pendingBlocks:       make(map[uint64]bool),
+		pendingBlockResults: make(map[uint64]bool),
+	}
+	for _, data := range heights {
+		if data.Block != nil {
+			v.pendingBlocks[data.Height] = true
+		}
+		if data.BlockResults != nil {
+			v.pendingBlockResults[data.Height] = true
+		}
+	}
+	return v
+}
+
+// verifyBlock checks the block is consistent, and chained to the previous
+// block and block results and to the next block. The neighbours that are not
+// known are not checked.
+func (v *heightVerifier) verifyBlock(height uint64, block *client.Block) error {
+	if block.Block == nil || block.BlockID == nil {
+		return checkFailed(checkBlock, "missing block or block ID")
+	}
+	b := block.Block
+	if b.Height != int64(height) {
+		return checkFailed(checkHeight, "block at height %d instead of %d", b.Height, height)
+	}
+	if hash := b.Hash(); !bytes.Equal(block.BlockID.Hash, hash) {
+		return checkFailed(checkBlockHash, "block ID hash %X doesn't match the header hash %X", block.BlockID.Hash, hash)
+	}
+	if err := b.ValidateBasic(); err != nil {
+		return checkFailed(checkBlock, "%v", err)
+	}
+
+	if height > 1 {
+		prev, err := v.blockAt(height - 1)
+		if err != nil {
+			return err
+		}
+		if prev != nil && !b.LastBlockID.Equals(*prev.BlockID) {
+			return checkFailed(checkLastBlockID, "last block ID %v doesn't match the block ID %v at height %d",
+				b.LastBlockID, *prev.BlockID, height-1)
+		}
+
+		prevResults, err := v.blockResultsAt(height - 1)
+		if err != nil {
+			return err
+		}
+		if prevResults != nil {
+			if err := verifyResultsHashes(b, prevResults, height-1); err != nil {
+				return err
+			}
+		}
+	}
+
+	next, err := v.blockAt(height + 1)
+	if err != nil {
+		return err
+	}
+	if next != nil && !next.Block.LastBlockID.Equals(*block.BlockID) {
+		return checkFailed(checkLastBlockID, "block ID %v doesn't match the last block ID %v at height %d",
+			*block.BlockID, next.Block.LastBlockID, height+1)
+	}
+
+	v.blocks[height] = block
+	return nil
+}
+
+// verifyBlockResults checks the block results are at the height, and chained
+// to the next block if it is known
+func (v *heightVerifier) verifyBlockResults(height uint64, blockResults *client.BlockResults) error {
+	if blockResults.Height != int64(height) {
+		return checkFailed(checkHeight, "block results at height %d instead of %d", blockResults.Height, height)
+	}
+
+	next, err := v.blockAt(height + 1)
+	if err != nil {
+		return err
+	}
+	if next != nil {
+		if err := verifyResultsHashes(next.Block, blockResults, height); err != nil {
+			return err
+		}
+	}
+
+	v.blockResults[height] = blockResults
+	return nil
+}
+
+// verifyResultsHashes checks the last results hash and the app hash of a block
+// match the block results of the previous height
+func verifyResultsHashes(block *types.Block, blockResults *client.BlockResults, resultsHeight uint64) error {
+	if hash := types.NewResults(blockResults.TxResults).Hash(); !bytes.Equal(block.LastResultsHash, hash) {
+		return checkFailed(checkLastResultsHash, "last results hash %X at height %d doesn't match the block results hash %X at height %d",
+			block.LastResultsHash, block.Height, hash, resultsHeight)
+	}
+	if !bytes.Equal(block.AppHash, blockResults.AppHash) {
+		return checkFailed(checkAppHash, "app hash %X at height %d doesn't match the block results app hash %X at height %d",
+			block.AppHash, block.Height, blockResults.AppHash, resultsHeight)
+	}
+	return nil
+}
+
+// blockAt returns the verified or stored block at a height, or nil if it is
+// unknown
+func (v *heightVerifier) blockAt(height uint64) (*client.Block, error) {
+	if block, ok := v.blocks[height]; ok {
+		return block, nil
+	}
+	if v.pendingBlocks[height] {
+		return nil, nil
+	}
+	block, err := v.storage.GetBlock(v.ctx, height)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if block.Block == nil || block.BlockID == nil {
+		return nil, nil
+	}
+	return block, nil
+}
+
+// blockResultsAt returns the verified or stored block results at a height, or
+// nil if they are unknown
+func (v *heightVerifier) blockResultsAt(height uint64) (*client.BlockResults, error) {
+	if blockResults, ok := v.blockResults[height]; ok {
+		return blockResults, nil
+	}
+	if v.pendingBlockResults[height] {
+		return nil, nil
+	}
+	blockResults, err := v.storage.GetBlockResults(v.ctx, height)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	return blockResults, err
+}
+
+// verifyHeights verifies the heights, in height order, and quarantines the
+// blocks and block results that fail verification. It returns the verified
+// data, without the heights left with no data, and the number of heights with
+// quarantined data. Nothing is verified if the verification is disabled.
+func (f *Fetcher) verifyHeights(ctx context.Context, heights []storage.HeightData) ([]storage.HeightData, int, error) {
+	if !f.config.Ingest.Verify {
+		return heights, 0, nil
+	}
+	logger := *f.logger.With("method", "verifyHeights")
+
+	v := newHeightVerifier(ctx, f.storage, heights)
+	verified := make([]storage.HeightData, 0, len(heights))
+	quarantined := 0
+	for _, data := range heights {
+		ok := true
+		if data.Block != nil {
+			if err := v.verifyBlock(data.Height, data.Block); err != nil {
+				if !f.quarantine(ctx, logger, storage.QuarantinedData{Height: data.Height, Block: data.Block}, err) {
+					return nil, 0, err
+				}
+				data.Block = nil
+				ok = false
+			}
+		}
+		if data.BlockResults != nil {
+			if err := v.verifyBlockResults(data.Height, data.BlockResults); err != nil {
+				if !f.quarantine(ctx, logger, storage.QuarantinedData{Height: data.Height, BlockResults: data.BlockResults}, err) {
+					return nil, 0, err
+				}
+				data.BlockResults = nil
+				ok = false
+			}
+		}
+		if !ok {
+			quarantined++
+		}
+		if data.Block != nil || data.BlockResults != nil {
+			verified = append(verified, data)
+		}
+	}
+	return verified, quarantined, nil
+}
+
+// quarantine stores the data that failed a verification check apart from the
+// verified data, and reports it. It returns false if the error is not a failed
+// check, e.g. the neighbouring data couldn't be read.
+func (f *Fetcher) quarantine(ctx context.Context, logger slog.Logger, data storage.QuarantinedData, err error) bool {
+	var verr *verificationError
+	if !errors.As(err, &verr) {
+		return false
+	}
+	data.Check = verr.check
+	data.Reason = verr.reason
+
+	logger.Error("Verification failed, quarantining data", "height", data.Height, "kind", data.Kind(),
+		"check", verr.check, "reason", verr.reason)
+	f.metrics.VerificationFailures.With("check", verr.check).Add(1)
+	if err := f.storage.QuarantineHeight(ctx, data); err != nil {
+		logger.Error("Quarantine data", "error", err, "height", data.Height, "kind", data.Kind())
+	}
+	return true
+}
+
+// RetryQuarantined fetches the quarantined blocks and block results from the
+// node again, in height order, and stores the ones passing verification, which
+// removes them from the quarantine. The retain heights are then updated. It
+// returns the number of blocks and block results still quarantined.
+func (f *Fetcher) RetryQuarantined(ctx context.Context) (int, error) {
+	logger := *f.logger.With("method", "RetryQuarantined")
+
+	entries, err := f.storage.GetQuarantined(ctx)
+	if err != nil {
+		logger.Error("Get quarantined data", "error", err)
+		return 0, fmt.Errorf("error getting quarantined data")
+	}
+
+	remaining := 0
+	var stored storedKinds
+	for _, entry := range entries {
+		data := storage.HeightData{Height: entry.Height}
+		if entry.Kind == "block" {
+			data.Block, err = f.GetBlock(ctx, int64(entry.Height))
+		} else {
+			data.BlockResults, err = f.GetBlockResults(ctx, int64(entry.Height))
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		err = f.StoreHeight(ctx, data)
+		if errors.Is(err, ErrQuarantined) {
+			remaining++
+			continue
+		}
+		if err != nil {
+			logger.Error("Store quarantined data", "error", err, "height", entry.Height, "kind", entry.Kind)
+			return 0, fmt.Errorf("error storing quarantined data at height %d", entry.Height)
+		}
+		logger.Info("Stored quarantined data", "height", entry.Height, "kind", entry.Kind)
+		stored.add(data)
+	}
+
+	f.updateRetainHeights(logger, stored.block, stored.blockResults)
+	return remaining, nil
+}
@@ -0,0 +1,196 @@
+// Package certs builds gRPC transport credentials from PEM files, reloading
+// them when the files change.
+//
+// The files are checked before every TLS handshake, so a rotated certificate is
+// used by the next connection, e.g. when a gRPC client reconnects. Established
+// connections are not affected.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// Files are the PEM files of a TLS client
+type Files struct {
+	// CA bundle verifying the server certificate, the system pool is used if
+	// empty
+	CAFile string
+	// Client certificate and key, for mutual TLS. Both are empty if the
+	// server doesn't authenticate the client.
+	CertFile string
+	KeyFile  string
+	// Name checked against the server certificate, the host of the dialed
+	// address is used if empty
+	ServerName string
+}
+
+// fileState identifies a version of a file
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// Reloader holds the TLS configuration loaded from the files, and loads it
+// again when any of the files changes
+type Reloader struct {
+	logger slog.Logger
+	files  Files
+
+	mtx    sync.Mutex
+	config *tls.Config
+	states map[string]fileState
+}
+
+// NewReloader loads the files, it fails if they can't be loaded
+func NewReloader(logger slog.Logger, files Files) (*Reloader, error) {
+	r := &Reloader{
+		logger: *logger.With("module", "Certs"),
+		files:  files,
+	}
+	states, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(states); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Config returns the current TLS configuration, loading the files again if
+// they changed. If they can't be loaded, e.g. while they are being replaced,
+// the previous configuration is kept.
+func (r *Reloader) Config() *tls.Config {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	states, err := r.stat()
+	if err != nil {
+		r.logger.Error("Check certificate files", "error", err)
+		return r.config
+	}
+	if !r.changed(states) {
+		return r.config
+	}
+	if err := r.load(states); err != nil {
+		r.logger.Error("Reload certificates", "error", err)
+		return r.config
+	}
+	r.logger.Info("Reloaded certificates", "ca_file", r.files.CAFile, "cert_file", r.files.CertFile)
+	return r.config
+}
+
+// TransportCredentials returns gRPC credentials using the current TLS
+// configuration for every handshake
+func (r *Reloader) TransportCredentials() credentials.TransportCredentials {
+	return &reloadingCredentials{reloader: r, serverName: r.files.ServerName}
+}
+
+// stat returns the state of the configured files
+func (r *Reloader) stat() (map[string]fileState, error) {
+	states := make(map[string]fileState)
+	for _, file := range []string{r.files.CAFile, r.files.CertFile, r.files.KeyFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", file, err)
+		}
+		states[file] = fileState{modTime: info.ModTime(), size: info.Size()}
+	}
+	return states, nil
+}
+
+func (r *Reloader) changed(states map[string]fileState) bool {
+	for file, state := range states {
+		if r.states[file] != state {
+			return true
+		}
+	}
+	return false
+}
+
+// load builds the TLS configuration from the files, the caller holds the lock
+func (r *Reloader) load(states map[string]fileState) error {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: r.files.ServerName,
+	}
+
+	if r.files.CAFile != "" {
+		pem, err := os.ReadFile(r.files.CAFile)
+		if err != nil {
+			return fmt.Errorf("error reading CA file %s: %w", r.files.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("error reading CA file %s: no PEM certificate found", r.files.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if r.files.CertFile != "" || r.files.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+		if err != nil {
+			return fmt.Errorf("error reading client certificate %s: %w", r.files.CertFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	r.config = config
+	r.states = states
+	return nil
+}
+
+// reloadingCredentials performs the client handshakes with the current TLS
+// configuration of a Reloader
+type reloadingCredentials struct {
+	reloader   *Reloader
+	serverName string
+}
+
+func (c *reloadingCredentials) current() credentials.TransportCredentials {
+	config := c.reloader.Config().Clone()
+	if c.serverName != "" {
+		config.ServerName = c.serverName
+	}
+	return credentials.NewTLS(config)
+}
+
+func (c *reloadingCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.current().ClientHandshake(ctx, authority, conn)
+}
+
+func (c *reloadingCredentials) ServerHandshake(net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, fmt.Errorf("server handshakes are not supported")
+}
+
+func (c *reloadingCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{
+		SecurityProtocol: "tls",
+		SecurityVersion:  "1.2",
+		ServerName:       c.serverName,
+	}
+}
+
+func (c *reloadingCredentials) Clone() credentials.TransportCredentials {
+	return &reloadingCredentials{reloader: c.reloader, serverName: c.serverName}
+}
+
+// OverrideServerName is deprecated in gRPC, it is kept to satisfy the
+// interface
+func (c *reloadingCredentials) OverrideServerName(serverName string) error {
+	c.serverName = serverName
+	return nil
+}
@@ -9,17 +9,22 @@ import (
 )
 
 // TrapSignal catches the SIGTERM/SIGINT and executes cb function. After that it exits
-// with code 0.
+// with code 0. The cb function can take a while to stop the services gracefully,
+// a second signal exits right away with code 1.
 func TrapSignal(logger slog.Logger, cb func()) {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 	go func() {
-		for sig := range c {
-			logger.Info(fmt.Sprintf("Signal %v captured, exiting...", sig))
-			if cb != nil {
-				cb()
-			}
-			os.Exit(0)
+		sig := <-c
+		logger.Info(fmt.Sprintf("Signal %v captured, exiting...", sig))
+		go func() {
+			sig := <-c
+			logger.Error(fmt.Sprintf("Signal %v captured again, exiting without cleanup", sig))
+			os.Exit(1)
+		}()
+		if cb != nil {
+			cb()
 		}
+		os.Exit(0)
 	}()
 }
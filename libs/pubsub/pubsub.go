@@ -0,0 +1,182 @@
+// Package pubsub delivers published messages to the subscribers whose query
+// matches the events of the message.
+//
+// Every client, identified by a string, can hold several subscriptions, one
+// per query. Publishing never blocks: a subscription whose buffer is full is
+// canceled with ErrOutOfCapacity.
+package pubsub
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/cometbft/rpc-companion/libs/query"
+)
+
+var (
+	// ErrSubscriptionNotFound is returned when a client tries to unsubscribe
+	// from a query it is not subscribed to.
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	// ErrAlreadySubscribed is returned when a client tries to subscribe twice
+	// with the same query.
+	ErrAlreadySubscribed = errors.New("already subscribed")
+	// ErrUnsubscribed is the cancellation reason of the subscriptions removed
+	// by the client.
+	ErrUnsubscribed = errors.New("client unsubscribed")
+	// ErrOutOfCapacity is the cancellation reason of the subscriptions that
+	// are not read fast enough.
+	ErrOutOfCapacity = errors.New("client is not pulling messages fast enough")
+)
+
+// Message is a published message
+type Message struct {
+	// Data sent to the subscribers
+	Data any
+	// Events matched against the queries, as a map from composite keys to
+	// attribute values
+	Events map[string][]string
+}
+
+// Subscription receives the messages matching its query until it is canceled
+type Subscription struct {
+	query    *query.Query
+	out      chan Message
+	canceled chan struct{}
+
+	mtx sync.RWMutex
+	err error
+}
+
+// Out returns the channel receiving the messages
+func (s *Subscription) Out() <-chan Message {
+	return s.out
+}
+
+// Canceled returns a channel closed when the subscription is canceled
+func (s *Subscription) Canceled() <-chan struct{} {
+	return s.canceled
+}
+
+// Err returns the cancellation reason, or nil if the subscription is active
+func (s *Subscription) Err() error {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.err
+}
+
+func (s *Subscription) cancel(err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.err == nil {
+		s.err = err
+		close(s.canceled)
+	}
+}
+
+// Server keeps track of the subscriptions and publishes the messages
+type Server struct {
+	mtx sync.RWMutex
+	// Subscriptions by client and query string
+	subscriptions map[string]map[string]*Subscription
+}
+
+func NewServer() *Server {
+	return &Server{
+		subscriptions: make(map[string]map[string]*Subscription),
+	}
+}
+
+// Subscribe creates a subscription of a client to the messages matching the
+// query, buffering up to capacity messages
+func (s *Server) Subscribe(clientID string, q *query.Query, capacity int) (*Subscription, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	clientSubscriptions, ok := s.subscriptions[clientID]
+	if !ok {
+		clientSubscriptions = make(map[string]*Subscription)
+		s.subscriptions[clientID] = clientSubscriptions
+	}
+	if _, ok := clientSubscriptions[q.String()]; ok {
+		return nil, ErrAlreadySubscribed
+	}
+
+	sub := &Subscription{
+		query:    q,
+		out:      make(chan Message, capacity),
+		canceled: make(chan struct{}),
+	}
+	clientSubscriptions[q.String()] = sub
+	return sub, nil
+}
+
+// Unsubscribe cancels the subscription of a client to a query
+func (s *Server) Unsubscribe(clientID string, q *query.Query) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	sub, ok := s.subscriptions[clientID][q.String()]
+	if !ok {
+		return ErrSubscriptionNotFound
+	}
+	s.remove(clientID, q.String(), sub, ErrUnsubscribed)
+	return nil
+}
+
+// UnsubscribeAll cancels all the subscriptions of a client
+func (s *Server) UnsubscribeAll(clientID string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	clientSubscriptions, ok := s.subscriptions[clientID]
+	if !ok {
+		return ErrSubscriptionNotFound
+	}
+	for q, sub := range clientSubscriptions {
+		s.remove(clientID, q, sub, ErrUnsubscribed)
+	}
+	return nil
+}
+
+// NumClients returns the number of clients with at least one subscription
+func (s *Server) NumClients() int {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return len(s.subscriptions)
+}
+
+// NumClientSubscriptions returns the number of subscriptions of a client
+func (s *Server) NumClientSubscriptions(clientID string) int {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return len(s.subscriptions[clientID])
+}
+
+// Publish sends the message to the subscriptions whose query matches its
+// events. The subscriptions that can't buffer the message are canceled.
+func (s *Server) Publish(msg Message) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for clientID, clientSubscriptions := range s.subscriptions {
+		for q, sub := range clientSubscriptions {
+			if !sub.query.Matches(msg.Events) {
+				continue
+			}
+			select {
+			case sub.out <- msg:
+			default:
+				s.remove(clientID, q, sub, ErrOutOfCapacity)
+			}
+		}
+	}
+}
+
+// remove cancels a subscription and forgets it, the caller holds the lock
+func (s *Server) remove(clientID string, q string, sub *Subscription, reason error) {
+	sub.cancel(reason)
+	delete(s.subscriptions[clientID], q)
+	if len(s.subscriptions[clientID]) == 0 {
+		delete(s.subscriptions, clientID)
+	}
+}
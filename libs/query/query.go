@@ -0,0 +1,399 @@
+// Package query parses the CometBFT event query language, e.g.
+//
+//	tm.event = 'Tx' AND tx.height > 100 AND transfer.recipient = 'addr'
+//
+// A query is a list of conditions joined by AND. Each condition compares the
+// values of an event attribute, identified by its composite key
+// (`type.key`), with an operand. The grammar follows the one of CometBFT:
+//
+//	query      = condition {"AND" condition}
+//	condition  = tag ("EXISTS" / "CONTAINS" string / op operand)
+//	op         = "=" / "<" / "<=" / ">" / ">="
+//	operand    = string / number / "DATE" date / "TIME" time
+//
+// Strings are single quoted and the order operators only accept numbers,
+// dates and times. Unlike in CometBFT, numbers may be negative and have an
+// exponent.
+package query
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Operator is a comparison operator of a condition
+type Operator int
+
+const (
+	OpEqual Operator = iota
+	OpLess
+	OpLessEqual
+	OpGreater
+	OpGreaterEqual
+	OpContains
+	OpExists
+)
+
+var operators = map[Operator]string{
+	OpEqual:        "=",
+	OpLess:         "<",
+	OpLessEqual:    "<=",
+	OpGreater:      ">",
+	OpGreaterEqual: ">=",
+	OpContains:     "CONTAINS",
+	OpExists:       "EXISTS",
+}
+
+func (op Operator) String() string {
+	return operators[op]
+}
+
+// OperandType is the type of the operand of a condition
+type OperandType int
+
+const (
+	TypeNone OperandType = iota
+	TypeString
+	TypeNumber
+	TypeDate
+	TypeTime
+)
+
+const (
+	dateLayout = "2006-01-02"
+	timeLayout = time.RFC3339
+)
+
+var (
+	// Also extracts the leading number of an attribute value, so values like
+	// "10stake" can be compared with numbers
+	numberRegexp = regexp.MustCompile(`^-?\d+(\.\d+)?([eE][-+]?\d+)?`)
+	dateRegexp   = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}`)
+	timeRegexp   = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?([-+]\d{2}:\d{2}|Z)`)
+)
+
+// Condition compares the values of the event attributes with a composite key
+// with an operand
+type Condition struct {
+	// Composite key of the attribute, `type.key`
+	Tag string
+	Op  Operator
+	// Type of the operand, TypeNone for EXISTS
+	Type OperandType
+	// Operand as written in the query, without quotes or the DATE and TIME
+	// keywords
+	Operand string
+	// Parsed operand, for numbers
+	Number *big.Float
+	// Parsed operand, for dates and times
+	Time time.Time
+}
+
+// Query is a parsed event query
+type Query struct {
+	str        string
+	conditions []Condition
+}
+
+// All returns a query matching all the events
+func All() *Query {
+	return &Query{}
+}
+
+// New parses a query
+func New(s string) (*Query, error) {
+	p := &parser{input: s}
+	conditions, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("error parsing query %q: %w", s, err)
+	}
+	return &Query{str: strings.TrimSpace(s), conditions: conditions}, nil
+}
+
+// MustCompile parses a query and panics on error
+func MustCompile(s string) *Query {
+	q, err := New(s)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// String returns the query as written
+func (q *Query) String() string {
+	return q.str
+}
+
+// Conditions returns the conditions of the query
+func (q *Query) Conditions() []Condition {
+	return q.conditions
+}
+
+// Matches reports whether the events satisfy every condition of the query. The
+// events are flattened as a map from composite keys to attribute values. A
+// condition is satisfied if any value of its composite key matches. As in
+// CometBFT, a condition whose tag is an event type is matched against an empty
+// value. A query without conditions matches any events, even none.
+func (q *Query) Matches(events map[string][]string) bool {
+	if len(q.conditions) == 0 {
+		return true
+	}
+	if len(events) == 0 {
+		return false
+	}
+	for _, c := range q.conditions {
+		if !c.matchesAny(events) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Condition) matchesAny(events map[string][]string) bool {
+	values, ok := events[c.Tag]
+	if !ok {
+		for key := range events {
+			if strings.HasPrefix(key, c.Tag+".") {
+				return c.Match("")
+			}
+		}
+		return false
+	}
+	for _, value := range values {
+		if c.Match(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether an attribute value satisfies the condition
+func (c Condition) Match(value string) bool {
+	switch c.Op {
+	case OpExists:
+		return true
+	case OpContains:
+		return strings.Contains(value, c.Operand)
+	}
+
+	switch c.Type {
+	case TypeString:
+		return c.Op == OpEqual && value == c.Operand
+	case TypeNumber:
+		number, _, err := big.ParseFloat(numberRegexp.FindString(value), 10, 125, big.ToNearestEven)
+		if err != nil {
+			return false
+		}
+		return c.compare(number.Cmp(c.Number))
+	case TypeDate, TypeTime:
+		t, err := time.Parse(timeLayout, value)
+		if err != nil {
+			if t, err = time.Parse(dateLayout, value); err != nil {
+				return false
+			}
+		}
+		return c.compare(t.Compare(c.Time))
+	default:
+		return false
+	}
+}
+
+// compare applies the operator to the result of comparing a value with the
+// operand
+func (c Condition) compare(cmp int) bool {
+	switch c.Op {
+	case OpEqual:
+		return cmp == 0
+	case OpLess:
+		return cmp < 0
+	case OpLessEqual:
+		return cmp <= 0
+	case OpGreater:
+		return cmp > 0
+	case OpGreaterEqual:
+		return cmp >= 0
+	default:
+		return false
+	}
+}
+
+//-----------------------------------------------------------------------------
+// parser
+
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) parse() ([]Condition, error) {
+	conditions := make([]Condition, 0)
+	for {
+		c, err := p.condition()
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, c)
+
+		p.skipSpaces()
+		if p.eof() {
+			return conditions, nil
+		}
+		if !p.keyword("AND") {
+			return nil, fmt.Errorf("expected AND at position %d", p.pos)
+		}
+	}
+}
+
+func (p *parser) condition() (Condition, error) {
+	var c Condition
+
+	p.skipSpaces()
+	c.Tag = p.tag()
+	if c.Tag == "" {
+		return c, fmt.Errorf("expected a tag at position %d", p.pos)
+	}
+
+	p.skipSpaces()
+	switch {
+	case p.keyword("EXISTS"):
+		c.Op = OpExists
+		return c, nil
+	case p.keyword("CONTAINS"):
+		c.Op = OpContains
+		p.skipSpaces()
+		s, err := p.string()
+		if err != nil {
+			return c, err
+		}
+		c.Type, c.Operand = TypeString, s
+		return c, nil
+	case p.symbol("<="):
+		c.Op = OpLessEqual
+	case p.symbol(">="):
+		c.Op = OpGreaterEqual
+	case p.symbol("<"):
+		c.Op = OpLess
+	case p.symbol(">"):
+		c.Op = OpGreater
+	case p.symbol("="):
+		c.Op = OpEqual
+	default:
+		return c, fmt.Errorf("expected an operator at position %d", p.pos)
+	}
+
+	p.skipSpaces()
+	switch {
+	case p.peek() == '\'':
+		if c.Op != OpEqual {
+			return c, fmt.Errorf("operator %s does not accept a string at position %d", c.Op, p.pos)
+		}
+		s, err := p.string()
+		if err != nil {
+			return c, err
+		}
+		c.Type, c.Operand = TypeString, s
+	case p.keyword("DATE"):
+		p.skipSpaces()
+		s := p.match(dateRegexp)
+		t, err := time.Parse(dateLayout, s)
+		if err != nil {
+			return c, fmt.Errorf("expected a date at position %d", p.pos)
+		}
+		c.Type, c.Operand, c.Time = TypeDate, s, t
+	case p.keyword("TIME"):
+		p.skipSpaces()
+		s := p.match(timeRegexp)
+		t, err := time.Parse(timeLayout, s)
+		if err != nil {
+			return c, fmt.Errorf("expected a time at position %d", p.pos)
+		}
+		c.Type, c.Operand, c.Time = TypeTime, s, t
+	default:
+		s := p.match(numberRegexp)
+		number, _, err := big.ParseFloat(s, 10, 125, big.ToNearestEven)
+		if s == "" || err != nil {
+			return c, fmt.Errorf("expected an operand at position %d", p.pos)
+		}
+		c.Type, c.Operand, c.Number = TypeNumber, s, number
+	}
+	return c, nil
+}
+
+// tag reads a composite key, words separated by dots
+func (p *parser) tag() string {
+	start := p.pos
+	for !p.eof() {
+		r := rune(p.input[p.pos])
+		if r != '.' && r != '_' && r != '-' && r != '/' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+// string reads a single quoted string
+func (p *parser) string() (string, error) {
+	if p.peek() != '\'' {
+		return "", fmt.Errorf("expected a quoted string at position %d", p.pos)
+	}
+	end := strings.IndexByte(p.input[p.pos+1:], '\'')
+	if end < 0 {
+		return "", fmt.Errorf("unterminated string at position %d", p.pos)
+	}
+	s := p.input[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	return s, nil
+}
+
+// keyword consumes the keyword if it is next in the input, followed by a space
+// or the end of the input
+func (p *parser) keyword(keyword string) bool {
+	if !strings.HasPrefix(p.input[p.pos:], keyword) {
+		return false
+	}
+	end := p.pos + len(keyword)
+	if end < len(p.input) && !unicode.IsSpace(rune(p.input[end])) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+// symbol consumes the symbol if it is next in the input
+func (p *parser) symbol(symbol string) bool {
+	if !strings.HasPrefix(p.input[p.pos:], symbol) {
+		return false
+	}
+	p.pos += len(symbol)
+	return true
+}
+
+// match consumes the input matching the regular expression, anchored at the
+// current position
+func (p *parser) match(re *regexp.Regexp) string {
+	s := re.FindString(p.input[p.pos:])
+	p.pos += len(s)
+	return s
+}
+
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *parser) skipSpaces() {
+	for !p.eof() && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.input)
+}
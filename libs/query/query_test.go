@@ -0,0 +1,267 @@
+package query
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The parser and matching cases are taken from the CometBFT pubsub query tests
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		input string
+		valid bool
+	}{
+		{"tm.events.type='NewBlock'", true},
+		{"tm.events.type = 'NewBlock'", true},
+		{"tm.events.name = ''", true},
+		{"tm.events.type='TIME'", true},
+		{"tm.events.type='DATE'", true},
+		{"tm.events.type='='", true},
+		{"tm.events.type='TIME", false},
+		{"tm.events.type=TIME'", false},
+		{"tm.events.type==", false},
+		{"tm.events.type=NewBlock", false},
+		{">==", false},
+		{"tm.events.type 'NewBlock' =", false},
+		{"tm.events.type>'NewBlock'", false},
+		{"", false},
+		{"=", false},
+		{"='NewBlock'", false},
+		{"tm.events.type=", false},
+
+		{"tm.events.typeNewBlock", false},
+		{"tm.events.type'NewBlock'", false},
+		{"'NewBlock'", false},
+		{"NewBlock", false},
+
+		{"tm.events.type='NewBlock' AND abci.account.name='Igor'", true},
+		{"tm.events.type='NewBlock' AND", false},
+		{"tm.events.type='NewBlock' AN", false},
+		{"tm.events.type='NewBlock' AN tm.events.type='NewBlockHeader'", false},
+		{"AND tm.events.type='NewBlock' ", false},
+
+		{"abci.account.name CONTAINS 'Igor'", true},
+		{"abci.account.name CONTAINS Igor", false},
+
+		{"tx.date > DATE 2013-05-03", true},
+		{"tx.date < DATE 2013-05-03", true},
+		{"tx.date <= DATE 2013-05-03", true},
+		{"tx.date >= DATE 2013-05-03", true},
+		{"tx.date >= DAT 2013-05-03", false},
+		{"tx.date <= DATE2013-05-03", false},
+		{"tx.date <= DATE -05-03", false},
+		{"tx.date >= DATE 20130503", false},
+		{"tx.date >= DATE 2013+01-03", false},
+		{"tx.date >= DATE 2013-31-03", false},
+		{"tx.date >= DATE 2013-01-83", false},
+
+		{"tx.date > TIME 2013-05-03T14:45:00+07:00", true},
+		{"tx.date < TIME 2013-05-03T14:45:00-02:00", true},
+		{"tx.date <= TIME 2013-05-03T14:45:00Z", true},
+		{"tx.date >= TIME 2013-05-03T14:45:00Z", true},
+		{"tx.date >= TIME2013-05-03T14:45:00Z", false},
+		{"tx.date = IME 2013-05-03T14:45:00Z", false},
+		{"tx.date = TIME 2013-05-:45:00Z", false},
+		{"tx.date >= TIME 2013-05-03T14:45:00", false},
+		{"tx.date >= TIME 0013-00-00T14:45:00Z", false},
+		{"tx.date >= TIME 2013+05=03T14:45:00Z", false},
+
+		{"account.balance=100", true},
+		{"account.balance >= 200", true},
+		{"account.balance >>= 400", false},
+		{"account.balance=33.22.1", false},
+		// Unlike in CometBFT, numbers may be negative and have an exponent
+		{"account.balance >= -300", true},
+		{"account.balance >= -300.5", true},
+		{"account.balance < 1e10", true},
+		{"account.balance < 1.5E-3", true},
+		{"account.balance < 1e", false},
+		{"account.balance < --3", false},
+
+		{"slashing.amount EXISTS", true},
+		{"slashing.amount EXISTS AND account.balance=100", true},
+		{"account.balance=100 AND slashing.amount EXISTS", true},
+		{"slashing EXISTS", true},
+
+		{"hash='136E18F7E4C348B780CF873A0BF43922E5BAFA63'", true},
+		{"hash=136E18F7E4C348B780CF873A0BF43922E5BAFA63", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			q, err := New(tc.input)
+			if !tc.valid {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, strings.TrimSpace(tc.input), q.String())
+		})
+	}
+}
+
+func TestConditions(t *testing.T) {
+	q := MustCompile("tm.event = 'Tx' AND tx.height > -5 AND transfer.amount <= 1.5e3 AND " +
+		"tx.date >= DATE 2013-05-03 AND tx.time < TIME 2013-05-03T14:45:00+02:00 AND " +
+		"abci.owner.name CONTAINS 'Igor' AND slash EXISTS")
+
+	date := time.Date(2013, 5, 3, 0, 0, 0, 0, time.UTC)
+	instant := time.Date(2013, 5, 3, 12, 45, 0, 0, time.UTC)
+	want := []Condition{
+		{Tag: "tm.event", Op: OpEqual, Type: TypeString, Operand: "Tx"},
+		{Tag: "tx.height", Op: OpGreater, Type: TypeNumber, Operand: "-5", Number: big.NewFloat(-5)},
+		{Tag: "transfer.amount", Op: OpLessEqual, Type: TypeNumber, Operand: "1.5e3", Number: big.NewFloat(1500)},
+		{Tag: "tx.date", Op: OpGreaterEqual, Type: TypeDate, Operand: "2013-05-03", Time: date},
+		{Tag: "tx.time", Op: OpLess, Type: TypeTime, Operand: "2013-05-03T14:45:00+02:00", Time: instant},
+		{Tag: "abci.owner.name", Op: OpContains, Type: TypeString, Operand: "Igor"},
+		{Tag: "slash", Op: OpExists},
+	}
+
+	conditions := q.Conditions()
+	require.Len(t, conditions, len(want))
+	for i, c := range conditions {
+		require.Equal(t, want[i].Tag, c.Tag)
+		require.Equal(t, want[i].Op, c.Op)
+		require.Equal(t, want[i].Type, c.Type)
+		require.Equal(t, want[i].Operand, c.Operand)
+		if want[i].Number != nil {
+			require.Zero(t, want[i].Number.Cmp(c.Number), "number %s", c.Number)
+		}
+		require.True(t, want[i].Time.Equal(c.Time), "time %s", c.Time)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	txDate := "2017-01-01"
+	txTime := "2018-05-03T14:45:00Z"
+
+	// Events from the examples of the CometBFT OpenAPI documentation
+	apiEvents := map[string][]string{
+		"tm.event":                 {"Tx"},
+		"tm.hash":                  {"XYZ"},
+		"tm.height":                {"5"},
+		"rewards.withdraw.address": {"AddrA", "AddrB"},
+		"rewards.withdraw.source":  {"SrcX", "SrcY"},
+		"rewards.withdraw.amount":  {"100", "45"},
+		"rewards.withdraw.balance": {"1500", "999"},
+		"transfer.sender":          {"AddrC"},
+		"transfer.recipient":       {"AddrD"},
+		"transfer.amount":          {"160"},
+	}
+	bigNumbers := map[string][]string{
+		"big.value":       {"99999999999999999999"},
+		"big2.value":      {"18446744073709551615"},
+		"big.floatvalue":  {"99999999999999999999.10"},
+		"big2.floatvalue": {"18446744073709551615.6"},
+	}
+
+	cases := []struct {
+		query   string
+		events  map[string][]string
+		matches bool
+	}{
+		{`tm.events.type='NewBlock'`, newTestEvents(`tm|events.type=NewBlock`), true},
+		{`tx.gas > 7`, newTestEvents(`tx|gas=8`), true},
+		{`transfer.amount > 7`, newTestEvents(`transfer|amount=8stake`), true},
+		{`transfer.amount > 7`, newTestEvents(`transfer|amount=8.045`), true},
+		{`transfer.amount > 7.043`, newTestEvents(`transfer|amount=8.045stake`), true},
+		{`transfer.amount > 8.045`, newTestEvents(`transfer|amount=8.045stake`), false},
+		{`tx.gas > 7 AND tx.gas < 9`, newTestEvents(`tx|gas=8`), true},
+		{`body.weight >= 3.5`, newTestEvents(`body|weight=3.5`), true},
+		{`account.balance < 1000.0`, newTestEvents(`account|balance=900`), true},
+		{`apples.kg <= 4`, newTestEvents(`apples|kg=4.0`), true},
+		{`body.weight >= 4.5`, newTestEvents(`body|weight=4.5`), true},
+		{`oranges.kg < 4 AND watermellons.kg > 10`, newTestEvents(`oranges|kg=3`, `watermellons|kg=12`), true},
+		{`peaches.kg < 4`, newTestEvents(`peaches|kg=5`), false},
+		{`tx.date > DATE 2017-01-01`, newTestEvents(`tx|date=` + time.Now().Format(dateLayout)), true},
+		{`tx.date = DATE 2017-01-01`, newTestEvents(`tx|date=` + txDate), true},
+		{`tx.date = DATE 2018-01-01`, newTestEvents(`tx|date=` + txDate), false},
+		{`tx.time >= TIME 2013-05-03T14:45:00Z`, newTestEvents(`tx|time=` + time.Now().Format(timeLayout)), true},
+		{`tx.time = TIME 2013-05-03T14:45:00Z`, newTestEvents(`tx|time=` + txTime), false},
+		{`abci.owner.name CONTAINS 'Igor'`, newTestEvents(`abci|owner.name=Igor|owner.name=Ivan`), true},
+		{`abci.owner.name CONTAINS 'Igor'`, newTestEvents(`abci|owner.name=Pavel|owner.name=Ivan`), false},
+		{`abci.owner.name = 'Igor'`, newTestEvents(`abci|owner.name=Igor|owner.name=Ivan`), true},
+		{`abci.owner.name = 'Ivan'`, newTestEvents(`abci|owner.name=Igor|owner.name=Ivan`), true},
+		{`abci.owner.name = 'Ivan' AND abci.owner.name = 'Igor'`, newTestEvents(`abci|owner.name=Igor|owner.name=Ivan`), true},
+		{`abci.owner.name = 'Ivan' AND abci.owner.name = 'John'`, newTestEvents(`abci|owner.name=Igor|owner.name=Ivan`), false},
+		{`tm.events.type='NewBlock'`, newTestEvents(`tm|events.type=NewBlock`, `app|name=fuzzed`), true},
+		{`app.name = 'fuzzed'`, newTestEvents(`tm|events.type=NewBlock`, `app|name=fuzzed`), true},
+		{`tm.events.type='NewBlock' AND app.name = 'fuzzed'`, newTestEvents(`tm|events.type=NewBlock`, `app|name=fuzzed`), true},
+		{`tm.events.type='NewHeader' AND app.name = 'fuzzed'`, newTestEvents(`tm|events.type=NewBlock`, `app|name=fuzzed`), false},
+		{`slash EXISTS`, newTestEvents(`slash|reason=missing_signature|power=6000`), true},
+		{`slash EXISTS`, newTestEvents(`transfer|recipient=cosmos1gu6y2a0ffteesyeyeesk23082c6998xyzmt9mz`), false},
+		{`slash.reason EXISTS AND slash.power > 1000`, newTestEvents(`slash|reason=missing_signature|power=6000`), true},
+		{`slash.reason EXISTS AND slash.power > 1000`, newTestEvents(`slash|reason=missing_signature|power=500`), false},
+		{`slash.reason EXISTS`, newTestEvents(`transfer|recipient=cosmos1gu6y2a0ffteesyeyeesk23082c6998xyzmt9mz`), false},
+
+		{`tm.event = 'Tx' AND rewards.withdraw.address = 'AddrA'`, apiEvents, true},
+		{`tm.event = 'Tx' AND rewards.withdraw.address = 'AddrA' AND rewards.withdraw.source = 'SrcY'`, apiEvents, true},
+		{`tm.event = 'Tx' AND transfer.sender = 'AddrA'`, apiEvents, false},
+		{`tm.event = 'Tx' AND transfer.sender = 'AddrC'`, apiEvents, true},
+		{`tm.event = 'Tx' AND transfer.sender = 'AddrZ'`, apiEvents, false},
+		{`tm.event = 'Tx' AND rewards.withdraw.address = 'AddrZ'`, apiEvents, false},
+		{`tm.event = 'Tx' AND rewards.withdraw.source = 'W'`, apiEvents, false},
+
+		// Values exceeding the capacity of int64 and float64
+		{`big.value >= 99999999999999999999`, bigNumbers, true},
+		{`big.value > 99999999999999999998`, bigNumbers, true},
+		{`big2.value <= 18446744073709551615`, bigNumbers, true},
+		{`big.floatvalue >= 99999999999999999999`, bigNumbers, true},
+		{`big.floatvalue > 99999999999999999998.10`, bigNumbers, true},
+		{`big.floatvalue > 99999999999999999998`, bigNumbers, true},
+		{`big2.floatvalue <= 18446744073709551615.6`, bigNumbers, true},
+		{`big2.floatvalue >= 18446744073709551615`, bigNumbers, true},
+		{`big2.floatvalue >= 12.5`, bigNumbers, true},
+		{`big.value >= 10`, bigNumbers, true},
+
+		// Negative numbers and exponents
+		{`account.balance > -5`, newTestEvents(`account|balance=-3`), true},
+		{`account.balance < -5`, newTestEvents(`account|balance=-3stake`), false},
+		{`account.balance < 0`, newTestEvents(`account|balance=-0.5`), true},
+		{`account.balance = 10000000000`, newTestEvents(`account|balance=1e10`), true},
+		{`account.balance > 1e9`, newTestEvents(`account|balance=2E9stake`), true},
+		{`account.balance < 1e-3`, newTestEvents(`account|balance=0.0005`), true},
+		{`account.balance > 1`, newTestEvents(`account|balance=1eth`), false},
+		{`account.balance > 0`, newTestEvents(`account|balance=stake`), false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.query, func(t *testing.T) {
+			require.Equal(t, tc.matches, MustCompile(tc.query).Matches(tc.events), "events %v", tc.events)
+		})
+	}
+}
+
+func TestAllMatches(t *testing.T) {
+	events := newTestEvents(`Asher|Roth=`, `Route|66=`, `Rilly|Blue=`)
+	for len(events) > 0 {
+		require.True(t, All().Matches(events), "events %v", events)
+		for key := range events {
+			delete(events, key)
+			break
+		}
+	}
+	require.True(t, All().Matches(events))
+	require.True(t, All().Matches(nil))
+	require.False(t, MustCompile("slash EXISTS").Matches(nil))
+}
+
+// newTestEvents returns the events described by the templates, in the format
+// "type|key1=value1|key2=value2"
+func newTestEvents(templates ...string) map[string][]string {
+	events := make(map[string][]string)
+	for _, template := range templates {
+		parts := strings.Split(template, "|")
+		for _, kv := range parts[1:] {
+			key, value, _ := strings.Cut(kv, "=")
+			key = parts[0] + "." + key
+			events[key] = append(events[key], value)
+		}
+	}
+	return events
+}
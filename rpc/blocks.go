@@ -0,0 +1,264 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	abci "github.com/cometbft/cometbft/abci/types"
+	ctypes "github.com/cometbft/cometbft/rpc/core/types"
+	"github.com/cometbft/cometbft/rpc/grpc/client"
+	rpctypes "github.com/cometbft/cometbft/rpc/jsonrpc/types"
+	"github.com/cometbft/cometbft/types"
+	"github.com/cometbft/rpc-companion/storage"
+)
+
+var (
+	maxBlockchainInfoHeights int64 = 20 // Maximum number of block metas returned by the blockchain endpoint
+)
+
+// Block gets the block at a given height. If no height is provided, it fetches
+// the latest stored block.
+// More: https://docs.cometbft.com/main/rpc/#/Info/block
+func (env *Environment) Block(ctx *rpctypes.Context, heightPtr *int64) (*ctypes.ResultBlock, error) {
+	height, err := env.getHeight(ctx.Context(), heightPtr)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := env.block(ctx.Context(), height)
+	if err != nil {
+		return nil, err
+	}
+	return &ctypes.ResultBlock{BlockID: *block.BlockID, Block: block.Block}, nil
+}
+
+// BlockResults gets the ABCI results of the block at a given height. If no
+// height is provided, it fetches the results of the latest stored block.
+// More: https://docs.cometbft.com/main/rpc/#/Info/block_results
+func (env *Environment) BlockResults(ctx *rpctypes.Context, heightPtr *int64) (*ctypes.ResultBlockResults, error) {
+	height, err := env.getHeight(ctx.Context(), heightPtr)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := env.blockResults(ctx.Context(), height)
+	if err != nil {
+		return nil, err
+	}
+
+	finalizeBlockEvents := make([]abci.Event, 0, len(results.FinalizeBlockEvents))
+	for _, event := range results.FinalizeBlockEvents {
+		if event != nil {
+			finalizeBlockEvents = append(finalizeBlockEvents, *event)
+		}
+	}
+	validatorUpdates := make([]abci.ValidatorUpdate, 0, len(results.ValidatorUpdates))
+	for _, update := range results.ValidatorUpdates {
+		if update != nil {
+			validatorUpdates = append(validatorUpdates, *update)
+		}
+	}
+
+	return &ctypes.ResultBlockResults{
+		Height:                height,
+		TxResults:             results.TxResults,
+		FinalizeBlockEvents:   finalizeBlockEvents,
+		ValidatorUpdates:      validatorUpdates,
+		ConsensusParamUpdates: results.ConsensusParamUpdates,
+		AppHash:               results.AppHash,
+	}, nil
+}
+
+// Header gets the header of the block at a given height. If no height is
+// provided, it fetches the header of the latest stored block.
+// More: https://docs.cometbft.com/main/rpc/#/Info/header
+func (env *Environment) Header(ctx *rpctypes.Context, heightPtr *int64) (*ctypes.ResultHeader, error) {
+	height, err := env.getHeight(ctx.Context(), heightPtr)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := env.block(ctx.Context(), height)
+	if err != nil {
+		return nil, err
+	}
+	return &ctypes.ResultHeader{Header: &block.Block.Header}, nil
+}
+
+// Commit gets the canonical commit of the block at a given height, which is
+// the last commit of the block at the next height. If no height is provided,
+// it fetches the commit of the highest block with the next block stored.
+// More: https://docs.cometbft.com/main/rpc/#/Info/commit
+func (env *Environment) Commit(ctx *rpctypes.Context, heightPtr *int64) (*ctypes.ResultCommit, error) {
+	if heightPtr == nil {
+		latestHeight, err := env.latestHeight(ctx.Context())
+		if err != nil {
+			return nil, err
+		}
+		if latestHeight <= 1 {
+			// Only the first block is stored, its commit is in the next block
+			return nil, fmt.Errorf("commit for height %d is not available yet", latestHeight)
+		}
+		height := latestHeight - 1
+		heightPtr = &height
+	}
+	height, err := env.getHeight(ctx.Context(), heightPtr)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := env.block(ctx.Context(), height)
+	if err != nil {
+		return nil, err
+	}
+	next, err := env.block(ctx.Context(), height+1)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("commit for height %d is not available yet", height)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ctypes.NewResultCommit(&block.Block.Header, next.Block.LastCommit, true), nil
+}
+
+// BlockchainInfo gets the block metas in the [minHeight, maxHeight] range, in
+// descending order. At most 20 block metas are returned, and the heights that
+// are not stored are skipped.
+// More: https://docs.cometbft.com/main/rpc/#/Info/blockchain
+func (env *Environment) BlockchainInfo(ctx *rpctypes.Context, minHeight, maxHeight int64) (*ctypes.ResultBlockchainInfo, error) {
+	latestHeight, err := env.latestHeight(ctx.Context())
+	if err != nil {
+		return nil, err
+	}
+	earliestHeight, err := env.earliestHeight(ctx.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	minHeight, maxHeight, err = filterMinMax(earliestHeight, latestHeight, minHeight, maxHeight, maxBlockchainInfoHeights)
+	if err != nil {
+		return nil, err
+	}
+
+	blockMetas := make([]*types.BlockMeta, 0, maxHeight-minHeight+1)
+	for height := maxHeight; height >= minHeight; height-- {
+		block, err := env.storage.GetBlock(ctx.Context(), uint64(height))
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, env.storageError("GetBlock", err, height)
+		}
+		blockMetas = append(blockMetas, &types.BlockMeta{
+			BlockID:   *block.BlockID,
+			BlockSize: block.Block.Size(),
+			Header:    block.Block.Header,
+			NumTxs:    len(block.Block.Txs),
+		})
+	}
+
+	return &ctypes.ResultBlockchainInfo{
+		LastHeight: latestHeight,
+		BlockMetas: blockMetas,
+	}, nil
+}
+
+// BlockSearch searches for a paginated set of blocks matching the finalize
+// block event search criteria.
+// More: https://docs.cometbft.com/main/rpc/#/Info/block_search
+func (env *Environment) BlockSearch(
+	ctx *rpctypes.Context,
+	query string,
+	pagePtr, perPagePtr *int,
+	orderBy string,
+) (*ctypes.ResultBlockSearch, error) {
+	q, page, err := parseSearch(query, pagePtr, perPagePtr, orderBy)
+	if err != nil {
+		return nil, err
+	}
+
+	heights, totalCount, err := env.storage.SearchBlocks(ctx.Context(), q, page)
+	if err != nil {
+		env.logger.Error("Storage", "method", "SearchBlocks", "error", err, "query", query)
+		return nil, fmt.Errorf("error searching the storage")
+	}
+	if _, err := validatePage(pagePtr, page.Limit, totalCount); err != nil {
+		return nil, err
+	}
+
+	apiResults := make([]*ctypes.ResultBlock, 0, len(heights))
+	for _, height := range heights {
+		block, err := env.block(ctx.Context(), int64(height))
+		if err != nil {
+			return nil, err
+		}
+		apiResults = append(apiResults, &ctypes.ResultBlock{BlockID: *block.BlockID, Block: block.Block})
+	}
+
+	return &ctypes.ResultBlockSearch{Blocks: apiResults, TotalCount: totalCount}, nil
+}
+
+// block returns the stored block at a height, or fetches it from the node if
+// it is missing and the fallback is enabled
+func (env *Environment) block(ctx context.Context, height int64) (*client.Block, error) {
+	block, err := env.storage.GetBlock(ctx, uint64(height))
+	if errors.Is(err, storage.ErrNotFound) && env.fallback != nil {
+		return env.fallback.block(ctx, height)
+	}
+	if err != nil {
+		return nil, env.storageError("GetBlock", err, height)
+	}
+	if block.BlockID == nil || block.Block == nil {
+		env.logger.Error("Stored block is incomplete", "height", height)
+		return nil, fmt.Errorf("error reading from the storage")
+	}
+	return block, nil
+}
+
+// blockResults returns the stored block results at a height, or fetches them
+// from the node if they are missing and the fallback is enabled
+func (env *Environment) blockResults(ctx context.Context, height int64) (*client.BlockResults, error) {
+	results, err := env.storage.GetBlockResults(ctx, uint64(height))
+	if errors.Is(err, storage.ErrNotFound) && env.fallback != nil {
+		return env.fallback.blockResults(ctx, height)
+	}
+	if err != nil {
+		return nil, env.storageError("GetBlockResults", err, height)
+	}
+	return results, nil
+}
+
+// filterMinMax returns error if either min or max are negative or min > max.
+// If 0 is passed for min, it will be set to 1. If 0 is passed for max, it will
+// be set to the latest height. The range is limited to the stored heights and
+// to `limit` heights below max, like in CometBFT.
+func filterMinMax(base, height, minHeight, maxHeight, limit int64) (int64, int64, error) {
+	// filter negatives
+	if minHeight < 0 || maxHeight < 0 {
+		return minHeight, maxHeight, fmt.Errorf("heights must be non-negative")
+	}
+
+	// adjust for default values
+	if minHeight == 0 {
+		minHeight = 1
+	}
+	if maxHeight == 0 {
+		maxHeight = height
+	}
+
+	// limit max to the height
+	maxHeight = min(height, maxHeight)
+
+	// limit min to the base
+	minHeight = max(base, minHeight)
+
+	// limit min to within `limit` of max
+	// so the total number of blocks returned will be `limit`
+	minHeight = max(minHeight, maxHeight-limit+1)
+
+	if minHeight > maxHeight {
+		return minHeight, maxHeight, fmt.Errorf("min height %d can't be greater than max height %d", minHeight, maxHeight)
+	}
+	return minHeight, maxHeight, nil
+}
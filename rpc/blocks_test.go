@@ -0,0 +1,203 @@
+package rpc
+
+import (
+	"testing"
+
+	"github.com/cometbft/rpc-companion/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlock(t *testing.T) {
+	testCases := []struct {
+		name     string
+		height   *int64
+		fallback bool
+		want     int64
+		err      string
+		notFound bool
+	}{
+		{name: "latest", height: nil, want: 8},
+		{name: "stored", height: ptr[int64](3), want: 3},
+		{name: "out of range", height: ptr[int64](9), err: "height 9 must be less than or equal to the current blockchain height 8"},
+		{name: "fetched from the node", height: ptr[int64](9), fallback: true, want: 9},
+		{name: "missing in the node", height: ptr[int64](11), fallback: true, notFound: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env, _ := newTestEnv(t, defaultTestChain, tc.fallback)
+			res, err := env.Block(testContext(), tc.height)
+			switch {
+			case tc.notFound:
+				require.ErrorIs(t, err, storage.ErrNotFound)
+			case tc.err != "":
+				require.EqualError(t, err, tc.err)
+			default:
+				require.NoError(t, err)
+				require.Equal(t, tc.want, res.Block.Height)
+				require.Equal(t, res.Block.Hash(), res.BlockID.Hash)
+			}
+		})
+	}
+}
+
+func TestBlockBelowNodeBase(t *testing.T) {
+	// The node pruned the heights below 2, the fallback doesn't request them
+	env, node := newTestEnv(t, defaultTestChain, true)
+	node.Prune(2)
+
+	_, err := env.Block(testContext(), ptr[int64](1))
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	res, err := env.Block(testContext(), ptr[int64](2))
+	require.NoError(t, err)
+	require.Equal(t, int64(2), res.Block.Height)
+}
+
+func TestBlockResults(t *testing.T) {
+	testCases := []struct {
+		name     string
+		height   *int64
+		fallback bool
+		want     int64
+		err      string
+		notFound bool
+	}{
+		{name: "latest", height: nil, want: 8},
+		{name: "stored", height: ptr[int64](5), want: 5},
+		{name: "out of range", height: ptr[int64](2), err: "height 2 is not available, lowest height is 3"},
+		{name: "fetched from the node", height: ptr[int64](2), fallback: true, want: 2},
+		{name: "missing in the node", height: ptr[int64](11), fallback: true, notFound: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env, _ := newTestEnv(t, defaultTestChain, tc.fallback)
+			res, err := env.BlockResults(testContext(), tc.height)
+			switch {
+			case tc.notFound:
+				require.ErrorIs(t, err, storage.ErrNotFound)
+			case tc.err != "":
+				require.EqualError(t, err, tc.err)
+			default:
+				require.NoError(t, err)
+				require.Equal(t, tc.want, res.Height)
+				require.Len(t, res.TxResults, 1)
+				require.Len(t, res.FinalizeBlockEvents, 1)
+			}
+		})
+	}
+}
+
+func TestHeader(t *testing.T) {
+	env, _ := newTestEnv(t, defaultTestChain, false)
+
+	res, err := env.Header(testContext(), ptr[int64](4))
+	require.NoError(t, err)
+	require.Equal(t, int64(4), res.Header.Height)
+	require.Equal(t, "test-chain", res.Header.ChainID)
+
+	_, err = env.Header(testContext(), ptr[int64](0))
+	require.Error(t, err)
+}
+
+func TestCommit(t *testing.T) {
+	testCases := []struct {
+		name     string
+		chain    testChain
+		height   *int64
+		fallback bool
+		want     int64
+		err      string
+	}{
+		{name: "latest", chain: defaultTestChain, height: nil, want: 7},
+		{name: "stored", chain: defaultTestChain, height: ptr[int64](5), want: 5},
+		{name: "next block missing", chain: defaultTestChain, height: ptr[int64](8), err: "commit for height 8 is not available yet"},
+		{name: "next block fetched from the node", chain: defaultTestChain, height: ptr[int64](8), fallback: true, want: 8},
+		{name: "latest of a single block", chain: testChain{produced: 3, from: 1, to: 1}, height: nil, err: "commit for height 1 is not available yet"},
+		{name: "latest of an empty storage", chain: testChain{produced: 3}, height: nil, err: "blocks not found"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env, _ := newTestEnv(t, tc.chain, tc.fallback)
+			res, err := env.Commit(testContext(), tc.height)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, res.CanonicalCommit)
+			require.Equal(t, tc.want, res.Header.Height)
+			require.Equal(t, tc.want, res.Commit.Height)
+			require.Equal(t, res.Header.Hash(), res.Commit.BlockID.Hash)
+		})
+	}
+}
+
+func TestBlockchainInfo(t *testing.T) {
+	testCases := []struct {
+		name      string
+		min, max  int64
+		want      []int64
+		errString string
+	}{
+		{name: "defaults", min: 0, max: 0, want: []int64{8, 7, 6, 5, 4, 3}},
+		{name: "range", min: 4, max: 6, want: []int64{6, 5, 4}},
+		{name: "limited to the stored heights", min: 1, max: 20, want: []int64{8, 7, 6, 5, 4, 3}},
+		{name: "negative", min: -1, max: 0, errString: "heights must be non-negative"},
+		{name: "min above max", min: 7, max: 5, errString: "min height 7 can't be greater than max height 5"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env, _ := newTestEnv(t, defaultTestChain, false)
+			res, err := env.BlockchainInfo(testContext(), tc.min, tc.max)
+			if tc.errString != "" {
+				require.EqualError(t, err, tc.errString)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, int64(8), res.LastHeight)
+			heights := make([]int64, 0, len(res.BlockMetas))
+			for _, meta := range res.BlockMetas {
+				heights = append(heights, meta.Header.Height)
+				require.Equal(t, 1, meta.NumTxs)
+			}
+			require.Equal(t, tc.want, heights)
+		})
+	}
+}
+
+func TestBlockSearch(t *testing.T) {
+	testCases := []struct {
+		name    string
+		query   string
+		page    *int
+		perPage *int
+		orderBy string
+		want    []int64
+		total   int
+		err     bool
+	}{
+		{name: "event", query: "block.height > 6", want: []int64{7, 8}, total: 2},
+		{name: "descending", query: "block.height >= 3", perPage: ptr(2), orderBy: "desc", want: []int64{8, 7}, total: 6},
+		{name: "second page", query: "block.height >= 3", page: ptr(2), perPage: ptr(4), want: []int64{7, 8}, total: 6},
+		{name: "no match", query: "block.height > 100", want: []int64{}, total: 0},
+		{name: "page out of range", query: "block.height > 6", page: ptr(2), err: true},
+		{name: "invalid order", query: "block.height > 6", orderBy: "random", err: true},
+		{name: "invalid query", query: "block.height >", err: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env, _ := newTestEnv(t, defaultTestChain, false)
+			res, err := env.BlockSearch(testContext(), tc.query, tc.page, tc.perPage, tc.orderBy)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.total, res.TotalCount)
+			heights := make([]int64, 0, len(res.Blocks))
+			for _, block := range res.Blocks {
+				heights = append(heights, block.Block.Height)
+			}
+			require.Equal(t, tc.want, heights)
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/cometbft/rpc-companion/config"
+	"github.com/cometbft/rpc-companion/ingest"
+	"github.com/cometbft/rpc-companion/libs/pubsub"
+	"github.com/cometbft/rpc-companion/libs/query"
+	"github.com/cometbft/rpc-companion/storage"
+)
+
+const (
+	// Default and maximum number of results per page of the search endpoints
+	defaultPerPage = 30
+	maxPerPage     = 100
+
+	// maxQueryLength is the maximum length of a query string that will be
+	// accepted. This is just a safety check to avoid outlandish queries.
+	maxQueryLength = 512
+)
+
+// Environment contains the objects needed to answer the JSON-RPC requests
+type Environment struct {
+	config   *config.Config
+	storage  storage.IStorage
+	eventBus *pubsub.Server
+	// Fetches the data missing in the storage from the node, nil if disabled
+	fallback *fallback
+	logger   slog.Logger
+}
+
+// NewEnvironment creates the environment of the JSON-RPC handlers. The fetcher
+// is only used by the fallback to the node, and may be nil if it is disabled.
+func NewEnvironment(
+	logger slog.Logger,
+	cfg *config.Config,
+	db storage.IStorage,
+	eventBus *pubsub.Server,
+	fetcher *ingest.Fetcher,
+) *Environment {
+	env := &Environment{
+		config:   cfg,
+		storage:  db,
+		eventBus: eventBus,
+		logger:   *logger.With("module", "Environment"),
+	}
+	if cfg.RPC.Fallback && fetcher != nil {
+		env.fallback = newFallback(logger, fetcher, cfg.RPC.FallbackPersist)
+	}
+	return env
+}
+
+// getHeight returns the height requested, or the latest stored height if
+// heightPtr is nil. It returns an error if the height is out of the stored
+// range, unless the fallback to the node is enabled.
+func (env *Environment) getHeight(ctx context.Context, heightPtr *int64) (int64, error) {
+	if heightPtr == nil {
+		return env.latestHeight(ctx)
+	}
+
+	height := *heightPtr
+	if height <= 0 {
+		return 0, fmt.Errorf("height must be greater than 0, but got %d", height)
+	}
+	if env.fallback != nil {
+		// The heights out of the stored range are fetched from the node
+		return height, nil
+	}
+
+	latestHeight, err := env.latestHeight(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if height > latestHeight {
+		return 0, fmt.Errorf("height %d must be less than or equal to the current blockchain height %d", height, latestHeight)
+	}
+	earliestHeight, err := env.earliestHeight(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if height < earliestHeight {
+		return 0, fmt.Errorf("height %d is not available, lowest height is %d", height, earliestHeight)
+	}
+	return height, nil
+}
+
+// latestHeight returns the highest height with a block stored
+func (env *Environment) latestHeight(ctx context.Context) (int64, error) {
+	height, err := env.storage.GetLatestHeight(ctx)
+	if err != nil {
+		return 0, env.storageError("GetLatestHeight", err, 0)
+	}
+	return int64(height), nil
+}
+
+// earliestHeight returns the lowest height with a block stored
+func (env *Environment) earliestHeight(ctx context.Context) (int64, error) {
+	height, err := env.storage.GetEarliestHeight(ctx)
+	if err != nil {
+		return 0, env.storageError("GetEarliestHeight", err, 0)
+	}
+	return int64(height), nil
+}
+
+// storageError maps a storage error to the error returned to the client. The
+// details of unexpected errors are logged but not returned.
+func (env *Environment) storageError(method string, err error, height int64) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("request canceled: %w", err)
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		if height == 0 {
+			return fmt.Errorf("blocks %w", storage.ErrNotFound)
+		}
+		return fmt.Errorf("height %d %w", height, storage.ErrNotFound)
+	}
+	env.logger.Error("Storage", "method", method, "error", err, "height", height)
+	return fmt.Errorf("error reading from the storage")
+}
+
+// validatePage returns an error if the page is out of the range of pages with
+// results. An empty result has one page.
+func validatePage(pagePtr *int, perPage, totalCount int) (int, error) {
+	if pagePtr == nil { // no page parameter
+		return 1, nil
+	}
+
+	pages := ((totalCount - 1) / perPage) + 1
+	if pages == 0 {
+		pages = 1 // one page (even if it's empty)
+	}
+	page := *pagePtr
+	if page <= 0 || page > pages {
+		return 1, fmt.Errorf("page should be within [1, %d] range, given %d", pages, page)
+	}
+
+	return page, nil
+}
+
+// validatePerPage returns the number of results per page, between 1 and
+// maxPerPage
+func validatePerPage(perPagePtr *int) int {
+	if perPagePtr == nil { // no per_page parameter
+		return defaultPerPage
+	}
+
+	perPage := *perPagePtr
+	if perPage < 1 {
+		return defaultPerPage
+	} else if perPage > maxPerPage {
+		return maxPerPage
+	}
+	return perPage
+}
+
+// parseSearch validates the search parameters and returns the parsed query and
+// the storage pagination of the requested page. The page is validated against
+// the total number of results once the search is done.
+func parseSearch(q string, pagePtr, perPagePtr *int, orderBy string) (*query.Query, storage.Pagination, error) {
+	var page storage.Pagination
+	parsed, err := parseQuery(q)
+	if err != nil {
+		return nil, page, err
+	}
+
+	switch orderBy {
+	case "desc":
+		page.Descending = true
+	case "asc", "":
+	default:
+		return nil, page, errors.New("expected order_by to be either `asc` or `desc` or empty")
+	}
+
+	page.Limit = validatePerPage(perPagePtr)
+	if pagePtr != nil && *pagePtr > 1 {
+		page.Offset = (*pagePtr - 1) * page.Limit
+	}
+	return parsed, page, nil
+}
+
+// parseQuery parses an event query, rejecting the queries longer than
+// maxQueryLength
+func parseQuery(q string) (*query.Query, error) {
+	if len(q) > maxQueryLength {
+		return nil, errors.New("maximum query length exceeded")
+	}
+	return query.New(q)
+}
@@ -0,0 +1,149 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	rpctypes "github.com/cometbft/cometbft/rpc/jsonrpc/types"
+	"github.com/cometbft/rpc-companion/config"
+	"github.com/cometbft/rpc-companion/ingest"
+	"github.com/cometbft/rpc-companion/storage"
+	"github.com/cometbft/rpc-companion/test/fakenode"
+	"github.com/stretchr/testify/require"
+)
+
+// testChain describes the blocks produced by the fake node and the heights
+// stored in the memory storage
+type testChain struct {
+	produced int
+	from, to uint64
+}
+
+// defaultTestChain has 10 blocks, with the heights from 3 to 8 stored
+var defaultTestChain = testChain{produced: 10, from: 3, to: 8}
+
+// newTestEnv returns an environment answering from a memory storage, with the
+// heights of the chain fetched from a fake node, and the fallback to the node
+// enabled if requested
+func newTestEnv(t *testing.T, chain testChain, fallback bool) (*Environment, *fakenode.Node) {
+	t.Helper()
+	node := fakenode.New("test-chain")
+	require.NoError(t, node.Start())
+	t.Cleanup(node.Stop)
+	node.ProduceBlocks(chain.produced)
+
+	cfg := config.DefaultConfig()
+	cfg.GRPCClient.ListenAddress = node.Address()
+	cfg.GRPCClient.ListenAddressPrivileged = node.PrivilegedAddress()
+	cfg.RPC.Fallback = fallback
+
+	logger := *slog.New(slog.NewTextHandler(io.Discard, nil))
+	db := storage.NewMemoryStorage()
+	fetcher, err := ingest.NewFetcher(logger, cfg, db)
+	require.NoError(t, err)
+	t.Cleanup(func() { fetcher.Close() }) //nolint:errcheck
+
+	ctx := context.Background()
+	for height := chain.from; height <= chain.to && height > 0; height++ {
+		block, err := fetcher.GetBlock(ctx, int64(height))
+		require.NoError(t, err)
+		blockResults, err := fetcher.GetBlockResults(ctx, int64(height))
+		require.NoError(t, err)
+		require.NoError(t, db.InsertHeight(ctx, height, block, blockResults))
+	}
+
+	return NewEnvironment(logger, cfg, db, nil, fetcher), node
+}
+
+// testContext returns the context of a request without a connection
+func testContext() *rpctypes.Context {
+	return &rpctypes.Context{}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestGetHeight(t *testing.T) {
+	testCases := []struct {
+		name     string
+		height   *int64
+		fallback bool
+		want     int64
+		err      string
+	}{
+		{name: "latest", height: nil, want: 8},
+		{name: "stored", height: ptr[int64](5), want: 5},
+		{name: "zero", height: ptr[int64](0), err: "height must be greater than 0, but got 0"},
+		{name: "negative", height: ptr[int64](-1), err: "height must be greater than 0, but got -1"},
+		{name: "above latest", height: ptr[int64](9), err: "height 9 must be less than or equal to the current blockchain height 8"},
+		{name: "below earliest", height: ptr[int64](2), err: "height 2 is not available, lowest height is 3"},
+		{name: "above latest with fallback", height: ptr[int64](9), fallback: true, want: 9},
+		{name: "below earliest with fallback", height: ptr[int64](2), fallback: true, want: 2},
+		{name: "zero with fallback", height: ptr[int64](0), fallback: true, err: "height must be greater than 0, but got 0"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env, _ := newTestEnv(t, defaultTestChain, tc.fallback)
+			height, err := env.getHeight(context.Background(), tc.height)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, height)
+		})
+	}
+}
+
+func TestGetHeightEmptyStorage(t *testing.T) {
+	env, _ := newTestEnv(t, testChain{produced: 3}, false)
+	_, err := env.getHeight(context.Background(), nil)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = env.getHeight(context.Background(), ptr[int64](1))
+	require.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestValidatePage(t *testing.T) {
+	testCases := []struct {
+		page       *int
+		perPage    int
+		totalCount int
+		want       int
+		err        bool
+	}{
+		{page: nil, perPage: 10, totalCount: 0, want: 1},
+		{page: ptr(1), perPage: 10, totalCount: 0, want: 1},
+		{page: ptr(2), perPage: 10, totalCount: 0, err: true},
+		{page: ptr(0), perPage: 10, totalCount: 15, err: true},
+		{page: ptr(2), perPage: 10, totalCount: 15, want: 2},
+		{page: ptr(3), perPage: 10, totalCount: 20, err: true},
+	}
+	for _, tc := range testCases {
+		page, err := validatePage(tc.page, tc.perPage, tc.totalCount)
+		if tc.err {
+			require.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tc.want, page)
+	}
+}
+
+func TestParseSearch(t *testing.T) {
+	_, page, err := parseSearch("tx.height > 1", ptr(3), ptr(10), "desc")
+	require.NoError(t, err)
+	require.Equal(t, storage.Pagination{Limit: 10, Offset: 20, Descending: true}, page)
+
+	_, page, err = parseSearch("tx.height > 1", nil, ptr(1000), "")
+	require.NoError(t, err)
+	require.Equal(t, storage.Pagination{Limit: maxPerPage}, page)
+
+	_, _, err = parseSearch("tx.height > 1", nil, nil, "random")
+	require.EqualError(t, err, "expected order_by to be either `asc` or `desc` or empty")
+
+	_, _, err = parseSearch("tx.height >", nil, nil, "")
+	require.Error(t, err)
+}
@@ -0,0 +1,101 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	ctypes "github.com/cometbft/cometbft/rpc/core/types"
+	rpctypes "github.com/cometbft/cometbft/rpc/jsonrpc/types"
+	"github.com/cometbft/cometbft/types"
+	"github.com/cometbft/rpc-companion/libs/pubsub"
+)
+
+// writeTimeout bounds the time spent writing an event to a subscriber
+const writeTimeout = 10 * time.Second
+
+// Subscribe for events via WebSocket. The events are published once the block
+// and its results are stored, see events.Publisher.
+// More: https://docs.cometbft.com/main/rpc/#/Websocket/subscribe
+func (env *Environment) Subscribe(ctx *rpctypes.Context, query string) (*ctypes.ResultSubscribe, error) {
+	addr := ctx.RemoteAddr()
+
+	if env.eventBus.NumClientSubscriptions(addr) == 0 && env.eventBus.NumClients() >= env.config.RPC.MaxSubscriptionClients {
+		return nil, fmt.Errorf("max_subscription_clients %d reached", env.config.RPC.MaxSubscriptionClients)
+	} else if env.eventBus.NumClientSubscriptions(addr) >= env.config.RPC.MaxSubscriptionsPerClient {
+		return nil, fmt.Errorf("max_subscriptions_per_client %d reached", env.config.RPC.MaxSubscriptionsPerClient)
+	}
+
+	q, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := env.eventBus.Subscribe(addr, q, env.config.RPC.SubscriptionBufferSize)
+	if err != nil {
+		return nil, err
+	}
+	env.logger.Info("Subscribe to query", "remote", addr, "query", query)
+
+	// Capture the current ID, since it can change in the future.
+	subscriptionID := ctx.JSONReq.ID
+	go func() {
+		for {
+			select {
+			case msg := <-sub.Out():
+				resp := rpctypes.NewRPCSuccessResponse(subscriptionID, &ctypes.ResultEvent{
+					Query:  query,
+					Data:   msg.Data.(types.TMEventData),
+					Events: msg.Events,
+				})
+				writeCtx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+				err := ctx.WSConn.WriteRPCResponse(writeCtx, resp)
+				cancel()
+				if err != nil {
+					env.logger.Info("Can't write event (slow client)", "remote", addr, "query", query, "error", err)
+					if err := env.eventBus.Unsubscribe(addr, q); err != nil && !errors.Is(err, pubsub.ErrSubscriptionNotFound) {
+						env.logger.Error("Unsubscribe slow client", "remote", addr, "error", err)
+					}
+					return
+				}
+			case <-sub.Canceled():
+				if !errors.Is(sub.Err(), pubsub.ErrUnsubscribed) {
+					resp := rpctypes.RPCServerError(subscriptionID, fmt.Errorf("subscription was canceled (reason: %s)", sub.Err()))
+					if ok := ctx.WSConn.TryWriteRPCResponse(resp); !ok {
+						env.logger.Info("Can't write canceled subscription response", "remote", addr, "query", query)
+					}
+				}
+				return
+			}
+		}
+	}()
+
+	return &ctypes.ResultSubscribe{}, nil
+}
+
+// Unsubscribe from events via WebSocket.
+// More: https://docs.cometbft.com/main/rpc/#/Websocket/unsubscribe
+func (env *Environment) Unsubscribe(ctx *rpctypes.Context, query string) (*ctypes.ResultUnsubscribe, error) {
+	addr := ctx.RemoteAddr()
+	q, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if err := env.eventBus.Unsubscribe(addr, q); err != nil {
+		return nil, err
+	}
+	env.logger.Info("Unsubscribe from query", "remote", addr, "query", query)
+	return &ctypes.ResultUnsubscribe{}, nil
+}
+
+// UnsubscribeAll from all events via WebSocket.
+// More: https://docs.cometbft.com/main/rpc/#/Websocket/unsubscribe_all
+func (env *Environment) UnsubscribeAll(ctx *rpctypes.Context) (*ctypes.ResultUnsubscribe, error) {
+	addr := ctx.RemoteAddr()
+	if err := env.eventBus.UnsubscribeAll(addr); err != nil {
+		return nil, err
+	}
+	env.logger.Info("Unsubscribe from all", "remote", addr)
+	return &ctypes.ResultUnsubscribe{}, nil
+}
@@ -0,0 +1,139 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/cometbft/cometbft/rpc/grpc/client"
+	"github.com/cometbft/rpc-companion/ingest"
+	"github.com/cometbft/rpc-companion/storage"
+)
+
+// How long the lowest heights retained by the node are cached. The node only
+// raises them, so a stale value lets a request reach the node for a pruned
+// height, which is answered as not found.
+const retainedCacheTTL = 5 * time.Second
+
+// fallback fetches the blocks and block results missing in the storage from
+// the node, either because they are newer than the last ingested height or
+// because they were never stored. Only the heights retained by the node are
+// requested.
+type fallback struct {
+	fetcher *ingest.Fetcher
+	// Store the fetched data, verified like the ingested data, so the next
+	// requests are answered from the storage
+	persist bool
+	logger  slog.Logger
+
+	// Lowest block and block results heights retained by the node, and when
+	// they were read
+	mtx                sync.Mutex
+	lowest             uint64
+	lowestBlockResults uint64
+	lowestRead         time.Time
+}
+
+func newFallback(logger slog.Logger, fetcher *ingest.Fetcher, persist bool) *fallback {
+	return &fallback{
+		fetcher: fetcher,
+		persist: persist,
+		logger:  *logger.With("module", "Fallback"),
+	}
+}
+
+// block fetches the block at a height from the node. The returned error wraps
+// storage.ErrNotFound if the node doesn't have the block.
+func (f *fallback) block(ctx context.Context, height int64) (*client.Block, error) {
+	if err := f.checkRetained(ctx, height, false); err != nil {
+		return nil, err
+	}
+
+	block, err := f.fetcher.GetBlock(ctx, height)
+	if err != nil {
+		return nil, fmt.Errorf("height %d %w", height, storage.ErrNotFound)
+	}
+	f.logger.Info("Fetched block from the node", "height", height)
+
+	if f.persist {
+		if err := f.store(ctx, storage.HeightData{Height: uint64(height), Block: block}); err != nil {
+			return nil, err
+		}
+	}
+	return block, nil
+}
+
+// blockResults fetches the block results at a height from the node. The
+// returned error wraps storage.ErrNotFound if the node doesn't have them.
+func (f *fallback) blockResults(ctx context.Context, height int64) (*client.BlockResults, error) {
+	if err := f.checkRetained(ctx, height, true); err != nil {
+		return nil, err
+	}
+
+	results, err := f.fetcher.GetBlockResults(ctx, height)
+	if err != nil {
+		return nil, fmt.Errorf("height %d %w", height, storage.ErrNotFound)
+	}
+	f.logger.Info("Fetched block results from the node", "height", height)
+
+	if f.persist {
+		if err := f.store(ctx, storage.HeightData{Height: uint64(height), BlockResults: results}); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// checkRetained returns an error wrapping storage.ErrNotFound if the height is
+// below the lowest block or block results height retained by the node, as it
+// may already be pruned
+func (f *fallback) checkRetained(ctx context.Context, height int64, blockResults bool) error {
+	lowest, lowestBlockResults, err := f.lowestRetained(ctx)
+	if err != nil {
+		f.logger.Error("Get node retain heights", "error", err)
+		return fmt.Errorf("error reading from the node")
+	}
+	if blockResults {
+		lowest = lowestBlockResults
+	}
+	if uint64(height) < lowest {
+		return fmt.Errorf("height %d %w, the node retains heights from %d", height, storage.ErrNotFound, lowest)
+	}
+	return nil
+}
+
+// lowestRetained returns the lowest block and block results heights retained
+// by the node, read at most once per retainedCacheTTL
+func (f *fallback) lowestRetained(ctx context.Context) (uint64, uint64, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if !f.lowestRead.IsZero() && time.Since(f.lowestRead) < retainedCacheTTL {
+		return f.lowest, f.lowestBlockResults, nil
+	}
+	lowest, lowestBlockResults, err := f.fetcher.GetBackfillLowerBounds(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	f.lowest, f.lowestBlockResults, f.lowestRead = lowest, lowestBlockResults, time.Now()
+	return lowest, lowestBlockResults, nil
+}
+
+// store persists fetched data through the fetcher, which verifies it like the
+// ingested data. The data failing verification is quarantined and not served.
+// Other errors are only logged: the ingest service may have stored the same
+// height in the meantime, which is not an error as the inserts are idempotent.
+func (f *fallback) store(ctx context.Context, data storage.HeightData) error {
+	err := f.fetcher.StoreHeight(ctx, data)
+	if errors.Is(err, ingest.ErrQuarantined) {
+		f.logger.Error("Fetched data failed verification", "error", err, "height", data.Height)
+		return fmt.Errorf("error verifying height %d fetched from the node", data.Height)
+	}
+	if err != nil {
+		f.logger.Error("Persist fetched data", "error", err, "height", data.Height)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cometbft/rpc-companion/storage"
+	"github.com/cometbft/rpc-companion/test/fakenode"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFallbackPersist(t *testing.T) {
+	testCases := []struct {
+		name    string
+		persist bool
+	}{
+		{name: "persisted", persist: true},
+		{name: "not persisted", persist: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env, _ := newTestEnv(t, defaultTestChain, true)
+			env.fallback.persist = tc.persist
+
+			_, err := env.Block(testContext(), ptr[int64](9))
+			require.NoError(t, err)
+			_, err = env.BlockResults(testContext(), ptr[int64](9))
+			require.NoError(t, err)
+
+			_, err = env.storage.GetBlock(context.Background(), 9)
+			_, resultsErr := env.storage.GetBlockResults(context.Background(), 9)
+			if tc.persist {
+				require.NoError(t, err)
+				require.NoError(t, resultsErr)
+			} else {
+				require.ErrorIs(t, err, storage.ErrNotFound)
+				require.ErrorIs(t, resultsErr, storage.ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestFallbackRetainedCache(t *testing.T) {
+	env, node := newTestEnv(t, defaultTestChain, true)
+	env.fallback.persist = false
+	node.Prune(2)
+
+	_, err := env.Block(testContext(), ptr[int64](9))
+	require.NoError(t, err)
+
+	// The retain heights read by the first request are reused
+	node.InjectError(fakenode.MethodGetBlockRetainHeight, nil, 1)
+	_, err = env.Block(testContext(), ptr[int64](10))
+	require.NoError(t, err)
+	_, err = env.BlockResults(testContext(), ptr[int64](1))
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	// They are read again once expired
+	env.fallback.lowestRead = time.Now().Add(-retainedCacheTTL)
+	_, err = env.Block(testContext(), ptr[int64](9))
+	require.EqualError(t, err, "error reading from the node")
+	_, err = env.Block(testContext(), ptr[int64](9))
+	require.NoError(t, err)
+}
@@ -0,0 +1,31 @@
+package rpc
+
+import (
+	"log/slog"
+
+	cmtlog "github.com/cometbft/cometbft/libs/log"
+)
+
+// cmtLogger adapts a slog logger to the logger used by the CometBFT JSON-RPC
+// server
+type cmtLogger struct {
+	logger slog.Logger
+}
+
+var _ cmtlog.Logger = cmtLogger{}
+
+func (l cmtLogger) Debug(msg string, keyvals ...interface{}) {
+	l.logger.Debug(msg, keyvals...)
+}
+
+func (l cmtLogger) Info(msg string, keyvals ...interface{}) {
+	l.logger.Info(msg, keyvals...)
+}
+
+func (l cmtLogger) Error(msg string, keyvals ...interface{}) {
+	l.logger.Error(msg, keyvals...)
+}
+
+func (l cmtLogger) With(keyvals ...interface{}) cmtlog.Logger {
+	return cmtLogger{logger: *l.logger.With(keyvals...)}
+}
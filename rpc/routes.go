@@ -0,0 +1,39 @@
+package rpc
+
+import (
+	rpc "github.com/cometbft/cometbft/rpc/jsonrpc/server"
+)
+
+// RoutesMap maps the JSON-RPC endpoints to their handlers
+type RoutesMap map[string]*rpc.RPCFunc
+
+// GetRoutes returns the endpoints served by the companion. The endpoints and
+// their arguments are the same as in CometBFT.
+func (env *Environment) GetRoutes() RoutesMap {
+	return RoutesMap{
+		// info API
+		"block":         rpc.NewRPCFunc(env.Block, "height", rpc.Cacheable("height")),
+		"block_results": rpc.NewRPCFunc(env.BlockResults, "height", rpc.Cacheable("height")),
+		"header":        rpc.NewRPCFunc(env.Header, "height", rpc.Cacheable("height")),
+		"commit":        rpc.NewRPCFunc(env.Commit, "height", rpc.Cacheable("height")),
+		"blockchain":    rpc.NewRPCFunc(env.BlockchainInfo, "minHeight,maxHeight", rpc.Cacheable()),
+		"status":        rpc.NewRPCFunc(env.Status, ""),
+
+		// tx API
+		"tx":           rpc.NewRPCFunc(env.Tx, "hash,prove", rpc.Cacheable()),
+		"tx_search":    rpc.NewRPCFunc(env.TxSearch, "query,prove,page,per_page,order_by"),
+		"block_search": rpc.NewRPCFunc(env.BlockSearch, "query,page,per_page,order_by"),
+	}
+}
+
+// GetWebsocketRoutes returns the endpoints served on the /websocket endpoint,
+// the subscription endpoints along with all the HTTP endpoints
+func (env *Environment) GetWebsocketRoutes() RoutesMap {
+	routes := env.GetRoutes()
+
+	// subscribe/unsubscribe are reserved for websocket events.
+	routes["subscribe"] = rpc.NewWSRPCFunc(env.Subscribe, "query")
+	routes["unsubscribe"] = rpc.NewWSRPCFunc(env.Unsubscribe, "query")
+	routes["unsubscribe_all"] = rpc.NewWSRPCFunc(env.UnsubscribeAll, "")
+	return routes
+}
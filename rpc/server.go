@@ -0,0 +1,103 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+
+	rpcserver "github.com/cometbft/cometbft/rpc/jsonrpc/server"
+	"github.com/cometbft/rpc-companion/config"
+	"github.com/cometbft/rpc-companion/ingest"
+	"github.com/cometbft/rpc-companion/libs/pubsub"
+	"github.com/cometbft/rpc-companion/libs/service"
+	"github.com/cometbft/rpc-companion/storage"
+)
+
+// Server serves the CometBFT JSON-RPC endpoints from the storage
+type Server struct {
+	service.BaseService
+	config   *config.Config
+	env      *Environment
+	logger   slog.Logger
+	listener net.Listener
+}
+
+func NewServer(
+	logger slog.Logger,
+	cfg *config.Config,
+	db storage.IStorage,
+	eventBus *pubsub.Server,
+	fetcher *ingest.Fetcher,
+) *Server {
+	logger = *logger.With("service", "RPC")
+
+	server := &Server{
+		config: cfg,
+		env:    NewEnvironment(logger, cfg, db, eventBus, fetcher),
+		logger: logger,
+	}
+	server.BaseService = *service.NewBaseService(logger, "RPC", server)
+	return server
+}
+
+func (s *Server) OnStart() error {
+	logger := *s.logger.With("method", "OnStart")
+
+	rpcLogger := cmtLogger{logger: *s.logger.With("module", "JSONRPC")}
+
+	serverConfig := rpcserver.DefaultConfig()
+	serverConfig.MaxOpenConnections = s.config.RPC.MaxOpenConnections
+	serverConfig.MaxBodyBytes = s.config.RPC.MaxBodyBytes
+	serverConfig.MaxHeaderBytes = s.config.RPC.MaxHeaderBytes
+	serverConfig.ReadTimeout = s.config.RPC.ReadTimeout
+	serverConfig.WriteTimeout = s.config.RPC.WriteTimeout
+
+	mux := http.NewServeMux()
+	rpcserver.RegisterRPCFuncs(mux, s.env.GetRoutes(), rpcLogger)
+
+	wm := rpcserver.NewWebsocketManager(s.env.GetWebsocketRoutes(),
+		rpcserver.OnDisconnect(func(remoteAddr string) {
+			// The subscriptions are removed once the client is gone
+			_ = s.env.eventBus.UnsubscribeAll(remoteAddr)
+		}),
+		rpcserver.ReadLimit(serverConfig.MaxBodyBytes),
+	)
+	wm.SetLogger(rpcLogger)
+	mux.HandleFunc("/websocket", wm.WebsocketHandler)
+
+	listener, err := rpcserver.Listen(s.config.RPC.ListenAddress, serverConfig.MaxOpenConnections)
+	if err != nil {
+		logger.Error("Listen", "error", err, "address", s.config.RPC.ListenAddress)
+		return fmt.Errorf("error listening on %s", s.config.RPC.ListenAddress)
+	}
+	s.listener = listener
+
+	handler := rpcserver.RecoverAndLogHandler(mux, rpcLogger)
+	go func() {
+		if err := rpcserver.Serve(listener, handler, rpcLogger, serverConfig); err != nil && !errors.Is(err, net.ErrClosed) {
+			logger.Error("Serve", "error", err)
+		}
+	}()
+
+	logger.Info("Serving JSON-RPC", "address", listener.Addr().String())
+	return nil
+}
+
+func (s *Server) OnStop() {
+	if s.listener != nil {
+		if err := s.listener.Close(); err != nil {
+			s.logger.Error("Close listener", "error", err)
+		}
+	}
+	s.BaseService.OnStop()
+}
+
+// Address returns the address the server is listening on
+func (s *Server) Address() string {
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
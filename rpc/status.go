@@ -0,0 +1,61 @@
+package rpc
+
+import (
+	"github.com/cometbft/cometbft/p2p"
+	ctypes "github.com/cometbft/cometbft/rpc/core/types"
+	rpctypes "github.com/cometbft/cometbft/rpc/jsonrpc/types"
+)
+
+var (
+	moniker = "rpc-companion" // Moniker reported by the status endpoint
+)
+
+// Status returns the range of blocks available in the storage. The companion
+// is not a node, so the node info only reports the network, and there is no
+// validator info.
+// More: https://docs.cometbft.com/main/rpc/#/Info/status
+func (env *Environment) Status(ctx *rpctypes.Context) (*ctypes.ResultStatus, error) {
+	latestHeight, err := env.latestHeight(ctx.Context())
+	if err != nil {
+		return nil, err
+	}
+	latest, err := env.block(ctx.Context(), latestHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	earliestHeight, err := env.earliestHeight(ctx.Context())
+	if err != nil {
+		return nil, err
+	}
+	earliest, err := env.block(ctx.Context(), earliestHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ctypes.ResultStatus{
+		NodeInfo: p2p.DefaultNodeInfo{
+			ProtocolVersion: p2p.ProtocolVersion{
+				Block: latest.Block.Version.Block,
+				App:   latest.Block.Version.App,
+			},
+			Network: latest.Block.ChainID,
+			Moniker: moniker,
+			Other: p2p.DefaultNodeInfoOther{
+				TxIndex:    "on",
+				RPCAddress: env.config.RPC.ListenAddress,
+			},
+		},
+		SyncInfo: ctypes.SyncInfo{
+			LatestBlockHash:     latest.BlockID.Hash,
+			LatestAppHash:       latest.Block.AppHash,
+			LatestBlockHeight:   latestHeight,
+			LatestBlockTime:     latest.Block.Time,
+			EarliestBlockHash:   earliest.BlockID.Hash,
+			EarliestAppHash:     earliest.Block.AppHash,
+			EarliestBlockHeight: earliestHeight,
+			EarliestBlockTime:   earliest.Block.Time,
+			CatchingUp:          false,
+		},
+	}, nil
+}
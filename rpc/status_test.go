@@ -0,0 +1,25 @@
+package rpc
+
+import (
+	"testing"
+
+	"github.com/cometbft/rpc-companion/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatus(t *testing.T) {
+	env, _ := newTestEnv(t, defaultTestChain, false)
+
+	res, err := env.Status(testContext())
+	require.NoError(t, err)
+	require.Equal(t, "test-chain", res.NodeInfo.Network)
+	require.Equal(t, moniker, res.NodeInfo.Moniker)
+	require.Equal(t, int64(8), res.SyncInfo.LatestBlockHeight)
+	require.Equal(t, int64(3), res.SyncInfo.EarliestBlockHeight)
+	require.False(t, res.SyncInfo.CatchingUp)
+
+	// Nothing stored
+	env, _ = newTestEnv(t, testChain{produced: 3}, false)
+	_, err = env.Status(testContext())
+	require.ErrorIs(t, err, storage.ErrNotFound)
+}
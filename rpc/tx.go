@@ -0,0 +1,106 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	ctypes "github.com/cometbft/cometbft/rpc/core/types"
+	rpctypes "github.com/cometbft/cometbft/rpc/jsonrpc/types"
+	"github.com/cometbft/cometbft/types"
+	"github.com/cometbft/rpc-companion/storage"
+)
+
+// Tx allows you to query the transaction results by hash. `nil` could mean the
+// transaction is in the mempool, invalidated, or was not sent in the first
+// place. If the transaction was included more than once, the first inclusion
+// is returned.
+// More: https://docs.cometbft.com/main/rpc/#/Info/tx
+func (env *Environment) Tx(ctx *rpctypes.Context, hash []byte, prove bool) (*ctypes.ResultTx, error) {
+	position, err := env.storage.GetTxPosition(ctx.Context(), hash)
+	if err != nil {
+		return nil, env.txError(err, hash)
+	}
+	return env.tx(ctx.Context(), position, prove)
+}
+
+// tx returns the transaction at a position with its result
+func (env *Environment) tx(ctx context.Context, position storage.TxPosition, prove bool) (*ctypes.ResultTx, error) {
+	height := int64(position.Height)
+
+	block, err := env.block(ctx, height)
+	if err != nil {
+		return nil, err
+	}
+	results, err := env.blockResults(ctx, height)
+	if err != nil {
+		return nil, err
+	}
+
+	index := int(position.Index)
+	if index >= len(block.Block.Txs) || index >= len(results.TxResults) || results.TxResults[index] == nil {
+		env.logger.Error("Stored tx is inconsistent", "height", height, "index", index)
+		return nil, fmt.Errorf("error reading from the storage")
+	}
+
+	var proof types.TxProof
+	if prove {
+		proof = block.Block.Txs.Proof(index)
+	}
+
+	return &ctypes.ResultTx{
+		Hash:     block.Block.Txs[index].Hash(),
+		Height:   height,
+		Index:    position.Index,
+		TxResult: *results.TxResults[index],
+		Tx:       block.Block.Txs[index],
+		Proof:    proof,
+	}, nil
+}
+
+// TxSearch allows you to query for multiple transactions results by their
+// events. It returns a list of transactions (maximum ?per_page entries) and the
+// total count.
+// More: https://docs.cometbft.com/main/rpc/#/Info/tx_search
+func (env *Environment) TxSearch(
+	ctx *rpctypes.Context,
+	query string,
+	prove bool,
+	pagePtr, perPagePtr *int,
+	orderBy string,
+) (*ctypes.ResultTxSearch, error) {
+	q, page, err := parseSearch(query, pagePtr, perPagePtr, orderBy)
+	if err != nil {
+		return nil, err
+	}
+
+	positions, totalCount, err := env.storage.SearchTxs(ctx.Context(), q, page)
+	if err != nil {
+		env.logger.Error("Storage", "method", "SearchTxs", "error", err, "query", query)
+		return nil, fmt.Errorf("error searching the storage")
+	}
+	if _, err := validatePage(pagePtr, page.Limit, totalCount); err != nil {
+		return nil, err
+	}
+
+	apiResults := make([]*ctypes.ResultTx, 0, len(positions))
+	for _, position := range positions {
+		result, err := env.tx(ctx.Context(), position, prove)
+		if err != nil {
+			return nil, err
+		}
+		apiResults = append(apiResults, result)
+	}
+
+	return &ctypes.ResultTxSearch{Txs: apiResults, TotalCount: totalCount}, nil
+}
+
+// txError maps a storage error of a transaction query to the error returned to
+// the client
+func (env *Environment) txError(err error, hash []byte) error {
+	if errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("tx (%X) not found", hash)
+	}
+	env.logger.Error("Storage", "method", "GetTxPosition", "error", err, "hash", fmt.Sprintf("%X", hash))
+	return fmt.Errorf("error reading from the storage")
+}
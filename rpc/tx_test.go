@@ -0,0 +1,74 @@
+package rpc
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cometbft/cometbft/types"
+	"github.com/stretchr/testify/require"
+)
+
+// testTx returns the transaction of the fake node at a height
+func testTx(height int64) types.Tx {
+	return types.Tx(fmt.Sprintf("tx-%d=%d", height, height))
+}
+
+func TestTx(t *testing.T) {
+	env, _ := newTestEnv(t, defaultTestChain, false)
+
+	tx := testTx(5)
+	res, err := env.Tx(testContext(), tx.Hash(), true)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), res.Height)
+	require.Equal(t, uint32(0), res.Index)
+	require.Equal(t, tx, res.Tx)
+	require.Equal(t, []byte("5"), res.TxResult.Data)
+	require.NoError(t, res.Proof.Validate(res.Proof.RootHash))
+
+	// Not stored
+	tx = testTx(9)
+	_, err = env.Tx(testContext(), tx.Hash(), false)
+	require.EqualError(t, err, fmt.Sprintf("tx (%X) not found", tx.Hash()))
+}
+
+func TestTxSearch(t *testing.T) {
+	testCases := []struct {
+		name    string
+		query   string
+		page    *int
+		perPage *int
+		orderBy string
+		want    []int64
+		total   int
+		err     bool
+	}{
+		{name: "attribute", query: "transfer.recipient = 'addr-5'", want: []int64{5}, total: 1},
+		{name: "number", query: "transfer.amount > 60", want: []int64{7, 8}, total: 2},
+		{name: "tx height", query: "tx.height = 4", want: []int64{4}, total: 1},
+		{name: "tx hash", query: fmt.Sprintf("tx.hash = '%X'", testTx(6).Hash()), want: []int64{6}, total: 1},
+		{name: "descending", query: "tx.height >= 3", perPage: ptr(2), orderBy: "desc", want: []int64{8, 7}, total: 6},
+		{name: "second page", query: "tx.height >= 3", page: ptr(2), perPage: ptr(4), want: []int64{7, 8}, total: 6},
+		{name: "no match", query: "transfer.recipient = 'nobody'", want: []int64{}, total: 0},
+		{name: "page out of range", query: "tx.height >= 3", page: ptr(3), perPage: ptr(4), err: true},
+		{name: "invalid order", query: "tx.height >= 3", orderBy: "random", err: true},
+		{name: "invalid query", query: "tx.height >=", err: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			env, _ := newTestEnv(t, defaultTestChain, false)
+			res, err := env.TxSearch(testContext(), tc.query, false, tc.page, tc.perPage, tc.orderBy)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.total, res.TotalCount)
+			heights := make([]int64, 0, len(res.Txs))
+			for _, tx := range res.Txs {
+				heights = append(heights, tx.Height)
+				require.Equal(t, testTx(tx.Height), tx.Tx)
+			}
+			require.Equal(t, tc.want, heights)
+		})
+	}
+}
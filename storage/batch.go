@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// maxBatchParams bounds the number of parameters of a multi-row statement.
+// Larger statements are split, as binding the parameters gets slower with
+// their number.
+const maxBatchParams = 1000
+
+// encodedData is the encoded block or block results at a height
+type encodedData struct {
+	height   uint64
+	encoding Encoding
+	data     []byte
+	// marshal serializes the block or block results in a format, to compare
+	// them with the data stored in another encoding
+	marshal func(format string) ([]byte, error)
+}
+
+// insertData stores the encoded data unless it is already stored, and returns
+// the heights that were inserted. It returns ErrHeightConflict if different
+// data is stored at any height.
+func insertData(tx *sqlTx, table string, data []encodedData) (map[uint64]bool, error) {
+	inserted := make(map[uint64]bool, len(data))
+	for _, chunk := range chunks(data, maxBatchParams/3) {
+		values := make([]any, 0, 3*len(chunk))
+		for _, d := range chunk {
+			values = append(values, d.height, d.data, d.encoding.String())
+		}
+		rows, err := tx.query(fmt.Sprintf("INSERT INTO %s (height, data, encoding) VALUES %s ON CONFLICT (height) DO NOTHING RETURNING height",
+			tx.dialect.table(table), tx.placeholders(len(chunk), 3)), values...)
+		if err != nil {
+			return nil, err
+		}
+		if err := scanHeights(rows, inserted); err != nil {
+			return nil, err
+		}
+	}
+
+	existing := make([]encodedData, 0)
+	for _, d := range data {
+		if !inserted[d.height] {
+			existing = append(existing, d)
+		}
+	}
+	for _, chunk := range chunks(existing, maxBatchParams) {
+		if err := compareData(tx, table, chunk); err != nil {
+			return nil, err
+		}
+	}
+	return inserted, nil
+}
+
+// compareData returns ErrHeightConflict if the stored data is different. The
+// data is compared uncompressed and in the format it is stored with, which may
+// differ from the configured one.
+func compareData(tx *sqlTx, table string, data []encodedData) error {
+	expected := make(map[uint64]encodedData, len(data))
+	heights := make([]any, 0, len(data))
+	for _, d := range data {
+		expected[d.height] = d
+		heights = append(heights, d.height)
+	}
+
+	rows, err := tx.query(fmt.Sprintf("SELECT height, data, encoding FROM %s WHERE height IN %s",
+		tx.dialect.table(table), tx.placeholders(1, len(heights))), heights...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var height uint64
+		var stored []byte
+		var tag string
+		if err := rows.Scan(&height, &stored, &tag); err != nil {
+			return err
+		}
+		equal, err := expected[height].equal(stored, tag)
+		if err != nil {
+			return fmt.Errorf("error comparing %s at height %d: %w", table, height, err)
+		}
+		if !equal {
+			return fmt.Errorf("%w: %s at height %d", ErrHeightConflict, table, height)
+		}
+	}
+	return rows.Err()
+}
+
+// equal reports whether the data stored with the encoding tag is the same
+func (d encodedData) equal(stored []byte, tag string) (bool, error) {
+	encoding, err := ParseEncoding(tag)
+	if err != nil {
+		return false, err
+	}
+	if encoding == d.encoding && bytes.Equal(stored, d.data) {
+		return true, nil
+	}
+
+	storedPayload, err := encoding.decompress(stored)
+	if err != nil {
+		return false, err
+	}
+	payload, err := d.marshal(encoding.Format)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(storedPayload, payload), nil
+}
+
+// clearQuarantine removes the data of a kind quarantined at the heights of
+// the data stored
+func clearQuarantine(tx *sqlTx, kind string, data []encodedData) error {
+	for _, chunk := range chunks(data, maxBatchParams-1) {
+		values := make([]any, 0, len(chunk)+1)
+		for _, d := range chunk {
+			values = append(values, d.height)
+		}
+		values = append(values, kind)
+		_, err := tx.exec(fmt.Sprintf("DELETE FROM %s WHERE height IN %s AND kind=%s",
+			tx.dialect.table("quarantine"), tx.placeholders(1, len(chunk)), tx.dialect.placeholder(len(chunk)+1)), values...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanHeights(rows *sql.Rows, heights map[uint64]bool) error {
+	defer rows.Close()
+	for rows.Next() {
+		var height uint64
+		if err := rows.Scan(&height); err != nil {
+			return err
+		}
+		heights[height] = true
+	}
+	return rows.Err()
+}
+
+// insertRows inserts the rows in a table with multi-row statements, each row
+// has a value per column
+func insertRows(tx *sqlTx, table string, columns []string, rows [][]any) error {
+	for _, chunk := range chunks(rows, maxBatchParams/len(columns)) {
+		values := make([]any, 0, len(columns)*len(chunk))
+		for _, row := range chunk {
+			values = append(values, row...)
+		}
+		_, err := tx.exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES %s",
+			tx.dialect.table(table), strings.Join(columns, ", "), tx.placeholders(len(chunk), len(columns))), values...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// placeholders returns the placeholders of the values of a multi-row
+// statement, e.g. ($1,$2),($3,$4) for 2 rows of 2 columns in Postgres
+func (t *sqlTx) placeholders(rows int, columns int) string {
+	var b strings.Builder
+	for i := 0; i < rows; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('(')
+		for j := 0; j < columns; j++ {
+			if j > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(t.dialect.placeholder(i*columns + j + 1))
+		}
+		b.WriteByte(')')
+	}
+	return b.String()
+}
+
+// chunks splits the items in chunks of at most size items
+func chunks[T any](items []T, size int) [][]T {
+	result := make([][]T, 0, len(items)/size+1)
+	for start := 0; start < len(items); start += size {
+		result = append(result, items[start:min(start+size, len(items))])
+	}
+	return result
+}
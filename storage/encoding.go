@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	blocksvc "github.com/cometbft/cometbft/api/cometbft/services/block/v1"
+	brs "github.com/cometbft/cometbft/api/cometbft/services/block_results/v1"
+	"github.com/cometbft/cometbft/libs/json"
+	"github.com/cometbft/cometbft/rpc/grpc/client"
+	"github.com/cometbft/cometbft/types"
+	"github.com/cometbft/rpc-companion/config"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	// DefaultEncoding is the encoding of the rows stored before the encoding
+	// was configurable
+	DefaultEncoding = Encoding{Format: config.StorageEncodingJSON, Compression: config.StorageCompressionNone}
+
+	// The zstd encoder and decoder are safe for concurrent use with EncodeAll
+	// and DecodeAll
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// Encoding defines how the blocks and block results are serialized in the
+// storage. It is stored along with every row as a tag, e.g. "protobuf+zstd",
+// so the rows stay readable when the configured encoding changes.
+type Encoding struct {
+	// Format of the data, see config.StorageEncodingJSON
+	Format string
+	// Compression of the formatted data, see config.StorageCompressionNone
+	Compression string
+}
+
+// NewEncoding returns the encoding with the given format and compression
+func NewEncoding(format string, compression string) (Encoding, error) {
+	switch format {
+	case config.StorageEncodingJSON, config.StorageEncodingProtobuf:
+	default:
+		return Encoding{}, fmt.Errorf("unknown encoding format %q", format)
+	}
+	switch compression {
+	case config.StorageCompressionNone, config.StorageCompressionZstd, config.StorageCompressionSnappy:
+	default:
+		return Encoding{}, fmt.Errorf("unknown encoding compression %q", compression)
+	}
+	return Encoding{Format: format, Compression: compression}, nil
+}
+
+// ParseEncoding returns the encoding of a stored tag
+func ParseEncoding(tag string) (Encoding, error) {
+	format, compression, found := strings.Cut(tag, "+")
+	if !found {
+		compression = config.StorageCompressionNone
+	}
+	return NewEncoding(format, compression)
+}
+
+// String returns the tag of the encoding, the compression is omitted if the
+// data isn't compressed
+func (e Encoding) String() string {
+	if e.Compression == config.StorageCompressionNone {
+		return e.Format
+	}
+	return e.Format + "+" + e.Compression
+}
+
+// Reencoder is implemented by the storage backends that can re-encode the
+// stored rows with their configured encoding
+type Reencoder interface {
+	// Reencode re-encodes up to `limit` rows stored with another encoding, in
+	// a transaction, and returns the number of rows re-encoded. It returns 0
+	// once every row uses the configured encoding.
+	Reencode(ctx context.Context, limit int) (int, error)
+}
+
+// EncodeBlock serializes the block
+func (e Encoding) EncodeBlock(block *client.Block) ([]byte, error) {
+	payload, err := marshalBlock(e.Format, block)
+	if err != nil {
+		return nil, err
+	}
+	return e.compress(payload)
+}
+
+// DecodeBlock deserializes a block encoded with EncodeBlock
+func (e Encoding) DecodeBlock(data []byte) (*client.Block, error) {
+	payload, err := e.decompress(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var block *client.Block
+	if e.Format == config.StorageEncodingJSON {
+		if err := json.Unmarshal(payload, &block); err != nil {
+			return nil, err
+		}
+		return block, nil
+	}
+
+	var msg blocksvc.GetByHeightResponse
+	if err := msg.Unmarshal(payload); err != nil {
+		return nil, fmt.Errorf("error decoding block: %w", err)
+	}
+	block = &client.Block{}
+	if msg.BlockId != nil {
+		if block.BlockID, err = types.BlockIDFromProto(msg.BlockId); err != nil {
+			return nil, fmt.Errorf("error decoding block ID: %w", err)
+		}
+	}
+	if msg.Block != nil {
+		if block.Block, err = types.BlockFromProto(msg.Block); err != nil {
+			return nil, fmt.Errorf("error decoding block: %w", err)
+		}
+	}
+	return block, nil
+}
+
+// EncodeBlockResults serializes the block results
+func (e Encoding) EncodeBlockResults(blockResults *client.BlockResults) ([]byte, error) {
+	payload, err := marshalBlockResults(e.Format, blockResults)
+	if err != nil {
+		return nil, err
+	}
+	return e.compress(payload)
+}
+
+// DecodeBlockResults deserializes block results encoded with
+// EncodeBlockResults
+func (e Encoding) DecodeBlockResults(data []byte) (*client.BlockResults, error) {
+	payload, err := e.decompress(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var blockResults *client.BlockResults
+	if e.Format == config.StorageEncodingJSON {
+		if err := json.Unmarshal(payload, &blockResults); err != nil {
+			return nil, err
+		}
+		return blockResults, nil
+	}
+
+	var msg brs.GetBlockResultsResponse
+	if err := msg.Unmarshal(payload); err != nil {
+		return nil, fmt.Errorf("error decoding block results: %w", err)
+	}
+	return &client.BlockResults{
+		Height:                msg.Height,
+		TxResults:             msg.TxResults,
+		FinalizeBlockEvents:   msg.FinalizeBlockEvents,
+		ValidatorUpdates:      msg.ValidatorUpdates,
+		ConsensusParamUpdates: msg.ConsensusParamUpdates,
+		AppHash:               msg.AppHash,
+	}, nil
+}
+
+// marshalBlock serializes the block in the format, the protobuf form is the
+// response of the block service
+func marshalBlock(format string, block *client.Block) ([]byte, error) {
+	if format == config.StorageEncodingJSON {
+		return json.Marshal(block)
+	}
+
+	var msg blocksvc.GetByHeightResponse
+	if block.BlockID != nil {
+		pblockID := block.BlockID.ToProto()
+		msg.BlockId = &pblockID
+	}
+	if block.Block != nil {
+		pblock, err := block.Block.ToProto()
+		if err != nil {
+			return nil, fmt.Errorf("error encoding block: %w", err)
+		}
+		msg.Block = pblock
+	}
+	return msg.Marshal()
+}
+
+// marshalBlockResults serializes the block results in the format, the
+// protobuf form is the response of the block results service
+func marshalBlockResults(format string, blockResults *client.BlockResults) ([]byte, error) {
+	if format == config.StorageEncodingJSON {
+		return json.Marshal(blockResults)
+	}
+
+	msg := brs.GetBlockResultsResponse{
+		Height:                blockResults.Height,
+		TxResults:             blockResults.TxResults,
+		FinalizeBlockEvents:   blockResults.FinalizeBlockEvents,
+		ValidatorUpdates:      blockResults.ValidatorUpdates,
+		ConsensusParamUpdates: blockResults.ConsensusParamUpdates,
+		AppHash:               blockResults.AppHash,
+	}
+	return msg.Marshal()
+}
+
+func (e Encoding) compress(payload []byte) ([]byte, error) {
+	switch e.Compression {
+	case config.StorageCompressionZstd:
+		return zstdEncoder.EncodeAll(payload, nil), nil
+	case config.StorageCompressionSnappy:
+		return snappy.Encode(nil, payload), nil
+	default:
+		return payload, nil
+	}
+}
+
+func (e Encoding) decompress(data []byte) ([]byte, error) {
+	switch e.Compression {
+	case config.StorageCompressionZstd:
+		payload, err := zstdDecoder.DecodeAll(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing zstd data: %w", err)
+		}
+		return payload, nil
+	case config.StorageCompressionSnappy:
+		payload, err := snappy.Decode(nil, data)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing snappy data: %w", err)
+		}
+		return payload, nil
+	default:
+		return data, nil
+	}
+}
@@ -0,0 +1,329 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cometbft/cometbft/libs/json"
+	"github.com/cometbft/cometbft/rpc/grpc/client"
+)
+
+// MemoryStorage implements IStorage in memory, for tests and ephemeral runs.
+// The data is encoded like in the SQL databases, so callers never share
+// memory with the storage.
+type MemoryStorage struct {
+	mtx          sync.RWMutex
+	blocks       map[uint64][]byte
+	blockResults map[uint64][]byte
+	txs          map[string]TxPosition
+	quarantine   map[quarantineKey]quarantinedRow
+}
+
+// quarantineKey identifies the quarantined data of a kind at a height
+type quarantineKey struct {
+	height uint64
+	kind   string
+}
+
+// quarantinedRow is the quarantined data, encoded
+type quarantinedRow struct {
+	check  string
+	reason string
+	data   []byte
+	at     time.Time
+}
+
+var _ IStorage = (*MemoryStorage)(nil)
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		blocks:       make(map[uint64][]byte),
+		blockResults: make(map[uint64][]byte),
+		txs:          make(map[string]TxPosition),
+		quarantine:   make(map[quarantineKey]quarantinedRow),
+	}
+}
+
+func (c *MemoryStorage) Ping(_ context.Context) error {
+	return nil
+}
+
+func (c *MemoryStorage) Disconnect() error {
+	return nil
+}
+
+// CheckSchema always succeeds, the memory storage has no schema to migrate
+func (c *MemoryStorage) CheckSchema(_ context.Context) error {
+	return nil
+}
+
+// InsertHeights stores the data of the heights at once, checking all of them
+// for conflicts first
+func (c *MemoryStorage) InsertHeights(_ context.Context, heights []HeightData) error {
+	blocks := make([][]byte, len(heights))
+	blockResults := make([][]byte, len(heights))
+	for i, h := range heights {
+		var err error
+		if h.Block != nil {
+			if blocks[i], err = json.Marshal(h.Block); err != nil {
+				return err
+			}
+		}
+		if h.BlockResults != nil {
+			if blockResults[i], err = json.Marshal(h.BlockResults); err != nil {
+				return err
+			}
+		}
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for i, h := range heights {
+		if err := c.checkConflict(c.blocks, h.Height, blocks[i]); err != nil {
+			return err
+		}
+		if err := c.checkConflict(c.blockResults, h.Height, blockResults[i]); err != nil {
+			return err
+		}
+	}
+	for i, h := range heights {
+		if blocks[i] != nil {
+			c.blocks[h.Height] = blocks[i]
+			c.indexTxs(h.Height, h.Block)
+			delete(c.quarantine, quarantineKey{height: h.Height, kind: "block"})
+		}
+		if blockResults[i] != nil {
+			c.blockResults[h.Height] = blockResults[i]
+			delete(c.quarantine, quarantineKey{height: h.Height, kind: "block_results"})
+		}
+	}
+	return nil
+}
+
+func (c *MemoryStorage) InsertHeight(ctx context.Context, height uint64, block *client.Block, blockResults *client.BlockResults) error {
+	return c.InsertHeights(ctx, []HeightData{{Height: height, Block: block, BlockResults: blockResults}})
+}
+
+// QuarantineHeight stores the quarantined data, replacing any data of the same
+// kind quarantined at the height
+func (c *MemoryStorage) QuarantineHeight(_ context.Context, data QuarantinedData) error {
+	var encoded []byte
+	var err error
+	if data.Block != nil {
+		encoded, err = json.Marshal(data.Block)
+	} else {
+		encoded, err = json.Marshal(data.BlockResults)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.quarantine[quarantineKey{height: data.Height, kind: data.Kind()}] = quarantinedRow{
+		check:  data.Check,
+		reason: data.Reason,
+		data:   encoded,
+		at:     time.Now().UTC(),
+	}
+	return nil
+}
+
+func (c *MemoryStorage) GetQuarantined(_ context.Context) ([]QuarantineEntry, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	entries := make([]QuarantineEntry, 0, len(c.quarantine))
+	for key, row := range c.quarantine {
+		entries = append(entries, QuarantineEntry{
+			Height:        key.height,
+			Kind:          key.kind,
+			Check:         row.check,
+			Reason:        row.reason,
+			QuarantinedAt: row.at,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Height != entries[j].Height {
+			return entries[i].Height < entries[j].Height
+		}
+		return entries[i].Kind < entries[j].Kind
+	})
+	return entries, nil
+}
+
+func (c *MemoryStorage) InsertBlock(ctx context.Context, height uint64, block *client.Block) error {
+	return c.InsertHeight(ctx, height, block, nil)
+}
+
+func (c *MemoryStorage) GetBlock(_ context.Context, height uint64) (*client.Block, error) {
+	var block *client.Block
+	data, err := c.get(c.blocks, height)
+	if err != nil {
+		return block, err
+	}
+	err = json.Unmarshal(data, &block)
+	if err != nil {
+		return block, err
+	}
+	return block, nil
+}
+
+func (c *MemoryStorage) InsertBlockResults(ctx context.Context, height uint64, blockResults *client.BlockResults) error {
+	return c.InsertHeight(ctx, height, nil, blockResults)
+}
+
+func (c *MemoryStorage) GetBlockResults(_ context.Context, height uint64) (*client.BlockResults, error) {
+	var blockResults *client.BlockResults
+	data, err := c.get(c.blockResults, height)
+	if err != nil {
+		return blockResults, err
+	}
+	err = json.Unmarshal(data, &blockResults)
+	if err != nil {
+		return blockResults, err
+	}
+	return blockResults, nil
+}
+
+// GetLatestHeight returns the highest height with a block stored
+func (c *MemoryStorage) GetLatestHeight(_ context.Context) (uint64, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	if len(c.blocks) == 0 {
+		return 0, ErrNotFound
+	}
+	var latest uint64
+	for height := range c.blocks {
+		latest = max(latest, height)
+	}
+	return latest, nil
+}
+
+// GetEarliestHeight returns the lowest height with a block stored
+func (c *MemoryStorage) GetEarliestHeight(_ context.Context) (uint64, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	if len(c.blocks) == 0 {
+		return 0, ErrNotFound
+	}
+	var earliest uint64
+	for height := range c.blocks {
+		if earliest == 0 || height < earliest {
+			earliest = height
+		}
+	}
+	return earliest, nil
+}
+
+// GetContiguousHeight returns the highest height such that every block from the
+// `from` height up to it is stored. It returns 0 if the block at the `from`
+// height is not stored.
+func (c *MemoryStorage) GetContiguousHeight(_ context.Context, from uint64) (uint64, error) {
+	return c.contiguousHeight(c.blocks, from), nil
+}
+
+// GetContiguousBlockResultsHeight returns the highest height such that every
+// block results from the `from` height up to it are stored. It returns 0 if the
+// block results at the `from` height are not stored.
+func (c *MemoryStorage) GetContiguousBlockResultsHeight(_ context.Context, from uint64) (uint64, error) {
+	return c.contiguousHeight(c.blockResults, from), nil
+}
+
+// GetMissingHeights returns the heights in the [from, to] range that don't
+// have a block stored, in ascending order.
+func (c *MemoryStorage) GetMissingHeights(_ context.Context, from uint64, to uint64) ([]uint64, error) {
+	return c.missingHeights(c.blocks, from, to), nil
+}
+
+// GetMissingBlockResultsHeights returns the heights in the [from, to] range
+// that don't have block results stored, in ascending order.
+func (c *MemoryStorage) GetMissingBlockResultsHeights(_ context.Context, from uint64, to uint64) ([]uint64, error) {
+	return c.missingHeights(c.blockResults, from, to), nil
+}
+
+// GetTxPosition returns the position of the transaction with the given hash
+func (c *MemoryStorage) GetTxPosition(_ context.Context, hash []byte) (TxPosition, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	position, ok := c.txs[string(hash)]
+	if !ok {
+		return position, fmt.Errorf("%w: tx %X", ErrNotFound, hash)
+	}
+	return position, nil
+}
+
+// indexTxs maps the hashes of the block transactions to their position,
+// keeping the lowest position of the transactions included more than once. The
+// caller holds the lock.
+func (c *MemoryStorage) indexTxs(height uint64, block *client.Block) {
+	if block == nil || block.Block == nil {
+		return
+	}
+
+	for i, tx := range block.Block.Txs {
+		hash := string(tx.Hash())
+		if position, ok := c.txs[hash]; ok && position.Height <= height {
+			continue
+		}
+		c.txs[hash] = TxPosition{Height: height, Index: uint32(i)}
+	}
+}
+
+// checkConflict returns ErrHeightConflict if different data is stored at the
+// height, nil data is never in conflict. The caller holds the lock.
+func (c *MemoryStorage) checkConflict(table map[uint64][]byte, height uint64, data []byte) error {
+	stored, ok := table[height]
+	if data == nil || !ok || bytes.Equal(stored, data) {
+		return nil
+	}
+	return fmt.Errorf("%w: height %d", ErrHeightConflict, height)
+}
+
+func (c *MemoryStorage) get(table map[uint64][]byte, height uint64) ([]byte, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	data, ok := table[height]
+	if !ok {
+		return nil, fmt.Errorf("%w: height %d", ErrNotFound, height)
+	}
+	return data, nil
+}
+
+func (c *MemoryStorage) contiguousHeight(table map[uint64][]byte, from uint64) uint64 {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	if _, ok := table[from]; !ok {
+		return 0
+	}
+	height := from
+	for {
+		if _, ok := table[height+1]; !ok {
+			return height
+		}
+		height++
+	}
+}
+
+func (c *MemoryStorage) missingHeights(table map[uint64][]byte, from uint64, to uint64) []uint64 {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	heights := make([]uint64, 0)
+	for height := from; height <= to; height++ {
+		if _, ok := table[height]; !ok {
+			heights = append(heights, height)
+		}
+	}
+	return heights
+}
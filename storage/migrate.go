@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrSchemaOutdated is returned when the storage has pending migrations
+	ErrSchemaOutdated = errors.New("schema is outdated")
+	// ErrSchemaUnknown is returned when the storage has migrations applied that
+	// this version doesn't know, that is the schema is newer than the binary
+	ErrSchemaUnknown = errors.New("schema is newer than supported")
+
+	//go:embed migrations
+	migrationsFS embed.FS
+
+	migrationFileRegexp = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+)
+
+// Migration is a versioned change to the storage schema
+type Migration struct {
+	Version uint64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration is applied to the storage
+type MigrationStatus struct {
+	Version   uint64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator is implemented by the storage backends with a versioned schema
+type Migrator interface {
+	// MigrateUp applies the pending migrations in order and returns the
+	// number of migrations applied
+	MigrateUp(ctx context.Context) (int, error)
+	// MigrateDown rolls back the last `steps` applied migrations and returns
+	// the number of migrations rolled back
+	MigrateDown(ctx context.Context, steps int) (int, error)
+	// MigrationStatus returns the status of every known migration, in order
+	MigrationStatus(ctx context.Context) ([]MigrationStatus, error)
+}
+
+// loadMigrations reads the migrations embedded in the given directory, sorted
+// by version. Every migration must have both an up and a down file.
+func loadMigrations(dir string) ([]Migration, error) {
+	dir = path.Join("migrations", dir)
+	entries, err := fs.ReadDir(migrationsFS, dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %w", err)
+	}
+
+	byVersion := make(map[uint64]*Migration)
+	for _, entry := range entries {
+		match := migrationFileRegexp.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %q", entry.Name())
+		}
+		data, err := migrationsFS.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %q: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has different names %q and %q", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// checkSchema returns ErrSchemaOutdated if any known migration is not applied,
+// or ErrSchemaUnknown if a migration this version doesn't know is applied
+func checkSchema(status []MigrationStatus, applied map[uint64]time.Time) error {
+	known := make(map[uint64]bool, len(status))
+	for _, s := range status {
+		known[s.Version] = true
+		if !s.Applied {
+			return fmt.Errorf("%w: migration %d_%s is not applied", ErrSchemaOutdated, s.Version, s.Name)
+		}
+	}
+	for version := range applied {
+		if !known[version] {
+			return fmt.Errorf("%w: migration %d is applied but unknown", ErrSchemaUnknown, version)
+		}
+	}
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// SQL migrations
+
+// MigrateUp applies the pending migrations in order, each one in a transaction
+func (c *sqlStorage) MigrateUp(ctx context.Context) (int, error) {
+	migrations, applied, err := c.migrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err := c.transact(ctx, func(tx *sqlTx) error {
+			if _, err := tx.exec(m.Up); err != nil {
+				return err
+			}
+			_, err := tx.exec(fmt.Sprintf("INSERT INTO %s (version, name, applied_at) VALUES ($1,$2,$3)", tx.dialect.table("schema_migrations")), m.Version, m.Name, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return count, fmt.Errorf("error applying migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// MigrateDown rolls back the last `steps` applied migrations, newest first,
+// each one in a transaction
+func (c *sqlStorage) MigrateDown(ctx context.Context, steps int) (int, error) {
+	migrations, applied, err := c.migrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkSchema(c.status(migrations, applied), applied); errors.Is(err, ErrSchemaUnknown) {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		err := c.transact(ctx, func(tx *sqlTx) error {
+			if _, err := tx.exec(m.Down); err != nil {
+				return err
+			}
+			_, err := tx.exec(fmt.Sprintf("DELETE FROM %s WHERE version=$1", tx.dialect.table("schema_migrations")), m.Version)
+			return err
+		})
+		if err != nil {
+			return count, fmt.Errorf("error rolling back migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// MigrationStatus returns the status of every known migration, in order
+func (c *sqlStorage) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, applied, err := c.migrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.status(migrations, applied), nil
+}
+
+// CheckSchema returns an error if the schema doesn't match the migrations
+// known by this version
+func (c *sqlStorage) CheckSchema(ctx context.Context) error {
+	migrations, applied, err := c.migrations(ctx)
+	if err != nil {
+		return err
+	}
+	return checkSchema(c.status(migrations, applied), applied)
+}
+
+func (c *sqlStorage) status(migrations []Migration, applied map[uint64]time.Time) []MigrationStatus {
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		appliedAt, ok := applied[m.Version]
+		status = append(status, MigrationStatus{
+			Version:   m.Version,
+			Name:      m.Name,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+	return status
+}
+
+// migrations returns the known migrations and the applied versions, creating
+// the table tracking the applied versions if it doesn't exist
+func (c *sqlStorage) migrations(ctx context.Context) ([]Migration, map[uint64]time.Time, error) {
+	migrations, err := loadMigrations(c.dialect.migrations)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := c.connection.ExecContext(ctx, c.dialect.migrationsTable); err != nil {
+		return nil, nil, fmt.Errorf("error creating migrations table: %w", err)
+	}
+
+	rows, err := c.query(ctx, fmt.Sprintf("SELECT version, applied_at FROM %s", c.dialect.table("schema_migrations")))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[uint64]time.Time)
+	for rows.Next() {
+		var (
+			version   uint64
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, nil, fmt.Errorf("error reading applied migrations: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading applied migrations: %w", err)
+	}
+	return migrations, applied, nil
+}
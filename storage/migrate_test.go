@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	for _, dir := range []string{"postgres", "sqlite"} {
+		t.Run(dir, func(t *testing.T) {
+			migrations, err := loadMigrations(dir)
+			require.NoError(t, err)
+			require.NotEmpty(t, migrations)
+			for i, m := range migrations {
+				require.Equal(t, uint64(i+1), m.Version)
+				require.NotEmpty(t, m.Up)
+				require.NotEmpty(t, m.Down)
+			}
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "companion.db"))
+	require.NoError(t, err)
+	defer db.Disconnect()
+
+	require.ErrorIs(t, db.CheckSchema(ctx), ErrSchemaOutdated)
+
+	migrations, err := loadMigrations("sqlite")
+	require.NoError(t, err)
+	count, err := db.MigrateUp(ctx)
+	require.NoError(t, err)
+	require.Equal(t, len(migrations), count)
+	require.NoError(t, db.CheckSchema(ctx))
+
+	// Applying again is a no-op
+	count, err = db.MigrateUp(ctx)
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	status, err := db.MigrationStatus(ctx)
+	require.NoError(t, err)
+	require.Len(t, status, len(migrations))
+	for _, s := range status {
+		require.True(t, s.Applied)
+	}
+
+	// Rolling back the last migration leaves the schema outdated
+	count, err = db.MigrateDown(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	require.ErrorIs(t, db.CheckSchema(ctx), ErrSchemaOutdated)
+	count, err = db.MigrateUp(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	// A migration applied by a newer version is refused
+	_, err = db.connection.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?,?,?)",
+		len(migrations)+1, "newer", time.Now().UTC())
+	require.NoError(t, err)
+	require.ErrorIs(t, db.CheckSchema(ctx), ErrSchemaUnknown)
+	_, err = db.MigrateDown(ctx, 1)
+	require.ErrorIs(t, err, ErrSchemaUnknown)
+}
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS comet.block_results;
+
+DROP TABLE IF EXISTS comet.block;
+
+DROP DOMAIN IF EXISTS comet.uint64;
+
+DROP DOMAIN IF EXISTS comet.uint32;
+
+DROP DOMAIN IF EXISTS comet.uint8;
@@ -1,7 +1,3 @@
--- SCHEMA: comet
-
-CREATE SCHEMA IF NOT EXISTS comet;
-
 -- DOMAIN: comet.uint8
 
 DO $$ BEGIN
@@ -36,8 +32,6 @@ DO $$ BEGIN
     CREATE DOMAIN comet.uint64
         AS numeric;
 
-    ALTER DOMAIN comet.uint64 OWNER TO postgres;
-
     ALTER DOMAIN comet.uint64
         ADD CONSTRAINT value_max CHECK (VALUE <= '18446744073709551615'::numeric);
 
@@ -49,11 +43,18 @@ END $$;
 
 -- TABLE: comet.block
 
-DROP TABLE IF EXISTS comet.block CASCADE;
-
-CREATE TABLE comet.block
+CREATE TABLE IF NOT EXISTS comet.block
 (
     height  comet.uint64 NOT NULL,
     data    bytea NOT NULL,
     CONSTRAINT block_pkey PRIMARY KEY (height)
 );
+
+-- TABLE: comet.block_results
+
+CREATE TABLE IF NOT EXISTS comet.block_results
+(
+    height  comet.uint64 NOT NULL,
+    data    bytea NOT NULL,
+    CONSTRAINT block_results_pkey PRIMARY KEY (height)
+);
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS comet.validator_update;
+
+DROP TABLE IF EXISTS comet.event_attribute;
+
+DROP TABLE IF EXISTS comet.event;
+
+DROP TABLE IF EXISTS comet.tx_result;
+
+DROP TABLE IF EXISTS comet.commit_signature;
+
+DROP TABLE IF EXISTS comet.tx;
+
+DROP TABLE IF EXISTS comet.header;
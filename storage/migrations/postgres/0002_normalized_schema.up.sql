@@ -0,0 +1,129 @@
+-- TABLE: comet.header
+
+CREATE TABLE IF NOT EXISTS comet.header
+(
+    height                comet.uint64 NOT NULL,
+    chain_id              text NOT NULL,
+    time                  timestamp with time zone NOT NULL,
+    block_hash            bytea NOT NULL,
+    version_block         comet.uint64 NOT NULL,
+    version_app           comet.uint64 NOT NULL,
+    last_block_hash       bytea,
+    last_commit_hash      bytea,
+    data_hash             bytea,
+    validators_hash       bytea,
+    next_validators_hash  bytea,
+    consensus_hash        bytea,
+    app_hash              bytea,
+    last_results_hash     bytea,
+    evidence_hash         bytea,
+    proposer_address      bytea,
+    num_txs               comet.uint32 NOT NULL,
+    CONSTRAINT header_pkey PRIMARY KEY (height),
+    CONSTRAINT header_block_fkey FOREIGN KEY (height)
+        REFERENCES comet.block (height) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS header_block_hash_idx ON comet.header (block_hash);
+
+-- TABLE: comet.tx
+
+CREATE TABLE IF NOT EXISTS comet.tx
+(
+    height    comet.uint64 NOT NULL,
+    tx_index  comet.uint32 NOT NULL,
+    hash      bytea NOT NULL,
+    data      bytea NOT NULL,
+    CONSTRAINT tx_pkey PRIMARY KEY (height, tx_index),
+    CONSTRAINT tx_block_fkey FOREIGN KEY (height)
+        REFERENCES comet.block (height) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS tx_hash_idx ON comet.tx (hash);
+
+-- TABLE: comet.commit_signature
+-- The signatures of the commit for the previous height, carried by the block
+
+CREATE TABLE IF NOT EXISTS comet.commit_signature
+(
+    block_height       comet.uint64 NOT NULL,
+    commit_height      comet.uint64 NOT NULL,
+    commit_round       comet.uint32 NOT NULL,
+    validator_index    comet.uint32 NOT NULL,
+    block_id_flag      comet.uint8 NOT NULL,
+    validator_address  bytea,
+    timestamp          timestamp with time zone,
+    signature          bytea,
+    CONSTRAINT commit_signature_pkey PRIMARY KEY (block_height, validator_index),
+    CONSTRAINT commit_signature_block_fkey FOREIGN KEY (block_height)
+        REFERENCES comet.block (height) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS commit_signature_validator_address_idx ON comet.commit_signature (validator_address);
+
+-- TABLE: comet.tx_result
+
+CREATE TABLE IF NOT EXISTS comet.tx_result
+(
+    height      comet.uint64 NOT NULL,
+    tx_index    comet.uint32 NOT NULL,
+    code        comet.uint32 NOT NULL,
+    codespace   text NOT NULL,
+    log         text NOT NULL,
+    info        text NOT NULL,
+    data        bytea,
+    gas_wanted  bigint NOT NULL,
+    gas_used    bigint NOT NULL,
+    CONSTRAINT tx_result_pkey PRIMARY KEY (height, tx_index),
+    CONSTRAINT tx_result_block_results_fkey FOREIGN KEY (height)
+        REFERENCES comet.block_results (height) ON DELETE CASCADE
+);
+
+-- TABLE: comet.event
+-- Transaction events have a tx_index, finalize block events don't
+
+CREATE TABLE IF NOT EXISTS comet.event
+(
+    id           bigserial NOT NULL,
+    height       comet.uint64 NOT NULL,
+    tx_index     comet.uint32,
+    event_index  comet.uint32 NOT NULL,
+    type         text NOT NULL,
+    CONSTRAINT event_pkey PRIMARY KEY (id),
+    CONSTRAINT event_block_results_fkey FOREIGN KEY (height)
+        REFERENCES comet.block_results (height) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS event_height_idx ON comet.event (height, tx_index);
+
+CREATE INDEX IF NOT EXISTS event_type_idx ON comet.event (type);
+
+-- TABLE: comet.event_attribute
+
+CREATE TABLE IF NOT EXISTS comet.event_attribute
+(
+    event_id         bigint NOT NULL,
+    attribute_index  comet.uint32 NOT NULL,
+    key              text NOT NULL,
+    value            text NOT NULL,
+    indexed          boolean NOT NULL,
+    CONSTRAINT event_attribute_pkey PRIMARY KEY (event_id, attribute_index),
+    CONSTRAINT event_attribute_event_fkey FOREIGN KEY (event_id)
+        REFERENCES comet.event (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS event_attribute_key_value_idx ON comet.event_attribute (key, value);
+
+-- TABLE: comet.validator_update
+
+CREATE TABLE IF NOT EXISTS comet.validator_update
+(
+    height        comet.uint64 NOT NULL,
+    update_index  comet.uint32 NOT NULL,
+    pub_key_type  text NOT NULL,
+    pub_key       bytea NOT NULL,
+    power         bigint NOT NULL,
+    CONSTRAINT validator_update_pkey PRIMARY KEY (height, update_index),
+    CONSTRAINT validator_update_block_results_fkey FOREIGN KEY (height)
+        REFERENCES comet.block_results (height) ON DELETE CASCADE
+);
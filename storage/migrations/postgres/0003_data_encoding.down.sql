@@ -0,0 +1,6 @@
+-- The rows must be re-encoded as JSON first, with the "json" encoding and no
+-- compression, otherwise they can't be read once the column is dropped
+
+ALTER TABLE comet.block_results DROP COLUMN IF EXISTS encoding;
+
+ALTER TABLE comet.block DROP COLUMN IF EXISTS encoding;
@@ -0,0 +1,6 @@
+-- The encoding of the blocks and block results, the rows stored before are
+-- JSON
+
+ALTER TABLE comet.block ADD COLUMN encoding text NOT NULL DEFAULT 'json';
+
+ALTER TABLE comet.block_results ADD COLUMN encoding text NOT NULL DEFAULT 'json';
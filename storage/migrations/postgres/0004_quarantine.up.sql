@@ -0,0 +1,13 @@
+-- TABLE: comet.quarantine
+
+CREATE TABLE IF NOT EXISTS comet.quarantine
+(
+    height          comet.uint64 NOT NULL,
+    kind            text NOT NULL,
+    failed_check    text NOT NULL,
+    reason          text NOT NULL,
+    data            bytea NOT NULL,
+    encoding        text NOT NULL,
+    quarantined_at  timestamp with time zone NOT NULL,
+    CONSTRAINT quarantine_pkey PRIMARY KEY (height, kind)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS block_results;
+
+DROP TABLE IF EXISTS block;
@@ -0,0 +1,17 @@
+-- TABLE: block
+
+CREATE TABLE IF NOT EXISTS block
+(
+    height  INTEGER NOT NULL,
+    data    BLOB NOT NULL,
+    CONSTRAINT block_pkey PRIMARY KEY (height)
+);
+
+-- TABLE: block_results
+
+CREATE TABLE IF NOT EXISTS block_results
+(
+    height  INTEGER NOT NULL,
+    data    BLOB NOT NULL,
+    CONSTRAINT block_results_pkey PRIMARY KEY (height)
+);
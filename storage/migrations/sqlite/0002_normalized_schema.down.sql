@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS validator_update;
+
+DROP TABLE IF EXISTS event_attribute;
+
+DROP TABLE IF EXISTS event;
+
+DROP TABLE IF EXISTS tx_result;
+
+DROP TABLE IF EXISTS commit_signature;
+
+DROP TABLE IF EXISTS tx;
+
+DROP TABLE IF EXISTS header;
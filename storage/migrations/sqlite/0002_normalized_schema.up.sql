@@ -0,0 +1,129 @@
+-- TABLE: header
+
+CREATE TABLE IF NOT EXISTS header
+(
+    height                INTEGER NOT NULL,
+    chain_id              TEXT NOT NULL,
+    time                  DATETIME NOT NULL,
+    block_hash            BLOB NOT NULL,
+    version_block         INTEGER NOT NULL,
+    version_app           INTEGER NOT NULL,
+    last_block_hash       BLOB,
+    last_commit_hash      BLOB,
+    data_hash             BLOB,
+    validators_hash       BLOB,
+    next_validators_hash  BLOB,
+    consensus_hash        BLOB,
+    app_hash              BLOB,
+    last_results_hash     BLOB,
+    evidence_hash         BLOB,
+    proposer_address      BLOB,
+    num_txs               INTEGER NOT NULL,
+    CONSTRAINT header_pkey PRIMARY KEY (height),
+    CONSTRAINT header_block_fkey FOREIGN KEY (height)
+        REFERENCES block (height) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS header_block_hash_idx ON header (block_hash);
+
+-- TABLE: tx
+
+CREATE TABLE IF NOT EXISTS tx
+(
+    height    INTEGER NOT NULL,
+    tx_index  INTEGER NOT NULL,
+    hash      BLOB NOT NULL,
+    data      BLOB NOT NULL,
+    CONSTRAINT tx_pkey PRIMARY KEY (height, tx_index),
+    CONSTRAINT tx_block_fkey FOREIGN KEY (height)
+        REFERENCES block (height) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS tx_hash_idx ON tx (hash);
+
+-- TABLE: commit_signature
+-- The signatures of the commit for the previous height, carried by the block
+
+CREATE TABLE IF NOT EXISTS commit_signature
+(
+    block_height       INTEGER NOT NULL,
+    commit_height      INTEGER NOT NULL,
+    commit_round       INTEGER NOT NULL,
+    validator_index    INTEGER NOT NULL,
+    block_id_flag      INTEGER NOT NULL,
+    validator_address  BLOB,
+    timestamp          DATETIME,
+    signature          BLOB,
+    CONSTRAINT commit_signature_pkey PRIMARY KEY (block_height, validator_index),
+    CONSTRAINT commit_signature_block_fkey FOREIGN KEY (block_height)
+        REFERENCES block (height) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS commit_signature_validator_address_idx ON commit_signature (validator_address);
+
+-- TABLE: tx_result
+
+CREATE TABLE IF NOT EXISTS tx_result
+(
+    height      INTEGER NOT NULL,
+    tx_index    INTEGER NOT NULL,
+    code        INTEGER NOT NULL,
+    codespace   TEXT NOT NULL,
+    log         TEXT NOT NULL,
+    info        TEXT NOT NULL,
+    data        BLOB,
+    gas_wanted  INTEGER NOT NULL,
+    gas_used    INTEGER NOT NULL,
+    CONSTRAINT tx_result_pkey PRIMARY KEY (height, tx_index),
+    CONSTRAINT tx_result_block_results_fkey FOREIGN KEY (height)
+        REFERENCES block_results (height) ON DELETE CASCADE
+);
+
+-- TABLE: event
+-- Transaction events have a tx_index, finalize block events don't
+
+CREATE TABLE IF NOT EXISTS event
+(
+    id           INTEGER NOT NULL,
+    height       INTEGER NOT NULL,
+    tx_index     INTEGER,
+    event_index  INTEGER NOT NULL,
+    type         TEXT NOT NULL,
+    CONSTRAINT event_pkey PRIMARY KEY (id),
+    CONSTRAINT event_block_results_fkey FOREIGN KEY (height)
+        REFERENCES block_results (height) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS event_height_idx ON event (height, tx_index);
+
+CREATE INDEX IF NOT EXISTS event_type_idx ON event (type);
+
+-- TABLE: event_attribute
+
+CREATE TABLE IF NOT EXISTS event_attribute
+(
+    event_id         INTEGER NOT NULL,
+    attribute_index  INTEGER NOT NULL,
+    key              TEXT NOT NULL,
+    value            TEXT NOT NULL,
+    indexed          BOOLEAN NOT NULL,
+    CONSTRAINT event_attribute_pkey PRIMARY KEY (event_id, attribute_index),
+    CONSTRAINT event_attribute_event_fkey FOREIGN KEY (event_id)
+        REFERENCES event (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS event_attribute_key_value_idx ON event_attribute (key, value);
+
+-- TABLE: validator_update
+
+CREATE TABLE IF NOT EXISTS validator_update
+(
+    height        INTEGER NOT NULL,
+    update_index  INTEGER NOT NULL,
+    pub_key_type  TEXT NOT NULL,
+    pub_key       BLOB NOT NULL,
+    power         INTEGER NOT NULL,
+    CONSTRAINT validator_update_pkey PRIMARY KEY (height, update_index),
+    CONSTRAINT validator_update_block_results_fkey FOREIGN KEY (height)
+        REFERENCES block_results (height) ON DELETE CASCADE
+);
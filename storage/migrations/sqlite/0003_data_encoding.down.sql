@@ -0,0 +1,6 @@
+-- The rows must be re-encoded as JSON first, with the "json" encoding and no
+-- compression, otherwise they can't be read once the column is dropped
+
+ALTER TABLE block_results DROP COLUMN encoding;
+
+ALTER TABLE block DROP COLUMN encoding;
@@ -0,0 +1,6 @@
+-- The encoding of the blocks and block results, the rows stored before are
+-- JSON
+
+ALTER TABLE block ADD COLUMN encoding TEXT NOT NULL DEFAULT 'json';
+
+ALTER TABLE block_results ADD COLUMN encoding TEXT NOT NULL DEFAULT 'json';
@@ -0,0 +1,13 @@
+-- TABLE: quarantine
+
+CREATE TABLE IF NOT EXISTS quarantine
+(
+    height          INTEGER NOT NULL,
+    kind            TEXT NOT NULL,
+    failed_check    TEXT NOT NULL,
+    reason          TEXT NOT NULL,
+    data            BLOB NOT NULL,
+    encoding        TEXT NOT NULL,
+    quarantined_at  DATETIME NOT NULL,
+    CONSTRAINT quarantine_pkey PRIMARY KEY (height, kind)
+);
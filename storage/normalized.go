@@ -0,0 +1,344 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	abci "github.com/cometbft/cometbft/abci/types"
+	cryptoenc "github.com/cometbft/cometbft/crypto/encoding"
+	"github.com/cometbft/cometbft/rpc/grpc/client"
+)
+
+// The normalized tables store the blocks and block results in a relational
+// form that can be queried with SQL. They are populated in the same
+// transaction as the raw data, which is kept for fidelity.
+
+// Reindexer is implemented by the storage backends with normalized tables, to
+// populate them for the data stored before they existed
+type Reindexer interface {
+	// ReindexBlocks inserts the normalized rows of up to `limit` blocks above
+	// the `after` height that have none, in a transaction. It returns the
+	// number of blocks reindexed and the highest one, 0 once every block
+	// above the `after` height is normalized.
+	ReindexBlocks(ctx context.Context, after uint64, limit int) (int, uint64, error)
+	// ReindexBlockResults is the ReindexBlocks equivalent for block results
+	ReindexBlockResults(ctx context.Context, after uint64, limit int) (int, uint64, error)
+}
+
+var _ Reindexer = (*sqlStorage)(nil)
+
+var (
+	headerColumns = []string{"height", "chain_id", "time", "block_hash", "version_block", "version_app",
+		"last_block_hash", "last_commit_hash", "data_hash", "validators_hash", "next_validators_hash", "consensus_hash",
+		"app_hash", "last_results_hash", "evidence_hash", "proposer_address", "num_txs"}
+	txColumns              = []string{"height", "tx_index", "hash", "data"}
+	commitSignatureColumns = []string{"block_height", "commit_height", "commit_round", "validator_index",
+		"block_id_flag", "validator_address", "timestamp", "signature"}
+	txResultColumns = []string{"height", "tx_index", "code", "codespace", "log", "info", "data",
+		"gas_wanted", "gas_used"}
+	eventColumns           = []string{"height", "tx_index", "event_index", "type"}
+	eventAttributeColumns  = []string{"event_id", "attribute_index", "key", "value", "indexed"}
+	validatorUpdateColumns = []string{"height", "update_index", "pub_key_type", "pub_key", "power"}
+)
+
+// rowBatch collects the rows of the normalized tables of several heights, to
+// insert them with multi-row statements
+type rowBatch struct {
+	headers          [][]any
+	txs              [][]any
+	commitSignatures [][]any
+	txResults        [][]any
+	events           []eventRow
+	validatorUpdates [][]any
+}
+
+// eventRow is an event to insert along with its attributes. The transaction
+// index is null for the finalize block events.
+type eventRow struct {
+	height  uint64
+	txIndex sql.NullInt64
+	index   int
+	event   *abci.Event
+}
+
+// eventKey identifies an event of a height
+type eventKey struct {
+	height  uint64
+	txIndex int64
+	index   int
+}
+
+func (e eventRow) key() eventKey {
+	key := eventKey{height: e.height, txIndex: -1, index: e.index}
+	if e.txIndex.Valid {
+		key.txIndex = e.txIndex.Int64
+	}
+	return key
+}
+
+// addBlock adds the header, transactions and commit signatures of the block
+func (b *rowBatch) addBlock(height uint64, block *client.Block) {
+	if block == nil || block.Block == nil {
+		return
+	}
+	bl := block.Block
+	h := bl.Header
+
+	blockHash := bl.Hash()
+	if block.BlockID != nil && len(block.BlockID.Hash) > 0 {
+		blockHash = block.BlockID.Hash
+	}
+
+	b.headers = append(b.headers, []any{
+		height, h.ChainID, h.Time.UTC(), []byte(blockHash), h.Version.Block, h.Version.App,
+		[]byte(h.LastBlockID.Hash), []byte(h.LastCommitHash), []byte(h.DataHash), []byte(h.ValidatorsHash),
+		[]byte(h.NextValidatorsHash), []byte(h.ConsensusHash), []byte(h.AppHash), []byte(h.LastResultsHash),
+		[]byte(h.EvidenceHash), []byte(h.ProposerAddress), len(bl.Txs),
+	})
+
+	for i, t := range bl.Txs {
+		b.txs = append(b.txs, []any{height, i, t.Hash(), []byte(t)})
+	}
+
+	if bl.LastCommit != nil {
+		for i, sig := range bl.LastCommit.Signatures {
+			var timestamp sql.NullTime
+			if !sig.Timestamp.IsZero() {
+				timestamp = sql.NullTime{Time: sig.Timestamp.UTC(), Valid: true}
+			}
+			b.commitSignatures = append(b.commitSignatures, []any{
+				height, bl.LastCommit.Height, bl.LastCommit.Round, i, int(sig.BlockIDFlag),
+				[]byte(sig.ValidatorAddress), timestamp, sig.Signature,
+			})
+		}
+	}
+}
+
+// addBlockResults adds the transaction results, the events and the validator
+// updates of the block results
+func (b *rowBatch) addBlockResults(height uint64, blockResults *client.BlockResults) error {
+	if blockResults == nil {
+		return nil
+	}
+
+	for i, r := range blockResults.TxResults {
+		if r == nil {
+			continue
+		}
+		b.txResults = append(b.txResults, []any{
+			height, i, r.Code, r.Codespace, r.Log, r.Info, r.Data, r.GasWanted, r.GasUsed,
+		})
+		for j := range r.Events {
+			b.events = append(b.events, eventRow{
+				height:  height,
+				txIndex: sql.NullInt64{Int64: int64(i), Valid: true},
+				index:   j,
+				event:   &r.Events[j],
+			})
+		}
+	}
+
+	for j, event := range blockResults.FinalizeBlockEvents {
+		if event == nil {
+			continue
+		}
+		b.events = append(b.events, eventRow{height: height, index: j, event: event})
+	}
+
+	for i, update := range blockResults.ValidatorUpdates {
+		if update == nil {
+			continue
+		}
+		pubKey, err := cryptoenc.PubKeyFromProto(update.PubKey)
+		if err != nil {
+			return fmt.Errorf("error decoding validator update %d public key at height %d: %w", i, height, err)
+		}
+		b.validatorUpdates = append(b.validatorUpdates, []any{
+			height, i, pubKey.Type(), pubKey.Bytes(), update.Power,
+		})
+	}
+
+	return nil
+}
+
+// insert stores the collected rows
+func (b *rowBatch) insert(tx *sqlTx) error {
+	if err := insertRows(tx, "header", headerColumns, b.headers); err != nil {
+		return fmt.Errorf("error inserting headers: %w", err)
+	}
+	if err := insertRows(tx, "tx", txColumns, b.txs); err != nil {
+		return fmt.Errorf("error inserting txs: %w", err)
+	}
+	if err := insertRows(tx, "commit_signature", commitSignatureColumns, b.commitSignatures); err != nil {
+		return fmt.Errorf("error inserting commit signatures: %w", err)
+	}
+	if err := insertRows(tx, "tx_result", txResultColumns, b.txResults); err != nil {
+		return fmt.Errorf("error inserting tx results: %w", err)
+	}
+	if err := b.insertEvents(tx); err != nil {
+		return err
+	}
+	if err := insertRows(tx, "validator_update", validatorUpdateColumns, b.validatorUpdates); err != nil {
+		return fmt.Errorf("error inserting validator updates: %w", err)
+	}
+	return nil
+}
+
+// insertEvents stores the events, then their attributes. The events of the
+// batch heights are read back to learn their ids, as the heights are new.
+func (b *rowBatch) insertEvents(tx *sqlTx) error {
+	if len(b.events) == 0 {
+		return nil
+	}
+
+	rows := make([][]any, 0, len(b.events))
+	heights := make([]uint64, 0)
+	for _, e := range b.events {
+		rows = append(rows, []any{e.height, e.txIndex, e.index, e.event.Type})
+		if len(heights) == 0 || heights[len(heights)-1] != e.height {
+			heights = append(heights, e.height)
+		}
+	}
+	if err := insertRows(tx, "event", eventColumns, rows); err != nil {
+		return fmt.Errorf("error inserting events: %w", err)
+	}
+
+	ids, err := eventIDs(tx, heights)
+	if err != nil {
+		return fmt.Errorf("error reading event ids: %w", err)
+	}
+
+	attributes := make([][]any, 0)
+	for _, e := range b.events {
+		id, ok := ids[e.key()]
+		if !ok {
+			return fmt.Errorf("error reading event ids: event %d missing at height %d", e.index, e.height)
+		}
+		for i, attr := range e.event.Attributes {
+			attributes = append(attributes, []any{id, i, attr.Key, attr.Value, attr.Index})
+		}
+	}
+	if err := insertRows(tx, "event_attribute", eventAttributeColumns, attributes); err != nil {
+		return fmt.Errorf("error inserting event attributes: %w", err)
+	}
+	return nil
+}
+
+// eventIDs returns the ids of the events stored at the heights
+func eventIDs(tx *sqlTx, heights []uint64) (map[eventKey]int64, error) {
+	ids := make(map[eventKey]int64)
+	for _, chunk := range chunks(heights, maxBatchParams) {
+		args := make([]any, 0, len(chunk))
+		for _, height := range chunk {
+			args = append(args, height)
+		}
+		rows, err := tx.query(fmt.Sprintf("SELECT id, height, tx_index, event_index FROM %s WHERE height IN %s",
+			tx.dialect.table("event"), tx.placeholders(1, len(args))), args...)
+		if err != nil {
+			return nil, err
+		}
+		if err := scanEventIDs(rows, ids); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+func scanEventIDs(rows *sql.Rows, ids map[eventKey]int64) error {
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var e eventRow
+		if err := rows.Scan(&id, &e.height, &e.txIndex, &e.index); err != nil {
+			return err
+		}
+		ids[e.key()] = id
+	}
+	return rows.Err()
+}
+
+// ReindexBlocks inserts the normalized rows of the blocks without a header row
+func (c *sqlStorage) ReindexBlocks(ctx context.Context, after uint64, limit int) (int, uint64, error) {
+	missing := fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %s n WHERE n.height = r.height)", c.dialect.table("header"))
+	return c.reindex(ctx, blockTable, missing, after, limit, func(b *rowBatch, height uint64, encoding Encoding, data []byte) error {
+		block, err := encoding.DecodeBlock(data)
+		if err != nil {
+			return err
+		}
+		b.addBlock(height, block)
+		return nil
+	})
+}
+
+// ReindexBlockResults inserts the normalized rows of the block results without
+// any tx result, event or validator update row. The block results that have
+// no rows to insert are selected again, which is why the scan resumes after
+// the `after` height.
+func (c *sqlStorage) ReindexBlockResults(ctx context.Context, after uint64, limit int) (int, uint64, error) {
+	missing := fmt.Sprintf(`NOT EXISTS (SELECT 1 FROM %s n WHERE n.height = r.height)
+		AND NOT EXISTS (SELECT 1 FROM %s n WHERE n.height = r.height)
+		AND NOT EXISTS (SELECT 1 FROM %s n WHERE n.height = r.height)`,
+		c.dialect.table("tx_result"), c.dialect.table("event"), c.dialect.table("validator_update"))
+	return c.reindex(ctx, blockResultsTable, missing, after, limit, func(b *rowBatch, height uint64, encoding Encoding, data []byte) error {
+		blockResults, err := encoding.DecodeBlockResults(data)
+		if err != nil {
+			return err
+		}
+		return b.addBlockResults(height, blockResults)
+	})
+}
+
+// reindex inserts the normalized rows of up to `limit` rows of the table above
+// the `after` height matching the `missing` condition, and returns their
+// number and the highest height
+func (c *sqlStorage) reindex(ctx context.Context, table string, missing string, after uint64, limit int,
+	add func(b *rowBatch, height uint64, encoding Encoding, data []byte) error,
+) (int, uint64, error) {
+	count, last := 0, uint64(0)
+	err := c.transact(ctx, func(tx *sqlTx) error {
+		rows, err := tx.query(fmt.Sprintf("SELECT r.height, r.data, r.encoding FROM %s r WHERE r.height > $1 AND %s ORDER BY r.height LIMIT $2",
+			tx.dialect.table(table), missing), after, limit)
+		if err != nil {
+			return err
+		}
+		stored := make([]encodedData, 0, limit)
+		for rows.Next() {
+			var d encodedData
+			var tag string
+			if err := rows.Scan(&d.height, &d.data, &tag); err != nil {
+				rows.Close()
+				return err
+			}
+			if d.encoding, err = ParseEncoding(tag); err != nil {
+				rows.Close()
+				return fmt.Errorf("error reading %s at height %d: %w", table, d.height, err)
+			}
+			stored = append(stored, d)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		var batch rowBatch
+		for _, d := range stored {
+			if err := add(&batch, d.height, d.encoding, d.data); err != nil {
+				return fmt.Errorf("error decoding %s at height %d: %w", table, d.height, err)
+			}
+		}
+		if err := batch.insert(tx); err != nil {
+			return err
+		}
+
+		count = len(stored)
+		if count > 0 {
+			last = stored[count-1].height
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return count, last, nil
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cometbft/cometbft/rpc/grpc/client"
+	"github.com/cometbft/cometbft/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReindex(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLiteStorage(t).(*SQLiteStorage)
+
+	for height := uint64(1); height <= 5; height++ {
+		blockResults := testBlockResults(height, "log")
+		if height == 3 {
+			// Block results without any normalized row
+			blockResults = &client.BlockResults{Height: 3}
+		}
+		require.NoError(t, db.InsertHeight(ctx, height, testBlock(height, "tx"), blockResults))
+	}
+
+	// The heights stored before the normalized tables existed
+	for _, table := range []struct{ name, height string }{
+		{"header", "height"}, {"tx", "height"}, {"commit_signature", "block_height"},
+		{"tx_result", "height"}, {"event", "height"}, {"validator_update", "height"},
+	} {
+		_, err := db.connection.Exec("DELETE FROM " + table.name + " WHERE " + table.height + " <= 4")
+		require.NoError(t, err)
+	}
+	position, err := db.GetTxPosition(ctx, types.Tx("tx").Hash())
+	require.NoError(t, err)
+	require.Equal(t, TxPosition{Height: 5, Index: 0}, position)
+
+	cases := []struct {
+		name    string
+		reindex func(ctx context.Context, after uint64, limit int) (int, uint64, error)
+		// Highest height of each batch
+		batches []uint64
+		// Heights still selected once reindexed
+		left int
+	}{
+		{"blocks", db.ReindexBlocks, []uint64{3, 4}, 0},
+		// The block results at height 3 have no normalized rows
+		{"block results", db.ReindexBlockResults, []uint64{3, 4}, 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var batches []uint64
+			after := uint64(0)
+			for {
+				count, last, err := tc.reindex(ctx, after, 3)
+				require.NoError(t, err)
+				if count == 0 {
+					break
+				}
+				batches = append(batches, last)
+				after = last
+			}
+			require.Equal(t, tc.batches, batches)
+
+			count, _, err := tc.reindex(ctx, 0, 3)
+			require.NoError(t, err)
+			require.Equal(t, tc.left, count)
+		})
+	}
+
+	position, err = db.GetTxPosition(ctx, types.Tx("tx").Hash())
+	require.NoError(t, err)
+	require.Equal(t, TxPosition{Height: 1, Index: 0}, position)
+
+	var txResults int
+	require.NoError(t, db.connection.QueryRow("SELECT COUNT(*) FROM tx_result").Scan(&txResults))
+	require.Equal(t, 4, txResults)
+}
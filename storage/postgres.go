@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+var (
+	driverName = "postgres"
+
+	uniqueViolation pq.ErrorCode = "23505"
+
+	postgresMigrationsTable = `
+CREATE SCHEMA IF NOT EXISTS comet;
+
+CREATE TABLE IF NOT EXISTS comet.schema_migrations
+(
+    version     bigint NOT NULL,
+    name        text NOT NULL,
+    applied_at  timestamp with time zone NOT NULL,
+    CONSTRAINT schema_migrations_pkey PRIMARY KEY (version)
+);
+`
+)
+
+// PostgresStorage implements IStorage on top of a Postgres database
+type PostgresStorage struct {
+	sqlStorage
+}
+
+var _ IStorage = (*PostgresStorage)(nil)
+
+func NewPostgresStorage(connectionString string, opts ...Option) (*PostgresStorage, error) {
+	db := &PostgresStorage{}
+	conn, err := db.Connect(connectionString)
+	if err != nil {
+		return db, err
+	}
+	db.connection = conn
+	db.dialect = dialect{
+		schema:            "comet.",
+		isUniqueViolation: isPostgresUniqueViolation,
+		numeric:           numericPostgres,
+		contains:          containsPostgres,
+		timestamp:         timestampPostgres,
+		placeholder:       placeholderPostgres,
+		migrations:        "postgres",
+		migrationsTable:   postgresMigrationsTable,
+	}
+	db.encoding = DefaultEncoding
+	for _, opt := range opts {
+		opt(&db.sqlStorage)
+	}
+	return db, nil
+}
+
+func (c *PostgresStorage) Connect(conn string) (*sql.DB, error) {
+	db, err := sql.Open(driverName, conn)
+	if err != nil {
+		return nil, err
+	} else {
+		return db, nil
+	}
+}
+
+func isPostgresUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
+func numericPostgres(expr string) string {
+	return fmt.Sprintf(`CAST(substring(%s from '^-?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?') AS NUMERIC)`, expr)
+}
+
+func placeholderPostgres(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func containsPostgres(expr string, substr string) string {
+	return fmt.Sprintf("strpos(%s, %s) > 0", expr, substr)
+}
+
+// timestampPostgres only casts the text matching the date and time layouts of
+// the query package, so other values are NULL instead of failing the cast
+func timestampPostgres(expr string) string {
+	expr = "CAST(" + expr + " AS TEXT)"
+	return fmt.Sprintf(`CASE WHEN %[1]s ~ '^[0-9]{4}-[0-9]{2}-[0-9]{2}$' THEN CAST(%[1]s || 'T00:00:00Z' AS TIMESTAMPTZ)
+		WHEN %[1]s ~ '^[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?([-+][0-9]{2}:[0-9]{2}|Z)$'
+		THEN CAST(%[1]s AS TIMESTAMPTZ) END`, expr)
+}
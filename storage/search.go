@@ -0,0 +1,383 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	abci "github.com/cometbft/cometbft/abci/types"
+	"github.com/cometbft/cometbft/types"
+	"github.com/cometbft/rpc-companion/libs/query"
+)
+
+// Pagination selects a page of search results
+type Pagination struct {
+	// Number of results to skip
+	Offset int
+	// Maximum number of results to return
+	Limit int
+	// Order the results from the highest position
+	Descending bool
+}
+
+// searchScope defines how the reserved keys of a query are matched when
+// searching transactions or blocks
+type searchScope struct {
+	// Value of the tm.event key
+	eventType string
+	// Reserved key and column of the height
+	heightKey    string
+	heightColumn string
+	// Reserved key and column of the hash, if any
+	hashKey    string
+	hashColumn string
+	// Condition selecting the events of the searched rows
+	eventFilter string
+}
+
+var (
+	txSearchScope = searchScope{
+		eventType:    types.EventTx,
+		heightKey:    types.TxHeightKey,
+		heightColumn: "t.height",
+		hashKey:      types.TxHashKey,
+		hashColumn:   "t.hash",
+		eventFilter:  "e.height = t.height AND e.tx_index = t.tx_index",
+	}
+	blockSearchScope = searchScope{
+		eventType:    types.EventNewBlock,
+		heightKey:    types.BlockHeightKey,
+		heightColumn: "h.height",
+		eventFilter:  "e.height = h.height AND e.tx_index IS NULL",
+	}
+)
+
+//-----------------------------------------------------------------------------
+// SQL search
+
+// SearchTxs returns a page of the positions of the transactions matching the
+// query, along with the total number of matches. Only the transactions with
+// their results stored are searched.
+func (c *sqlStorage) SearchTxs(ctx context.Context, q *query.Query, page Pagination) ([]TxPosition, int, error) {
+	b := &searchBuilder{dialect: c.dialect}
+	where, err := b.conditions(q, txSearchScope)
+	if err != nil {
+		return nil, 0, err
+	}
+	from := fmt.Sprintf("FROM %s t JOIN %s r ON r.height = t.height AND r.tx_index = t.tx_index WHERE %s",
+		c.dialect.table("tx"), c.dialect.table("tx_result"), where)
+
+	var total int
+	if err := c.queryRow(ctx, "SELECT COUNT(*) "+from, b.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	order := page.order()
+	rows, err := c.query(ctx, fmt.Sprintf("SELECT t.height, t.tx_index %s ORDER BY t.height %s, t.tx_index %s LIMIT %d OFFSET %d",
+		from, order, order, page.Limit, page.Offset), b.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	positions := make([]TxPosition, 0, page.Limit)
+	for rows.Next() {
+		var position TxPosition
+		if err := rows.Scan(&position.Height, &position.Index); err != nil {
+			return nil, 0, err
+		}
+		positions = append(positions, position)
+	}
+	return positions, total, rows.Err()
+}
+
+// SearchBlocks returns a page of the heights of the blocks whose finalize block
+// events match the query, along with the total number of matches. Only the
+// blocks with their results stored are searched.
+func (c *sqlStorage) SearchBlocks(ctx context.Context, q *query.Query, page Pagination) ([]uint64, int, error) {
+	b := &searchBuilder{dialect: c.dialect}
+	where, err := b.conditions(q, blockSearchScope)
+	if err != nil {
+		return nil, 0, err
+	}
+	from := fmt.Sprintf("FROM %s h JOIN %s r ON r.height = h.height WHERE %s",
+		c.dialect.table("header"), c.dialect.table(blockResultsTable), where)
+
+	var total int
+	if err := c.queryRow(ctx, "SELECT COUNT(*) "+from, b.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := c.query(ctx, fmt.Sprintf("SELECT h.height %s ORDER BY h.height %s LIMIT %d OFFSET %d",
+		from, page.order(), page.Limit, page.Offset), b.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	heights := make([]uint64, 0, page.Limit)
+	for rows.Next() {
+		var height uint64
+		if err := rows.Scan(&height); err != nil {
+			return nil, 0, err
+		}
+		heights = append(heights, height)
+	}
+	return heights, total, rows.Err()
+}
+
+func (p Pagination) order() string {
+	if p.Descending {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// searchBuilder translates the conditions of a query to SQL, collecting the
+// query arguments
+type searchBuilder struct {
+	dialect dialect
+	args    []any
+}
+
+// arg adds a query argument and returns its placeholder
+func (b *searchBuilder) arg(value any) string {
+	b.args = append(b.args, value)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+func (b *searchBuilder) conditions(q *query.Query, scope searchScope) (string, error) {
+	conditions := make([]string, 0, len(q.Conditions()))
+	for _, c := range q.Conditions() {
+		condition, err := b.condition(c, scope)
+		if err != nil {
+			return "", err
+		}
+		conditions = append(conditions, condition)
+	}
+	if len(conditions) == 0 {
+		return "1=1", nil
+	}
+	return strings.Join(conditions, " AND "), nil
+}
+
+func (b *searchBuilder) condition(c query.Condition, scope searchScope) (string, error) {
+	switch c.Tag {
+	case types.EventTypeKey:
+		if c.Match(scope.eventType) {
+			return "1=1", nil
+		}
+		return "1=0", nil
+
+	case scope.heightKey:
+		return b.compare(scope.heightColumn, c, true), nil
+
+	case scope.hashKey:
+		hash, err := txHash(c)
+		if err != nil {
+			return "", err
+		}
+		return scope.hashColumn + " = " + b.arg(hash), nil
+	}
+
+	eventType, key, found := strings.Cut(c.Tag, ".")
+	if !found {
+		// A tag that is an event type matches the events of that type
+		// against an empty value
+		if !c.Match("") {
+			return "1=0", nil
+		}
+		return fmt.Sprintf("EXISTS (SELECT 1 FROM %s e WHERE %s AND e.type = %s)",
+			b.dialect.table("event"), scope.eventFilter, b.arg(eventType)), nil
+	}
+
+	return fmt.Sprintf(`EXISTS (SELECT 1 FROM %s e JOIN %s a ON a.event_id = e.id
+		WHERE %s AND e.type = %s AND a.key = %s AND a.indexed = TRUE AND %s)`,
+		b.dialect.table("event"), b.dialect.table("event_attribute"), scope.eventFilter, b.arg(eventType), b.arg(key), b.compare("a.value", c, false)), nil
+}
+
+// txHash returns the hash of a tx.hash condition, which only supports the =
+// operator with a hex string, in any case
+func txHash(c query.Condition) ([]byte, error) {
+	if c.Op != query.OpEqual || c.Type != query.TypeString {
+		return nil, fmt.Errorf("%s only supports the = operator with a string", c.Tag)
+	}
+	hash, err := hex.DecodeString(c.Operand)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %w", c.Tag, c.Operand, err)
+	}
+	return hash, nil
+}
+
+// compare translates the comparison of a column with the condition operand.
+// The attribute values are text, and they are compared as numbers by their
+// leading number, and as dates and times by the instant they denote.
+func (b *searchBuilder) compare(column string, c query.Condition, numericColumn bool) string {
+	if numericColumn && (c.Op == query.OpContains || c.Type == query.TypeString) {
+		column = "CAST(" + column + " AS TEXT)"
+		numericColumn = false
+	}
+
+	switch {
+	case c.Op == query.OpExists:
+		return "1=1"
+	case c.Op == query.OpContains:
+		return b.dialect.contains(column, b.arg(c.Operand))
+	case c.Type == query.TypeString:
+		return column + " = " + b.arg(c.Operand)
+	case c.Type == query.TypeNumber:
+		if !numericColumn {
+			column = b.dialect.numeric(column)
+		}
+		return column + " " + c.Op.String() + " " + b.dialect.numeric(b.arg(c.Operand))
+	case (c.Type == query.TypeDate || c.Type == query.TypeTime) && !numericColumn:
+		return b.dialect.timestamp(column) + " " + c.Op.String() + " " + b.dialect.timestamp(b.arg(c.Operand))
+	default:
+		return "1=0"
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Memory search
+
+// SearchTxs returns a page of the positions of the transactions matching the
+// query, along with the total number of matches. Only the transactions with
+// their results stored are searched.
+func (c *MemoryStorage) SearchTxs(ctx context.Context, q *query.Query, page Pagination) ([]TxPosition, int, error) {
+	// The tx.hash operands, compared as bytes like in the SQL search
+	hashes := make(map[string][]byte)
+	for _, condition := range q.Conditions() {
+		if condition.Tag != types.TxHashKey {
+			continue
+		}
+		hash, err := txHash(condition)
+		if err != nil {
+			return nil, 0, err
+		}
+		hashes[condition.Operand] = hash
+	}
+
+	matches := make([]TxPosition, 0)
+	for _, height := range c.resultHeights() {
+		block, err := c.GetBlock(ctx, height)
+		if err != nil {
+			continue
+		}
+		results, err := c.GetBlockResults(ctx, height)
+		if err != nil {
+			return nil, 0, err
+		}
+		if block.Block == nil {
+			continue
+		}
+
+		for i, tx := range block.Block.Txs {
+			if i >= len(results.TxResults) || results.TxResults[i] == nil {
+				continue
+			}
+			events := flattenEvents(results.TxResults[i].Events)
+			events[types.EventTypeKey] = []string{types.EventTx}
+			events[types.TxHeightKey] = []string{fmt.Sprint(height)}
+			events[types.TxHashKey] = hashOperands(hashes, tx.Hash())
+			if q.Matches(events) {
+				matches = append(matches, TxPosition{Height: height, Index: uint32(i)})
+			}
+		}
+	}
+
+	if page.Descending {
+		for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+			matches[i], matches[j] = matches[j], matches[i]
+		}
+	}
+	return paginate(matches, page), len(matches), nil
+}
+
+// SearchBlocks returns a page of the heights of the blocks whose finalize block
+// events match the query, along with the total number of matches. Only the
+// blocks with their results stored are searched.
+func (c *MemoryStorage) SearchBlocks(ctx context.Context, q *query.Query, page Pagination) ([]uint64, int, error) {
+	matches := make([]uint64, 0)
+	for _, height := range c.resultHeights() {
+		if _, err := c.get(c.blocks, height); err != nil {
+			continue
+		}
+		results, err := c.GetBlockResults(ctx, height)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		finalizeBlockEvents := make([]abci.Event, 0, len(results.FinalizeBlockEvents))
+		for _, event := range results.FinalizeBlockEvents {
+			if event != nil {
+				finalizeBlockEvents = append(finalizeBlockEvents, *event)
+			}
+		}
+		events := flattenEvents(finalizeBlockEvents)
+		events[types.EventTypeKey] = []string{types.EventNewBlock}
+		events[types.BlockHeightKey] = []string{fmt.Sprint(height)}
+		if q.Matches(events) {
+			matches = append(matches, height)
+		}
+	}
+
+	if page.Descending {
+		for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+			matches[i], matches[j] = matches[j], matches[i]
+		}
+	}
+	return paginate(matches, page), len(matches), nil
+}
+
+// hashOperands returns the tx.hash operands equal to the hash, as the values
+// of the tx.hash key matching them
+func hashOperands(hashes map[string][]byte, hash []byte) []string {
+	operands := make([]string, 0, len(hashes))
+	for operand, h := range hashes {
+		if bytes.Equal(h, hash) {
+			operands = append(operands, operand)
+		}
+	}
+	return operands
+}
+
+// resultHeights returns the heights with block results stored, in ascending
+// order
+func (c *MemoryStorage) resultHeights() []uint64 {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	heights := make([]uint64, 0, len(c.blockResults))
+	for height := range c.blockResults {
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+	return heights
+}
+
+// flattenEvents maps the composite keys of the indexed event attributes to
+// their values
+func flattenEvents(events []abci.Event) map[string][]string {
+	flattened := make(map[string][]string)
+	for _, event := range events {
+		for _, attr := range event.Attributes {
+			if !attr.Index {
+				continue
+			}
+			key := event.Type + "." + attr.Key
+			flattened[key] = append(flattened[key], attr.Value)
+		}
+	}
+	return flattened
+}
+
+func paginate[T any](matches []T, page Pagination) []T {
+	if page.Offset >= len(matches) {
+		return []T{}
+	}
+	end := min(page.Offset+page.Limit, len(matches))
+	return matches[page.Offset:end]
+}
@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	abci "github.com/cometbft/cometbft/abci/types"
+	"github.com/cometbft/cometbft/rpc/grpc/client"
+	"github.com/cometbft/cometbft/types"
+	"github.com/cometbft/rpc-companion/libs/query"
+	"github.com/stretchr/testify/require"
+)
+
+// searchAmounts are the values of the transfer.amount attribute at each height
+// of the search tests, compared as numbers by their leading number
+var searchAmounts = map[uint64]string{
+	1: "-5",
+	2: "10stake",
+	3: "1e3",
+	4: "20",
+	5: "abc",
+	6: "7.5",
+}
+
+// newSearchTestStorage stores the heights of the search tests. Each block has
+// two transactions: the first one transfers the amount of the height, the
+// second one has a message event with a non-indexed attribute. The finalize
+// block events reward the amount of the height.
+func newSearchTestStorage(t *testing.T, db IStorage) {
+	t.Helper()
+	ctx := context.Background()
+	for height := uint64(1); height <= uint64(len(searchAmounts)); height++ {
+		amount := searchAmounts[height]
+		blockResults := &client.BlockResults{
+			Height: int64(height),
+			TxResults: []*abci.ExecTxResult{
+				{Events: []abci.Event{{
+					Type: "transfer",
+					Attributes: []abci.EventAttribute{
+						{Key: "amount", Value: amount, Index: true},
+						{Key: "recipient", Value: fmt.Sprintf("addr-%d", height%3), Index: true},
+					},
+				}}},
+				{Events: []abci.Event{{
+					Type:       "message",
+					Attributes: []abci.EventAttribute{{Key: "memo", Value: "secret", Index: false}},
+				}}},
+			},
+			FinalizeBlockEvents: []*abci.Event{{
+				Type:       "reward",
+				Attributes: []abci.EventAttribute{{Key: "amount", Value: amount, Index: true}},
+			}},
+		}
+		block := testBlock(height, fmt.Sprintf("transfer-%d", height), fmt.Sprintf("message-%d", height))
+		require.NoError(t, db.InsertHeight(ctx, height, block, blockResults))
+	}
+}
+
+// txPositions returns the positions of the first tx at the heights
+func txPositions(heights ...uint64) []TxPosition {
+	positions := make([]TxPosition, 0, len(heights))
+	for _, height := range heights {
+		positions = append(positions, TxPosition{Height: height})
+	}
+	return positions
+}
+
+func TestSearchTxs(t *testing.T) {
+	ctx := context.Background()
+	hash := fmt.Sprintf("%X", types.Tx("transfer-4").Hash())
+
+	cases := []struct {
+		query string
+		page  Pagination
+		want  []TxPosition
+		total int
+	}{
+		// Operators
+		{query: "transfer.recipient = 'addr-1'", want: txPositions(1, 4), total: 2},
+		{query: "transfer.amount = 20", want: txPositions(4), total: 1},
+		{query: "transfer.amount > 7.5", want: txPositions(2, 3, 4), total: 3},
+		{query: "transfer.amount >= 7.5", want: txPositions(2, 3, 4, 6), total: 4},
+		{query: "transfer.amount < 10", want: txPositions(1, 6), total: 2},
+		{query: "transfer.amount <= 10", want: txPositions(1, 2, 6), total: 3},
+		{query: "transfer.amount > -10", want: txPositions(1, 2, 3, 4, 6), total: 5},
+		{query: "transfer.amount < -1", want: txPositions(1), total: 1},
+		{query: "transfer.amount = 1e3", want: txPositions(3), total: 1},
+		{query: "transfer.amount > 1.5e1", want: txPositions(3, 4), total: 2},
+		{query: "transfer.recipient CONTAINS 'dr-2'", want: txPositions(2, 5), total: 2},
+		{query: "transfer.amount CONTAINS 'stake'", want: txPositions(2), total: 1},
+		{query: "transfer.amount EXISTS", want: txPositions(1, 2, 3, 4, 5, 6), total: 6},
+		{query: "transfer EXISTS", want: txPositions(1, 2, 3, 4, 5, 6), total: 6},
+		{query: "transfer.sender EXISTS", want: []TxPosition{}, total: 0},
+		{query: "transfer.amount > 5 AND transfer.recipient = 'addr-0'", want: txPositions(3, 6), total: 2},
+		// A non-indexed attribute is not searchable
+		{query: "message.memo = 'secret'", want: []TxPosition{}, total: 0},
+		// Reserved keys
+		{query: "tm.event = 'Tx'", page: Pagination{Limit: 3}, want: []TxPosition{{1, 0}, {1, 1}, {2, 0}}, total: 12},
+		{query: "tm.event = 'NewBlock'", want: []TxPosition{}, total: 0},
+		{query: "tx.height = 5", want: []TxPosition{{5, 0}, {5, 1}}, total: 2},
+		{query: "tx.height > 5", want: []TxPosition{{6, 0}, {6, 1}}, total: 2},
+		{query: "tx.height <= 1 AND transfer.amount EXISTS", want: txPositions(1), total: 1},
+		{query: "tx.height > 2 AND tx.height < 4", want: []TxPosition{{3, 0}, {3, 1}}, total: 2},
+		{query: "tx.hash = '" + hash + "'", want: txPositions(4), total: 1},
+		{query: "tx.hash = '" + strings.ToLower(hash) + "'", want: txPositions(4), total: 1},
+		{query: "tx.hash = '" + strings.Repeat("0", 64) + "'", want: []TxPosition{}, total: 0},
+		// Order and pagination
+		{query: "transfer.amount EXISTS", page: Pagination{Limit: 2, Descending: true}, want: txPositions(6, 5), total: 6},
+		{query: "transfer.amount EXISTS", page: Pagination{Limit: 2, Offset: 2}, want: txPositions(3, 4), total: 6},
+		{query: "transfer.amount EXISTS", page: Pagination{Limit: 4, Offset: 4, Descending: true}, want: txPositions(2, 1), total: 6},
+		{query: "transfer.amount EXISTS", page: Pagination{Limit: 2, Offset: 6}, want: []TxPosition{}, total: 6},
+	}
+
+	results := make(map[string][]TxPosition)
+	for _, backend := range backends {
+		db := backend.storage(t)
+		newSearchTestStorage(t, db)
+
+		for _, tc := range cases {
+			if tc.page.Limit == 0 {
+				tc.page.Limit = 10
+			}
+			t.Run(backend.name+"/"+tc.query, func(t *testing.T) {
+				positions, total, err := db.SearchTxs(ctx, query.MustCompile(tc.query), tc.page)
+				require.NoError(t, err)
+				require.Equal(t, tc.want, positions)
+				require.Equal(t, tc.total, total)
+			})
+		}
+
+		// The backends return the same results for every query
+		for _, tc := range cases {
+			positions, _, err := db.SearchTxs(ctx, query.MustCompile(tc.query), Pagination{Limit: 100})
+			require.NoError(t, err)
+			if expected, ok := results[tc.query]; ok {
+				require.Equal(t, expected, positions, "%s: %s", backend.name, tc.query)
+			}
+			results[tc.query] = positions
+		}
+	}
+}
+
+func TestSearchTxsInvalidHash(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			db := backend.storage(t)
+			newSearchTestStorage(t, db)
+
+			for _, q := range []string{"tx.hash = 'not hex'", "tx.hash CONTAINS 'AB'", "tx.hash EXISTS"} {
+				_, _, err := db.SearchTxs(ctx, query.MustCompile(q), Pagination{Limit: 10})
+				require.Error(t, err, q)
+			}
+		})
+	}
+}
+
+func TestSearchBlocks(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		query string
+		page  Pagination
+		want  []uint64
+		total int
+	}{
+		{query: "reward.amount = 20", want: []uint64{4}, total: 1},
+		{query: "reward.amount > 7.5", want: []uint64{2, 3, 4}, total: 3},
+		{query: "reward.amount <= 10", want: []uint64{1, 2, 6}, total: 3},
+		{query: "reward.amount < 0", want: []uint64{1}, total: 1},
+		{query: "reward.amount = 'abc'", want: []uint64{5}, total: 1},
+		{query: "reward.amount CONTAINS 'e'", want: []uint64{2, 3}, total: 2},
+		{query: "reward.amount EXISTS", want: []uint64{1, 2, 3, 4, 5, 6}, total: 6},
+		{query: "reward EXISTS", want: []uint64{1, 2, 3, 4, 5, 6}, total: 6},
+		// The tx events are not block events
+		{query: "transfer.amount EXISTS", want: []uint64{}, total: 0},
+		// Reserved keys
+		{query: "tm.event = 'NewBlock'", want: []uint64{1, 2, 3, 4, 5, 6}, total: 6},
+		{query: "tm.event = 'Tx'", want: []uint64{}, total: 0},
+		{query: "block.height = 3", want: []uint64{3}, total: 1},
+		{query: "block.height >= 5", want: []uint64{5, 6}, total: 2},
+		{query: "block.height > 1 AND reward.amount < 10", want: []uint64{6}, total: 1},
+		// Order and pagination
+		{query: "block.height > 0", page: Pagination{Limit: 4, Descending: true}, want: []uint64{6, 5, 4, 3}, total: 6},
+		{query: "block.height > 0", page: Pagination{Limit: 4, Offset: 4}, want: []uint64{5, 6}, total: 6},
+		{query: "block.height > 0", page: Pagination{Limit: 4, Offset: 4, Descending: true}, want: []uint64{2, 1}, total: 6},
+	}
+
+	results := make(map[string][]uint64)
+	for _, backend := range backends {
+		db := backend.storage(t)
+		newSearchTestStorage(t, db)
+
+		for _, tc := range cases {
+			if tc.page.Limit == 0 {
+				tc.page.Limit = 10
+			}
+			t.Run(backend.name+"/"+tc.query, func(t *testing.T) {
+				heights, total, err := db.SearchBlocks(ctx, query.MustCompile(tc.query), tc.page)
+				require.NoError(t, err)
+				require.Equal(t, tc.want, heights)
+				require.Equal(t, tc.total, total)
+			})
+		}
+
+		// The backends return the same results for every query
+		for _, tc := range cases {
+			heights, _, err := db.SearchBlocks(ctx, query.MustCompile(tc.query), Pagination{Limit: 100})
+			require.NoError(t, err)
+			if expected, ok := results[tc.query]; ok {
+				require.Equal(t, expected, heights, "%s: %s", backend.name, tc.query)
+			}
+			results[tc.query] = heights
+		}
+	}
+}
+
+func TestSearchBlocksDateTime(t *testing.T) {
+	ctx := context.Background()
+	// The value of the indexed attribute at each height
+	values := map[uint64]string{
+		1: "2023-01-01T10:00:00+02:00",
+		2: "2023-01-01T09:00:00Z",
+		3: "2023-01-02",
+		4: "2023-01-01T23:30:00-01:00",
+		5: "not a time",
+		6: "2023-01-01T09:00:00",
+	}
+
+	cases := []struct {
+		query string
+		want  []uint64
+	}{
+		// The times are compared as instants, whatever their time zone
+		{"transfer.at > TIME 2023-01-01T08:30:00Z", []uint64{2, 3, 4}},
+		{"transfer.at = TIME 2023-01-01T08:00:00Z", []uint64{1}},
+		{"transfer.at <= TIME 2023-01-01T11:00:00+02:00", []uint64{1, 2}},
+		// A date is midnight UTC
+		{"transfer.at >= DATE 2023-01-02", []uint64{3, 4}},
+		{"transfer.at < DATE 2023-01-02", []uint64{1, 2}},
+		{"transfer.at = DATE 2023-01-02", []uint64{3}},
+	}
+
+	for _, backend := range backends {
+		db := backend.storage(t)
+		for height := uint64(1); height <= uint64(len(values)); height++ {
+			blockResults := &client.BlockResults{
+				Height: int64(height),
+				FinalizeBlockEvents: []*abci.Event{{
+					Type:       "transfer",
+					Attributes: []abci.EventAttribute{{Key: "at", Value: values[height], Index: true}},
+				}},
+			}
+			require.NoError(t, db.InsertHeight(ctx, height, testBlock(height), blockResults))
+		}
+
+		for _, tc := range cases {
+			t.Run(backend.name+"/"+tc.query, func(t *testing.T) {
+				heights, total, err := db.SearchBlocks(ctx, query.MustCompile(tc.query), Pagination{Limit: 10})
+				require.NoError(t, err)
+				require.Equal(t, tc.want, heights)
+				require.Equal(t, len(tc.want), total)
+			})
+		}
+	}
+}
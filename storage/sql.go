@@ -0,0 +1,490 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cometbft/cometbft/rpc/grpc/client"
+)
+
+var (
+	blockTable        = "block"
+	blockResultsTable = "block_results"
+)
+
+// dialect defines the differences between the SQL databases used as storage
+type dialect struct {
+	// schema qualifies the table names, it is empty if the database has no
+	// schemas
+	schema string
+	// isUniqueViolation reports whether the database error is caused by a
+	// duplicate primary key
+	isUniqueViolation func(err error) bool
+	// numeric converts a text expression to the number it starts with, or
+	// NULL if it doesn't start with a number
+	numeric func(expr string) string
+	// contains returns a condition checking the text expression contains
+	// the substring
+	contains func(expr string, substr string) string
+	// timestamp converts a text expression holding a date or an RFC3339 time
+	// to a value comparing as the instant it denotes, or NULL if it holds
+	// neither. A date is midnight UTC, like in the query package.
+	timestamp func(expr string) string
+	// placeholder returns the placeholder of the nth parameter of a
+	// multi-row statement, where each parameter is used once and in order
+	placeholder func(n int) string
+	// migrations is the directory with the embedded migrations of the database
+	migrations string
+	// migrationsTable creates the table tracking the applied migrations
+	migrationsTable string
+}
+
+// table returns the name of a table qualified with the schema
+func (d dialect) table(name string) string {
+	return d.schema + name
+}
+
+// sqlStorage implements the IStorage operations shared by the SQL databases
+type sqlStorage struct {
+	connection *sql.DB
+	dialect    dialect
+	// encoding of the inserted blocks and block results
+	encoding Encoding
+}
+
+var _ Reencoder = (*sqlStorage)(nil)
+
+// Option configures a SQL storage
+type Option func(*sqlStorage)
+
+// WithEncoding sets the encoding of the inserted blocks and block results,
+// DefaultEncoding otherwise
+func WithEncoding(encoding Encoding) Option {
+	return func(c *sqlStorage) {
+		c.encoding = encoding
+	}
+}
+
+func (c *sqlStorage) Disconnect() error {
+	err := c.connection.Close()
+	if err != nil {
+		return err
+	} else {
+		return nil
+	}
+}
+
+func (c *sqlStorage) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	err := c.connection.PingContext(ctx)
+	if err != nil {
+		return err
+	} else {
+		return nil
+	}
+}
+
+// InsertHeights stores the data of the heights in a single transaction, with
+// multi-row inserts. The normalized rows are only inserted along with new data.
+func (c *sqlStorage) InsertHeights(ctx context.Context, heights []HeightData) error {
+	if len(heights) == 0 {
+		return nil
+	}
+
+	blocks := make([]encodedData, 0, len(heights))
+	blockResults := make([]encodedData, 0, len(heights))
+	for _, h := range heights {
+		if block := h.Block; block != nil {
+			data, err := c.encoding.EncodeBlock(block)
+			if err != nil {
+				return err
+			}
+			blocks = append(blocks, encodedData{
+				height:   h.Height,
+				encoding: c.encoding,
+				data:     data,
+				marshal:  func(format string) ([]byte, error) { return marshalBlock(format, block) },
+			})
+		}
+		if results := h.BlockResults; results != nil {
+			data, err := c.encoding.EncodeBlockResults(results)
+			if err != nil {
+				return err
+			}
+			blockResults = append(blockResults, encodedData{
+				height:   h.Height,
+				encoding: c.encoding,
+				data:     data,
+				marshal:  func(format string) ([]byte, error) { return marshalBlockResults(format, results) },
+			})
+		}
+	}
+
+	err := c.transact(ctx, func(tx *sqlTx) error {
+		newBlocks, err := insertData(tx, blockTable, blocks)
+		if err != nil {
+			return err
+		}
+		newBlockResults, err := insertData(tx, blockResultsTable, blockResults)
+		if err != nil {
+			return err
+		}
+		if err := clearQuarantine(tx, "block", blocks); err != nil {
+			return err
+		}
+		if err := clearQuarantine(tx, "block_results", blockResults); err != nil {
+			return err
+		}
+
+		rows := &rowBatch{}
+		for _, h := range heights {
+			if newBlocks[h.Height] {
+				rows.addBlock(h.Height, h.Block)
+			}
+			if newBlockResults[h.Height] {
+				if err := rows.addBlockResults(h.Height, h.BlockResults); err != nil {
+					return err
+				}
+			}
+		}
+		return rows.insert(tx)
+	})
+	if err != nil && c.dialect.isUniqueViolation(err) {
+		return fmt.Errorf("%w: heights %d to %d", ErrHeightConflict, heights[0].Height, heights[len(heights)-1].Height)
+	}
+	return err
+}
+
+// InsertHeight stores the block and the block results at a height in a single
+// transaction
+func (c *sqlStorage) InsertHeight(ctx context.Context, height uint64, block *client.Block, blockResults *client.BlockResults) error {
+	return c.InsertHeights(ctx, []HeightData{{Height: height, Block: block, BlockResults: blockResults}})
+}
+
+// QuarantineHeight stores the quarantined data, encoded like the verified data
+func (c *sqlStorage) QuarantineHeight(ctx context.Context, data QuarantinedData) error {
+	var encoded []byte
+	var err error
+	if data.Block != nil {
+		encoded, err = c.encoding.EncodeBlock(data.Block)
+	} else {
+		encoded, err = c.encoding.EncodeBlockResults(data.BlockResults)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = c.exec(ctx, fmt.Sprintf(`
+		INSERT INTO %s (height, kind, failed_check, reason, data, encoding, quarantined_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)
+		ON CONFLICT (height, kind) DO UPDATE SET
+			failed_check=excluded.failed_check, reason=excluded.reason, data=excluded.data,
+			encoding=excluded.encoding, quarantined_at=excluded.quarantined_at`, c.dialect.table("quarantine")),
+		data.Height, data.Kind(), data.Check, data.Reason, encoded, c.encoding.String(), time.Now().UTC())
+	return err
+}
+
+// GetQuarantined returns the quarantined data, ordered by height and kind
+func (c *sqlStorage) GetQuarantined(ctx context.Context) ([]QuarantineEntry, error) {
+	rows, err := c.query(ctx, fmt.Sprintf("SELECT height, kind, failed_check, reason, quarantined_at FROM %s ORDER BY height, kind",
+		c.dialect.table("quarantine")))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]QuarantineEntry, 0)
+	for rows.Next() {
+		var e QuarantineEntry
+		if err := rows.Scan(&e.Height, &e.Kind, &e.Check, &e.Reason, &e.QuarantinedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (c *sqlStorage) InsertBlock(ctx context.Context, height uint64, block *client.Block) error {
+	return c.InsertHeight(ctx, height, block, nil)
+}
+
+// GetBlock returns the block at a height, decoded with the encoding it was
+// stored with
+func (c *sqlStorage) GetBlock(ctx context.Context, height uint64) (*client.Block, error) {
+	var data []byte
+	var tag string
+	row := c.queryRow(ctx, fmt.Sprintf("SELECT data, encoding FROM %s WHERE height=$1", c.dialect.table(blockTable)), height)
+	err := row.Scan(&data, &tag)
+	if err != nil {
+		return nil, c.mapError(err, height)
+	}
+	encoding, err := ParseEncoding(tag)
+	if err != nil {
+		return nil, err
+	}
+	return encoding.DecodeBlock(data)
+}
+
+func (c *sqlStorage) InsertBlockResults(ctx context.Context, height uint64, blockResults *client.BlockResults) error {
+	return c.InsertHeight(ctx, height, nil, blockResults)
+}
+
+// GetBlockResults returns the block results at a height, decoded with the
+// encoding they were stored with
+func (c *sqlStorage) GetBlockResults(ctx context.Context, height uint64) (*client.BlockResults, error) {
+	var data []byte
+	var tag string
+	row := c.queryRow(ctx, fmt.Sprintf("SELECT data, encoding FROM %s WHERE height=$1", c.dialect.table(blockResultsTable)), height)
+	err := row.Scan(&data, &tag)
+	if err != nil {
+		return nil, c.mapError(err, height)
+	}
+	encoding, err := ParseEncoding(tag)
+	if err != nil {
+		return nil, err
+	}
+	return encoding.DecodeBlockResults(data)
+}
+
+// Reencode re-encodes the rows stored with another encoding than the
+// configured one, the blocks first and then the block results
+func (c *sqlStorage) Reencode(ctx context.Context, limit int) (int, error) {
+	count := 0
+	err := c.transact(ctx, func(tx *sqlTx) error {
+		var err error
+		count, err = c.reencodeRows(tx, blockTable, limit, func(from Encoding, data []byte) ([]byte, error) {
+			block, err := from.DecodeBlock(data)
+			if err != nil {
+				return nil, err
+			}
+			return c.encoding.EncodeBlock(block)
+		})
+		if err != nil || count >= limit {
+			return err
+		}
+
+		n, err := c.reencodeRows(tx, blockResultsTable, limit-count, func(from Encoding, data []byte) ([]byte, error) {
+			blockResults, err := from.DecodeBlockResults(data)
+			if err != nil {
+				return nil, err
+			}
+			return c.encoding.EncodeBlockResults(blockResults)
+		})
+		count += n
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// reencodeRows re-encodes up to `limit` rows of the table stored with another
+// encoding, and returns the number of rows re-encoded
+func (c *sqlStorage) reencodeRows(tx *sqlTx, table string, limit int, reencode func(from Encoding, data []byte) ([]byte, error)) (int, error) {
+	tag := c.encoding.String()
+	rows, err := tx.query(fmt.Sprintf("SELECT height, data, encoding FROM %s WHERE encoding <> $1 ORDER BY height LIMIT $2", tx.dialect.table(table)), tag, limit)
+	if err != nil {
+		return 0, err
+	}
+	stored := make([]encodedData, 0, limit)
+	for rows.Next() {
+		var d encodedData
+		var storedTag string
+		if err := rows.Scan(&d.height, &d.data, &storedTag); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if d.encoding, err = ParseEncoding(storedTag); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error reading %s at height %d: %w", table, d.height, err)
+		}
+		stored = append(stored, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, d := range stored {
+		data, err := reencode(d.encoding, d.data)
+		if err != nil {
+			return 0, fmt.Errorf("error re-encoding %s at height %d: %w", table, d.height, err)
+		}
+		_, err = tx.exec(fmt.Sprintf("UPDATE %s SET data=$1, encoding=$2 WHERE height=$3", tx.dialect.table(table)), data, tag, d.height)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(stored), nil
+}
+
+// GetLatestHeight returns the highest height with a block stored
+func (c *sqlStorage) GetLatestHeight(ctx context.Context) (uint64, error) {
+	return c.boundHeight(ctx, "MAX")
+}
+
+// GetEarliestHeight returns the lowest height with a block stored
+func (c *sqlStorage) GetEarliestHeight(ctx context.Context) (uint64, error) {
+	return c.boundHeight(ctx, "MIN")
+}
+
+// GetContiguousHeight returns the highest height such that every block from the
+// `from` height up to it is stored. It returns 0 if the block at the `from`
+// height is not stored.
+func (c *sqlStorage) GetContiguousHeight(ctx context.Context, from uint64) (uint64, error) {
+	return c.contiguousHeight(ctx, blockTable, from)
+}
+
+// GetContiguousBlockResultsHeight returns the highest height such that every
+// block results from the `from` height up to it are stored. It returns 0 if the
+// block results at the `from` height are not stored.
+func (c *sqlStorage) GetContiguousBlockResultsHeight(ctx context.Context, from uint64) (uint64, error) {
+	return c.contiguousHeight(ctx, blockResultsTable, from)
+}
+
+// GetMissingHeights returns the heights in the [from, to] range that don't
+// have a block stored, in ascending order.
+func (c *sqlStorage) GetMissingHeights(ctx context.Context, from uint64, to uint64) ([]uint64, error) {
+	return c.missingHeights(ctx, blockTable, from, to)
+}
+
+// GetMissingBlockResultsHeights returns the heights in the [from, to] range
+// that don't have block results stored, in ascending order.
+func (c *sqlStorage) GetMissingBlockResultsHeights(ctx context.Context, from uint64, to uint64) ([]uint64, error) {
+	return c.missingHeights(ctx, blockResultsTable, from, to)
+}
+
+// GetTxPosition returns the position of the transaction with the given hash,
+// using the index of the normalized tx table
+func (c *sqlStorage) GetTxPosition(ctx context.Context, hash []byte) (TxPosition, error) {
+	var position TxPosition
+	row := c.queryRow(ctx, fmt.Sprintf("SELECT height, tx_index FROM %s WHERE hash=$1 ORDER BY height, tx_index LIMIT 1", c.dialect.table("tx")), hash)
+	err := row.Scan(&position.Height, &position.Index)
+	if errors.Is(err, sql.ErrNoRows) {
+		return position, fmt.Errorf("%w: tx %X", ErrNotFound, hash)
+	}
+	return position, err
+}
+
+func (c *sqlStorage) boundHeight(ctx context.Context, aggregate string) (uint64, error) {
+	var height sql.NullInt64
+	row := c.queryRow(ctx, fmt.Sprintf("SELECT %s(height) FROM %s", aggregate, c.dialect.table(blockTable)))
+	err := row.Scan(&height)
+	if err != nil {
+		return 0, err
+	}
+	if !height.Valid {
+		return 0, ErrNotFound
+	}
+	return uint64(height.Int64), nil
+}
+
+func (c *sqlStorage) contiguousHeight(ctx context.Context, table string, from uint64) (uint64, error) {
+	var height sql.NullInt64
+	row := c.queryRow(ctx, fmt.Sprintf(`
+		WITH stored AS (
+			SELECT height, height - ROW_NUMBER() OVER (ORDER BY height) AS island
+			FROM %s
+			WHERE height >= $1
+		)
+		SELECT MAX(height) FROM stored
+		WHERE island = (SELECT island FROM stored WHERE height = $1)`, c.dialect.table(table)), from)
+	err := row.Scan(&height)
+	if err != nil {
+		return 0, err
+	}
+	if !height.Valid {
+		return 0, nil
+	}
+	return uint64(height.Int64), nil
+}
+
+func (c *sqlStorage) missingHeights(ctx context.Context, table string, from uint64, to uint64) ([]uint64, error) {
+	rows, err := c.query(ctx, fmt.Sprintf(`
+		WITH RECURSIVE series(h) AS (
+			SELECT CAST($1 AS BIGINT)
+			UNION ALL
+			SELECT h + 1 FROM series WHERE h < CAST($2 AS BIGINT)
+		)
+		SELECT h FROM series
+		WHERE NOT EXISTS (SELECT 1 FROM %s WHERE height = h)
+		ORDER BY h`, c.dialect.table(table)), from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	heights := make([]uint64, 0)
+	for rows.Next() {
+		var height uint64
+		if err := rows.Scan(&height); err != nil {
+			return nil, err
+		}
+		heights = append(heights, height)
+	}
+	return heights, rows.Err()
+}
+
+func (c *sqlStorage) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return c.connection.ExecContext(ctx, query, args...)
+}
+
+func (c *sqlStorage) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return c.connection.QueryContext(ctx, query, args...)
+}
+
+func (c *sqlStorage) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	return c.connection.QueryRowContext(ctx, query, args...)
+}
+
+// transact runs fn in a transaction, committing it if fn succeeds. The
+// transaction is rolled back if the context is canceled before the commit.
+func (c *sqlStorage) transact(ctx context.Context, fn func(tx *sqlTx) error) error {
+	tx, err := c.connection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(&sqlTx{tx: tx, ctx: ctx, dialect: c.dialect}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// sqlTx runs the queries of a transaction with its context, and holds the
+// dialect to build them
+type sqlTx struct {
+	tx      *sql.Tx
+	ctx     context.Context
+	dialect dialect
+}
+
+func (t *sqlTx) exec(query string, args ...any) (sql.Result, error) {
+	return t.tx.ExecContext(t.ctx, query, args...)
+}
+
+func (t *sqlTx) query(query string, args ...any) (*sql.Rows, error) {
+	return t.tx.QueryContext(t.ctx, query, args...)
+}
+
+func (t *sqlTx) queryRow(query string, args ...any) *sql.Row {
+	return t.tx.QueryRowContext(t.ctx, query, args...)
+}
+
+// mapError maps the database errors to the storage errors
+func (c *sqlStorage) mapError(err error, height uint64) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("%w: height %d", ErrNotFound, height)
+	case c.dialect.isUniqueViolation(err):
+		return fmt.Errorf("%w: height %d", ErrHeightConflict, height)
+	default:
+		return err
+	}
+}
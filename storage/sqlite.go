@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var (
+	sqliteDriverName = "sqlite"
+
+	// The SQLite database has no schemas, all the tables are in the main database
+	sqliteMigrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations
+(
+    version     INTEGER NOT NULL,
+    name        TEXT NOT NULL,
+    applied_at  DATETIME NOT NULL,
+    CONSTRAINT schema_migrations_pkey PRIMARY KEY (version)
+);
+`
+)
+
+// SQLiteStorage implements IStorage on top of an embedded SQLite database file
+type SQLiteStorage struct {
+	sqlStorage
+}
+
+var _ IStorage = (*SQLiteStorage)(nil)
+
+// NewSQLiteStorage opens (or creates) the SQLite database at the given path.
+// The schema is created by the migrations.
+func NewSQLiteStorage(path string, opts ...Option) (*SQLiteStorage, error) {
+	db := &SQLiteStorage{}
+	conn, err := db.Connect(path)
+	if err != nil {
+		return db, err
+	}
+	db.connection = conn
+	db.dialect = dialect{
+		schema:            "",
+		isUniqueViolation: isSQLiteUniqueViolation,
+		numeric:           numericSQLite,
+		contains:          containsSQLite,
+		timestamp:         timestampSQLite,
+		placeholder:       placeholderSQLite,
+		migrations:        "sqlite",
+		migrationsTable:   sqliteMigrationsTable,
+	}
+	db.encoding = DefaultEncoding
+	for _, opt := range opts {
+		opt(&db.sqlStorage)
+	}
+	return db, nil
+}
+
+func (c *SQLiteStorage) Connect(path string) (*sql.DB, error) {
+	// WAL allows reads while the ingest service is writing, and the busy
+	// timeout makes concurrent writers wait for the lock instead of failing
+	dsn := fmt.Sprintf("file:%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)", path)
+	db, err := sql.Open(sqliteDriverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code()
+	return code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY || code == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+// numericSQLite relies on the cast converting the leading number of the text,
+// including its sign and exponent
+func numericSQLite(expr string) string {
+	return fmt.Sprintf("CASE WHEN %[1]s GLOB '[0-9]*' OR %[1]s GLOB '-[0-9]*' THEN CAST(%[1]s AS REAL) END", expr)
+}
+
+// placeholderSQLite returns positional placeholders, binding numbered ones is
+// slow with the driver when there are many parameters
+func placeholderSQLite(int) string {
+	return "?"
+}
+
+func containsSQLite(expr string, substr string) string {
+	return fmt.Sprintf("instr(%s, %s) > 0", expr, substr)
+}
+
+// timestampSQLite converts the text matching the date and time layouts of the
+// query package to a julian day, which julianday computes in UTC
+func timestampSQLite(expr string) string {
+	return fmt.Sprintf(`CASE WHEN %[1]s GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]'
+		OR (%[1]s GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9]T[0-9][0-9]:[0-9][0-9]:[0-9][0-9]*'
+			AND (%[1]s GLOB '*Z' OR %[1]s GLOB '*[+-][0-9][0-9]:[0-9][0-9]'))
+		THEN julianday(%[1]s) END`, expr)
+}
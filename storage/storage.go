@@ -2,86 +2,162 @@ package storage
 
 import (
 	"context"
-	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
-	"github.com/cometbft/cometbft/libs/json"
 	"github.com/cometbft/cometbft/rpc/grpc/client"
-	_ "github.com/lib/pq"
+	"github.com/cometbft/rpc-companion/config"
+	"github.com/cometbft/rpc-companion/libs/query"
 )
 
 var (
-	driverName = "postgres"
+	// ErrNotFound is returned when the requested data is not stored.
+	ErrNotFound = errors.New("not found")
+	// ErrHeightConflict is returned when inserting data at a height that is
+	// already stored with different data.
+	ErrHeightConflict = errors.New("height already stored with different data")
 )
 
-type Storage struct {
-	connection *sql.DB
+// TxPosition locates a transaction in the chain
+type TxPosition struct {
+	// Height of the block including the transaction
+	Height uint64
+	// Index of the transaction in the block
+	Index uint32
 }
 
-func NewStorage(connectionString string) (Storage, error) {
-	db := Storage{}
-	conn, err := db.Connect(connectionString)
-	if err != nil {
-		return db, err
-	}
-	db.connection = conn
-	return db, nil
+// HeightData is the data stored at a height
+type HeightData struct {
+	Height uint64
+	// Block at the height, not stored if nil
+	Block *client.Block
+	// Block results at the height, not stored if nil
+	BlockResults *client.BlockResults
 }
 
-func (c *Storage) Connect(conn string) (*sql.DB, error) {
-	db, err := sql.Open(driverName, conn)
-	if err != nil {
-		return nil, err
-	} else {
-		return db, nil
-	}
+// QuarantinedData is the block or the block results of a height that failed a
+// verification check. It is stored apart from the verified data, for
+// inspection, instead of being served.
+type QuarantinedData struct {
+	Height uint64
+	// Either the block or the block results that failed the check
+	Block        *client.Block
+	BlockResults *client.BlockResults
+	// Name of the failed check, and why it failed
+	Check  string
+	Reason string
 }
 
-func (c *Storage) Disconnect() error {
-	err := c.connection.Close()
-	if err != nil {
-		return err
-	} else {
-		return nil
+// Kind returns "block" or "block_results", depending on the quarantined data
+func (q QuarantinedData) Kind() string {
+	if q.Block != nil {
+		return "block"
 	}
+	return "block_results"
 }
 
-func (c *Storage) Ping() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	err := c.connection.PingContext(ctx)
-	if err != nil {
-		return err
-	} else {
-		return nil
-	}
+// QuarantineEntry describes the data quarantined at a height, without the data
+type QuarantineEntry struct {
+	Height uint64
+	// "block" or "block_results", see QuarantinedData.Kind
+	Kind string
+	// Name of the failed check, and why it failed
+	Check         string
+	Reason        string
+	QuarantinedAt time.Time
 }
 
-func (c *Storage) InsertBlock(height uint64, block *client.Block) error {
-	data, err := json.Marshal(block)
-	if err != nil {
-		return err
-	} else {
-		_, err = c.connection.Exec("INSERT INTO comet.block (height, data) values ($1,$2)", height, &data)
-		if err != nil {
-			return err
-		} else {
-			return nil
-		}
-	}
+// IStorage defines the operations supported by the storage backends
+type IStorage interface {
+	// Ping checks the storage is reachable
+	Ping(ctx context.Context) error
+	// Disconnect releases the resources held by the storage
+	Disconnect() error
+	// CheckSchema returns ErrSchemaOutdated if the storage has pending
+	// migrations, or ErrSchemaUnknown if its schema is newer than supported
+	CheckSchema(ctx context.Context) error
+
+	// InsertHeights stores the data of the heights, and their normalized
+	// rows, in a single transaction. Storing the same data again is a no-op,
+	// if any data is stored with different data nothing is stored and
+	// ErrHeightConflict is returned. The data of the same kinds quarantined
+	// at the heights is removed.
+	InsertHeights(ctx context.Context, heights []HeightData) error
+	// InsertHeight stores the block and the block results at a height, see
+	// InsertHeights
+	InsertHeight(ctx context.Context, height uint64, block *client.Block, blockResults *client.BlockResults) error
+	// QuarantineHeight stores data that failed verification apart from the
+	// verified data, replacing any data of the same kind quarantined at the
+	// height
+	QuarantineHeight(ctx context.Context, data QuarantinedData) error
+	// GetQuarantined returns the quarantined data, ordered by height and kind
+	GetQuarantined(ctx context.Context) ([]QuarantineEntry, error)
+
+	// InsertBlock stores the block at a height, see InsertHeight
+	InsertBlock(ctx context.Context, height uint64, block *client.Block) error
+	// GetBlock returns the block at a height, or ErrNotFound
+	GetBlock(ctx context.Context, height uint64) (*client.Block, error)
+
+	// InsertBlockResults stores the block results at a height, see
+	// InsertHeight
+	InsertBlockResults(ctx context.Context, height uint64, blockResults *client.BlockResults) error
+	// GetBlockResults returns the block results at a height, or ErrNotFound
+	GetBlockResults(ctx context.Context, height uint64) (*client.BlockResults, error)
+
+	// GetLatestHeight returns the highest height with a block stored, or
+	// ErrNotFound if there are no blocks
+	GetLatestHeight(ctx context.Context) (uint64, error)
+	// GetEarliestHeight returns the lowest height with a block stored, or
+	// ErrNotFound if there are no blocks
+	GetEarliestHeight(ctx context.Context) (uint64, error)
+
+	// GetContiguousHeight returns the highest height such that every block
+	// from the `from` height up to it is stored, or 0 if the block at the
+	// `from` height is not stored
+	GetContiguousHeight(ctx context.Context, from uint64) (uint64, error)
+	// GetContiguousBlockResultsHeight is the GetContiguousHeight equivalent
+	// for block results
+	GetContiguousBlockResultsHeight(ctx context.Context, from uint64) (uint64, error)
+
+	// GetMissingHeights returns the heights in the [from, to] range that
+	// don't have a block stored, in ascending order
+	GetMissingHeights(ctx context.Context, from uint64, to uint64) ([]uint64, error)
+	// GetMissingBlockResultsHeights is the GetMissingHeights equivalent for
+	// block results
+	GetMissingBlockResultsHeights(ctx context.Context, from uint64, to uint64) ([]uint64, error)
+
+	// GetTxPosition returns the position of the transaction with the given
+	// hash, or ErrNotFound. If the transaction was included more than once,
+	// the lowest position is returned.
+	GetTxPosition(ctx context.Context, hash []byte) (TxPosition, error)
+
+	// SearchTxs returns a page of the positions of the transactions whose
+	// events match the query, ordered by position, along with the total
+	// number of matches
+	SearchTxs(ctx context.Context, q *query.Query, page Pagination) ([]TxPosition, int, error)
+	// SearchBlocks returns a page of the heights of the blocks whose finalize
+	// block events match the query, ordered by height, along with the total
+	// number of matches
+	SearchBlocks(ctx context.Context, q *query.Query, page Pagination) ([]uint64, int, error)
 }
 
-func (c *Storage) GetHeader(height uint64) (*client.Block, error) {
-	var block *client.Block
-	var data []byte
-	row := c.connection.QueryRow("SELECT block FROM comet.block WHERE height=$1", height)
-	err := row.Scan(&data)
-	if err != nil {
-		return block, err
+// NewStorage creates the storage backend defined in the configuration
+func NewStorage(cfg *config.StorageConfig) (IStorage, error) {
+	if cfg.Backend == config.StorageBackendMemory {
+		return NewMemoryStorage(), nil
 	}
-	err = json.Unmarshal(data, &block)
+
+	encoding, err := NewEncoding(cfg.Encoding, cfg.Compression)
 	if err != nil {
-		return block, err
+		return nil, err
+	}
+	switch cfg.Backend {
+	case config.StorageBackendPostgres:
+		return NewPostgresStorage(cfg.Connection, WithEncoding(encoding))
+	case config.StorageBackendSQLite:
+		return NewSQLiteStorage(cfg.Connection, WithEncoding(encoding))
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
 	}
-	return block, nil
 }
@@ -0,0 +1,371 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	abci "github.com/cometbft/cometbft/abci/types"
+	"github.com/cometbft/cometbft/crypto/tmhash"
+	"github.com/cometbft/cometbft/rpc/grpc/client"
+	"github.com/cometbft/cometbft/types"
+	"github.com/stretchr/testify/require"
+)
+
+// backends are the storage backends that must behave the same
+var backends = []struct {
+	name    string
+	storage func(t *testing.T) IStorage
+}{
+	{"memory", func(*testing.T) IStorage { return NewMemoryStorage() }},
+	{"sqlite", newTestSQLiteStorage},
+}
+
+// newTestSQLiteStorage returns a migrated SQLite storage in a temporary
+// directory
+func newTestSQLiteStorage(t *testing.T) IStorage {
+	t.Helper()
+	db, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "companion.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Disconnect() }) //nolint:errcheck
+
+	_, err = db.MigrateUp(context.Background())
+	require.NoError(t, err)
+	return db
+}
+
+// testBlock returns a block at the height with the given transactions
+func testBlock(height uint64, txs ...string) *client.Block {
+	blockTxs := make([]types.Tx, len(txs))
+	for i, tx := range txs {
+		blockTxs[i] = types.Tx(tx)
+	}
+	block := types.MakeBlock(int64(height), blockTxs, &types.Commit{}, nil)
+	block.ChainID = "test-chain"
+	// The header hash is nil without the validators hash
+	block.ValidatorsHash = tmhash.Sum([]byte("validators"))
+	return &client.Block{
+		Block:   block,
+		BlockID: &types.BlockID{Hash: block.Hash()},
+	}
+}
+
+// testBlockResults returns the block results at the height, with one
+// transaction result carrying the log
+func testBlockResults(height uint64, log string) *client.BlockResults {
+	return &client.BlockResults{
+		Height:    int64(height),
+		TxResults: []*abci.ExecTxResult{{Code: 0, Log: log}},
+		AppHash:   []byte("app-hash"),
+	}
+}
+
+func TestInsertGet(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			db := backend.storage(t)
+			block := testBlock(1, "tx")
+			require.NoError(t, db.InsertBlock(ctx, 1, block))
+			require.NoError(t, db.InsertBlockResults(ctx, 1, testBlockResults(1, "log")))
+
+			stored, err := db.GetBlock(ctx, 1)
+			require.NoError(t, err)
+			require.Equal(t, block.BlockID.Hash, stored.BlockID.Hash)
+			require.Equal(t, block.Block.Txs, stored.Block.Txs)
+			blockResults, err := db.GetBlockResults(ctx, 1)
+			require.NoError(t, err)
+			require.Equal(t, "log", blockResults.TxResults[0].Log)
+
+			_, err = db.GetBlock(ctx, 2)
+			require.ErrorIs(t, err, ErrNotFound)
+			_, err = db.GetBlockResults(ctx, 2)
+			require.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
+
+func TestInsertIdempotent(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name   string
+		insert func(db IStorage) error
+	}{
+		{"InsertHeights", func(db IStorage) error {
+			return db.InsertHeights(ctx, []HeightData{
+				{Height: 1, Block: testBlock(1, "tx"), BlockResults: testBlockResults(1, "log")},
+				{Height: 2, Block: testBlock(2)},
+			})
+		}},
+		{"InsertHeight", func(db IStorage) error {
+			return db.InsertHeight(ctx, 1, testBlock(1, "tx"), testBlockResults(1, "log"))
+		}},
+		{"InsertBlock", func(db IStorage) error {
+			return db.InsertBlock(ctx, 1, testBlock(1, "tx"))
+		}},
+		{"InsertBlockResults", func(db IStorage) error {
+			return db.InsertBlockResults(ctx, 1, testBlockResults(1, "log"))
+		}},
+	}
+
+	for _, backend := range backends {
+		for _, tc := range cases {
+			t.Run(backend.name+"/"+tc.name, func(t *testing.T) {
+				db := backend.storage(t)
+				require.NoError(t, tc.insert(db))
+				latest, latestErr := db.GetLatestHeight(ctx)
+				contiguous, err := db.GetContiguousBlockResultsHeight(ctx, 1)
+				require.NoError(t, err)
+
+				require.NoError(t, tc.insert(db))
+				latestAgain, latestAgainErr := db.GetLatestHeight(ctx)
+				require.Equal(t, latestErr, latestAgainErr)
+				require.Equal(t, latest, latestAgain)
+				contiguousAgain, err := db.GetContiguousBlockResultsHeight(ctx, 1)
+				require.NoError(t, err)
+				require.Equal(t, contiguous, contiguousAgain)
+			})
+		}
+	}
+}
+
+func TestInsertConflict(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name     string
+		stored   HeightData
+		inserted []HeightData
+	}{
+		{
+			name:     "different block",
+			stored:   HeightData{Height: 1, Block: testBlock(1, "tx")},
+			inserted: []HeightData{{Height: 1, Block: testBlock(1, "other tx")}},
+		},
+		{
+			name:     "different block results",
+			stored:   HeightData{Height: 1, BlockResults: testBlockResults(1, "log")},
+			inserted: []HeightData{{Height: 1, BlockResults: testBlockResults(1, "other log")}},
+		},
+		{
+			name:     "new block with different block results",
+			stored:   HeightData{Height: 1, BlockResults: testBlockResults(1, "log")},
+			inserted: []HeightData{{Height: 1, Block: testBlock(1, "tx"), BlockResults: testBlockResults(1, "other log")}},
+		},
+		{
+			name:   "batch with one conflicting height",
+			stored: HeightData{Height: 2, Block: testBlock(2, "tx")},
+			inserted: []HeightData{
+				{Height: 1, Block: testBlock(1)},
+				{Height: 2, Block: testBlock(2, "other tx")},
+				{Height: 3, Block: testBlock(3)},
+			},
+		},
+	}
+
+	for _, backend := range backends {
+		for _, tc := range cases {
+			t.Run(backend.name+"/"+tc.name, func(t *testing.T) {
+				db := backend.storage(t)
+				require.NoError(t, db.InsertHeights(ctx, []HeightData{tc.stored}))
+
+				err := db.InsertHeights(ctx, tc.inserted)
+				require.ErrorIs(t, err, ErrHeightConflict)
+
+				// Nothing of the batch is stored
+				for _, data := range tc.inserted {
+					if data.Height == tc.stored.Height {
+						continue
+					}
+					_, err := db.GetBlock(ctx, data.Height)
+					require.ErrorIs(t, err, ErrNotFound)
+				}
+				// The stored data is unchanged
+				if tc.stored.Block != nil {
+					block, err := db.GetBlock(ctx, tc.stored.Height)
+					require.NoError(t, err)
+					require.Equal(t, tc.stored.Block.BlockID.Hash, block.BlockID.Hash)
+				} else {
+					blockResults, err := db.GetBlockResults(ctx, tc.stored.Height)
+					require.NoError(t, err)
+					require.Equal(t, tc.stored.BlockResults.TxResults[0].Log, blockResults.TxResults[0].Log)
+				}
+			})
+		}
+	}
+}
+
+func TestGetLatestEarliestHeight(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			db := backend.storage(t)
+			_, err := db.GetLatestHeight(ctx)
+			require.ErrorIs(t, err, ErrNotFound)
+			_, err = db.GetEarliestHeight(ctx)
+			require.ErrorIs(t, err, ErrNotFound)
+
+			for _, height := range []uint64{4, 7, 5} {
+				require.NoError(t, db.InsertBlock(ctx, height, testBlock(height)))
+			}
+			latest, err := db.GetLatestHeight(ctx)
+			require.NoError(t, err)
+			require.Equal(t, uint64(7), latest)
+			earliest, err := db.GetEarliestHeight(ctx)
+			require.NoError(t, err)
+			require.Equal(t, uint64(4), earliest)
+		})
+	}
+}
+
+func TestGetContiguousHeight(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name    string
+		heights []uint64
+		from    uint64
+		want    uint64
+	}{
+		{"empty", nil, 1, 0},
+		{"contiguous", []uint64{1, 2, 3}, 1, 3},
+		{"stops at the gap", []uint64{1, 2, 3, 5, 6}, 1, 3},
+		{"from after the gap", []uint64{1, 2, 3, 5, 6}, 5, 6},
+		{"from in the gap", []uint64{1, 2, 3, 5, 6}, 4, 0},
+		{"from below the first height", []uint64{3, 4}, 1, 0},
+		{"from in the middle", []uint64{1, 2, 3, 4}, 2, 4},
+	}
+
+	for _, backend := range backends {
+		for _, tc := range cases {
+			t.Run(backend.name+"/"+tc.name, func(t *testing.T) {
+				db := backend.storage(t)
+				for _, height := range tc.heights {
+					require.NoError(t, db.InsertHeight(ctx, height, testBlock(height), testBlockResults(height, "log")))
+				}
+
+				got, err := db.GetContiguousHeight(ctx, tc.from)
+				require.NoError(t, err)
+				require.Equal(t, tc.want, got)
+
+				got, err = db.GetContiguousBlockResultsHeight(ctx, tc.from)
+				require.NoError(t, err)
+				require.Equal(t, tc.want, got)
+			})
+		}
+	}
+}
+
+func TestGetMissingHeights(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			db := backend.storage(t)
+			for _, height := range []uint64{2, 3, 6} {
+				require.NoError(t, db.InsertBlock(ctx, height, testBlock(height)))
+			}
+			require.NoError(t, db.InsertBlockResults(ctx, 3, testBlockResults(3, "log")))
+
+			missing, err := db.GetMissingHeights(ctx, 1, 7)
+			require.NoError(t, err)
+			require.Equal(t, []uint64{1, 4, 5, 7}, missing)
+
+			missing, err = db.GetMissingBlockResultsHeights(ctx, 1, 4)
+			require.NoError(t, err)
+			require.Equal(t, []uint64{1, 2, 4}, missing)
+
+			missing, err = db.GetMissingHeights(ctx, 2, 3)
+			require.NoError(t, err)
+			require.Empty(t, missing)
+		})
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			db := backend.storage(t)
+			quarantined, err := db.GetQuarantined(ctx)
+			require.NoError(t, err)
+			require.Empty(t, quarantined)
+
+			for _, data := range []QuarantinedData{
+				{Height: 3, Block: testBlock(3), Check: "block_hash", Reason: "mismatch"},
+				{Height: 2, BlockResults: testBlockResults(2, "log"), Check: "app_hash", Reason: "mismatch"},
+				{Height: 3, BlockResults: testBlockResults(3, "log"), Check: "results_hash", Reason: "mismatch"},
+				// Replaces the quarantined block
+				{Height: 3, Block: testBlock(3), Check: "last_block_id", Reason: "mismatch"},
+			} {
+				require.NoError(t, db.QuarantineHeight(ctx, data))
+			}
+			quarantined, err = db.GetQuarantined(ctx)
+			require.NoError(t, err)
+			require.Len(t, quarantined, 3)
+			for i, want := range []QuarantineEntry{
+				{Height: 2, Kind: "block_results", Check: "app_hash", Reason: "mismatch"},
+				{Height: 3, Kind: "block", Check: "last_block_id", Reason: "mismatch"},
+				{Height: 3, Kind: "block_results", Check: "results_hash", Reason: "mismatch"},
+			} {
+				require.False(t, quarantined[i].QuarantinedAt.IsZero())
+				quarantined[i].QuarantinedAt = want.QuarantinedAt
+				require.Equal(t, want, quarantined[i])
+			}
+
+			// Storing the data of a kind removes it from the quarantine
+			require.NoError(t, db.InsertHeights(ctx, []HeightData{
+				{Height: 2, BlockResults: testBlockResults(2, "log")},
+				{Height: 3, Block: testBlock(3)},
+			}))
+			quarantined, err = db.GetQuarantined(ctx)
+			require.NoError(t, err)
+			require.Len(t, quarantined, 1)
+			require.Equal(t, uint64(3), quarantined[0].Height)
+			require.Equal(t, "block_results", quarantined[0].Kind)
+		})
+	}
+}
+
+func TestGetTxPosition(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name    string
+		heights []HeightData
+		want    TxPosition
+	}{
+		{
+			name:    "single inclusion",
+			heights: []HeightData{{Height: 1, Block: testBlock(1, "a", "tx")}},
+			want:    TxPosition{Height: 1, Index: 1},
+		},
+		{
+			name:    "duplicate in a block",
+			heights: []HeightData{{Height: 1, Block: testBlock(1, "tx", "a", "tx")}},
+			want:    TxPosition{Height: 1, Index: 0},
+		},
+		{
+			name: "lower height stored last",
+			heights: []HeightData{
+				{Height: 3, Block: testBlock(3, "tx")},
+				{Height: 2, Block: testBlock(2, "a", "tx", "tx")},
+			},
+			want: TxPosition{Height: 2, Index: 1},
+		},
+	}
+
+	for _, backend := range backends {
+		for _, tc := range cases {
+			t.Run(backend.name+"/"+tc.name, func(t *testing.T) {
+				db := backend.storage(t)
+				for _, data := range tc.heights {
+					require.NoError(t, db.InsertHeights(ctx, []HeightData{data}))
+				}
+
+				got, err := db.GetTxPosition(ctx, types.Tx("tx").Hash())
+				require.NoError(t, err)
+				require.Equal(t, tc.want, got)
+
+				_, err = db.GetTxPosition(ctx, types.Tx("missing").Hash())
+				require.ErrorIs(t, err, ErrNotFound)
+			})
+		}
+	}
+}
@@ -0,0 +1,553 @@
+// Package fakenode provides an in-process fake of the CometBFT gRPC services
+// used by the RPC Companion (block, block results and pruning), so the ingest
+// pipeline can be exercised without a running node.
+//
+// Typical usage:
+//
+//	node := fakenode.New("test-chain")
+//	if err := node.Start(); err != nil {
+//		...
+//	}
+//	defer node.Stop()
+//
+//	cfg.GRPCClient.ListenAddress = node.Address()
+//	cfg.GRPCClient.ListenAddressPrivileged = node.PrivilegedAddress()
+//	cfg.Storage.Backend = config.StorageBackendMemory
+//
+//	service, err := ingest.NewIngestService(logger, cfg)
+//	...
+//	node.ProduceBlocks(10)
+//	node.InjectError(fakenode.MethodGetByHeight, errors.New("boom"), 1)
+//	node.TamperBlockResults(5, func(r *brs.GetBlockResultsResponse) { r.AppHash = nil })
+//	node.DisconnectStreams()
+package fakenode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	abci "github.com/cometbft/cometbft/abci/types"
+	blocksvc "github.com/cometbft/cometbft/api/cometbft/services/block/v1"
+	brs "github.com/cometbft/cometbft/api/cometbft/services/block_results/v1"
+	pbsvc "github.com/cometbft/cometbft/api/cometbft/services/pruning/v1"
+	ptypes "github.com/cometbft/cometbft/api/cometbft/types/v1"
+	"github.com/cometbft/cometbft/crypto"
+	"github.com/cometbft/cometbft/crypto/ed25519"
+	"github.com/cometbft/cometbft/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// Method identifies a gRPC method of the fake node, used to inject errors
+type Method string
+
+const (
+	MethodGetByHeight                 Method = "GetByHeight"
+	MethodGetLatest                   Method = "GetLatest"
+	MethodGetLatestHeight             Method = "GetLatestHeight"
+	MethodGetBlockResults             Method = "GetBlockResults"
+	MethodSetBlockRetainHeight        Method = "SetBlockRetainHeight"
+	MethodGetBlockRetainHeight        Method = "GetBlockRetainHeight"
+	MethodSetBlockResultsRetainHeight Method = "SetBlockResultsRetainHeight"
+	MethodGetBlockResultsRetainHeight Method = "GetBlockResultsRetainHeight"
+)
+
+// ErrInjected is the error returned by default by the injected failures
+var ErrInjected = errors.New("injected error")
+
+// Node is a fake CometBFT node serving the regular gRPC services (block and
+// block results) and the privileged gRPC service (pruning) on two local
+// listeners, with a scripted chain of blocks.
+type Node struct {
+	mtx sync.Mutex
+
+	chainID     string
+	genesisTime time.Time
+	privKey     crypto.PrivKey
+
+	blocks       map[int64]*blocksvc.GetByHeightResponse
+	blockResults map[int64]*brs.GetBlockResultsResponse
+	latest       int64
+	lastBlockID  types.BlockID
+	lastResults  []*abci.ExecTxResult
+	lastAppHash  []byte
+
+	// Retain heights set through the pruning service, data below them is pruned
+	blockRetainHeight        uint64
+	blockResultsRetainHeight uint64
+	appRetainHeight          uint64
+
+	// Errors returned by the next calls to a method
+	injected map[Method][]error
+
+	// Active GetLatestHeight streams, closing the channel ends the stream
+	streams map[chan int64]struct{}
+
+	// Transport security of the servers, insecure if nil
+	creds           credentials.TransportCredentials
+	privilegedCreds credentials.TransportCredentials
+
+	server           *grpc.Server
+	privilegedServer *grpc.Server
+	listener         net.Listener
+	privListener     net.Listener
+}
+
+// New creates a fake node for the given chain, without any block
+func New(chainID string) *Node {
+	return &Node{
+		chainID:      chainID,
+		genesisTime:  time.Date(2023, 10, 18, 0, 0, 0, 0, time.UTC),
+		privKey:      ed25519.GenPrivKey(),
+		blocks:       make(map[int64]*blocksvc.GetByHeightResponse),
+		blockResults: make(map[int64]*brs.GetBlockResultsResponse),
+		injected:     make(map[Method][]error),
+		streams:      make(map[chan int64]struct{}),
+	}
+}
+
+// SetTLS serves the regular and the privileged services with the given
+// credentials, e.g. credentials.NewTLS, it must be called before Start
+func (n *Node) SetTLS(creds credentials.TransportCredentials, privilegedCreds credentials.TransportCredentials) {
+	n.creds = creds
+	n.privilegedCreds = privilegedCreds
+}
+
+// Start listens on two random local ports and serves the gRPC services
+func (n *Node) Start() error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("error listening: %w", err)
+	}
+	privListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("error listening: %w", err)
+	}
+
+	n.server = grpc.NewServer(serverOptions(n.creds)...)
+	blocksvc.RegisterBlockServiceServer(n.server, &blockService{node: n})
+	brs.RegisterBlockResultsServiceServer(n.server, &blockResultsService{node: n})
+
+	n.privilegedServer = grpc.NewServer(serverOptions(n.privilegedCreds)...)
+	pbsvc.RegisterPruningServiceServer(n.privilegedServer, &pruningService{node: n})
+
+	n.listener = listener
+	n.privListener = privListener
+	go n.server.Serve(listener)               //nolint:errcheck
+	go n.privilegedServer.Serve(privListener) //nolint:errcheck
+	return nil
+}
+
+func serverOptions(creds credentials.TransportCredentials) []grpc.ServerOption {
+	if creds == nil {
+		return nil
+	}
+	return []grpc.ServerOption{grpc.Creds(creds)}
+}
+
+// Stop disconnects the streams and stops the gRPC servers
+func (n *Node) Stop() {
+	n.DisconnectStreams()
+	if n.server != nil {
+		n.server.Stop()
+	}
+	if n.privilegedServer != nil {
+		n.privilegedServer.Stop()
+	}
+}
+
+// Address returns the address of the regular gRPC services
+func (n *Node) Address() string {
+	return n.listener.Addr().String()
+}
+
+// PrivilegedAddress returns the address of the privileged gRPC services
+func (n *Node) PrivilegedAddress() string {
+	return n.privListener.Addr().String()
+}
+
+// ProduceBlocks commits the given number of blocks, each with one transaction,
+// and notifies the new heights to the active streams
+func (n *Node) ProduceBlocks(count int) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	for i := 0; i < count; i++ {
+		height := n.latest + 1
+		n.commitBlock(height)
+		n.latest = height
+		for stream := range n.streams {
+			select {
+			case stream <- height:
+			default:
+				// Like the node, skip slow subscribers
+			}
+		}
+	}
+}
+
+// LatestHeight returns the height of the last committed block
+func (n *Node) LatestHeight() int64 {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return n.latest
+}
+
+// Block returns the block committed at a height, or nil if it is pruned or
+// was not committed yet
+func (n *Node) Block(height int64) *types.Block {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	res, ok := n.blocks[height]
+	if !ok {
+		return nil
+	}
+	block, err := types.BlockFromProto(res.Block)
+	if err != nil {
+		return nil
+	}
+	return block
+}
+
+// RetainHeights returns the block and block results retain heights set by
+// the pruning service
+func (n *Node) RetainHeights() (uint64, uint64) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return n.blockRetainHeight, n.blockResultsRetainHeight
+}
+
+// SetAppRetainHeight sets the retain height reported for the application,
+// and prunes the data below it like the node would
+func (n *Node) SetAppRetainHeight(height uint64) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.appRetainHeight = height
+	prune(n.blocks, height)
+	prune(n.blockResults, height)
+}
+
+// Prune removes the blocks and block results below a height without reporting
+// any retain height, like a node started from a snapshot at that height
+func (n *Node) Prune(height uint64) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	prune(n.blocks, height)
+	prune(n.blockResults, height)
+}
+
+// TamperBlock modifies the block served at a height, e.g. to serve a block
+// that fails verification. The block ID is not updated.
+func (n *Node) TamperBlock(height int64, tamper func(block *ptypes.Block, blockID *ptypes.BlockID)) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if res, ok := n.blocks[height]; ok {
+		tamper(res.Block, res.BlockId)
+	}
+}
+
+// TamperBlockResults modifies the block results served at a height
+func (n *Node) TamperBlockResults(height int64, tamper func(blockResults *brs.GetBlockResultsResponse)) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if res, ok := n.blockResults[height]; ok {
+		tamper(res)
+	}
+}
+
+// InjectError makes the next `count` calls to a method fail with the given
+// error. If err is nil, ErrInjected is used.
+func (n *Node) InjectError(method Method, err error, count int) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if err == nil {
+		err = ErrInjected
+	}
+	for i := 0; i < count; i++ {
+		n.injected[method] = append(n.injected[method], err)
+	}
+}
+
+// DisconnectStreams terminates all the active GetLatestHeight streams
+func (n *Node) DisconnectStreams() {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	for stream := range n.streams {
+		close(stream)
+		delete(n.streams, stream)
+	}
+}
+
+// StreamCount returns the number of active GetLatestHeight streams
+func (n *Node) StreamCount() int {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return len(n.streams)
+}
+
+// takeError returns the next injected error for a method, if any
+func (n *Node) takeError(method Method) error {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	errs := n.injected[method]
+	if len(errs) == 0 {
+		return nil
+	}
+	n.injected[method] = errs[1:]
+	return status.Error(codes.Unavailable, errs[0].Error())
+}
+
+// commitBlock creates the block and block results at a height, chained to the
+// previous block and signed by the single validator of the fake node
+func (n *Node) commitBlock(height int64) {
+	lastCommit := &types.Commit{}
+	if height > 1 {
+		lastCommit = n.makeCommit(height-1, n.lastBlockID)
+	}
+
+	txs := types.Txs{types.Tx(fmt.Sprintf("tx-%d=%d", height, height))}
+	block := types.MakeBlock(height, txs, lastCommit, nil)
+	block.ChainID = n.chainID
+	block.Time = n.blockTime(height)
+	block.LastBlockID = n.lastBlockID
+	block.ValidatorsHash = types.NewValidatorSet([]*types.Validator{n.validator()}).Hash()
+	block.NextValidatorsHash = block.ValidatorsHash
+	block.LastResultsHash = types.NewResults(n.lastResults).Hash()
+	block.AppHash = n.lastAppHash
+	block.ProposerAddress = n.privKey.PubKey().Address()
+
+	partSet, err := block.MakePartSet(types.BlockPartSizeBytes)
+	if err != nil {
+		panic(fmt.Sprintf("error making part set: %v", err))
+	}
+	blockID := types.BlockID{Hash: block.Hash(), PartSetHeader: partSet.Header()}
+
+	pblock, err := block.ToProto()
+	if err != nil {
+		panic(fmt.Sprintf("error converting block to proto: %v", err))
+	}
+	pblockID := blockID.ToProto()
+	n.blocks[height] = &blocksvc.GetByHeightResponse{BlockId: &pblockID, Block: pblock}
+
+	txResults := []*abci.ExecTxResult{{
+		Code: abci.CodeTypeOK,
+		Data: []byte(fmt.Sprintf("%d", height)),
+		Events: []abci.Event{{
+			Type: "transfer",
+			Attributes: []abci.EventAttribute{
+				{Key: "recipient", Value: fmt.Sprintf("addr-%d", height), Index: true},
+				{Key: "amount", Value: fmt.Sprintf("%d", height*10), Index: true},
+			},
+		}},
+	}}
+	n.blockResults[height] = &brs.GetBlockResultsResponse{
+		Height:    height,
+		TxResults: txResults,
+		FinalizeBlockEvents: []*abci.Event{{
+			Type:       "block",
+			Attributes: []abci.EventAttribute{{Key: "height", Value: fmt.Sprintf("%d", height), Index: true}},
+		}},
+		AppHash: []byte(fmt.Sprintf("app-hash-%d", height)),
+	}
+
+	n.lastBlockID = blockID
+	n.lastResults = txResults
+	n.lastAppHash = n.blockResults[height].AppHash
+}
+
+// makeCommit returns the commit signed by the validator for a block
+func (n *Node) makeCommit(height int64, blockID types.BlockID) *types.Commit {
+	vote := &types.Vote{
+		Type:             types.PrecommitType,
+		Height:           height,
+		Round:            0,
+		BlockID:          blockID,
+		Timestamp:        n.blockTime(height + 1),
+		ValidatorAddress: n.privKey.PubKey().Address(),
+		ValidatorIndex:   0,
+	}
+	pvote := vote.ToProto()
+	signature, err := n.privKey.Sign(types.VoteSignBytes(n.chainID, pvote))
+	if err != nil {
+		panic(fmt.Sprintf("error signing vote: %v", err))
+	}
+	return &types.Commit{
+		Height:  height,
+		Round:   0,
+		BlockID: blockID,
+		Signatures: []types.CommitSig{{
+			BlockIDFlag:      types.BlockIDFlagCommit,
+			ValidatorAddress: vote.ValidatorAddress,
+			Timestamp:        vote.Timestamp,
+			Signature:        signature,
+		}},
+	}
+}
+
+func (n *Node) validator() *types.Validator {
+	return types.NewValidator(n.privKey.PubKey(), 10)
+}
+
+func (n *Node) blockTime(height int64) time.Time {
+	return n.genesisTime.Add(time.Duration(height) * time.Second)
+}
+
+// prune removes the data below a retain height, the caller must hold the lock
+func prune[T any](data map[int64]T, retainHeight uint64) {
+	for height := range data {
+		if uint64(height) < retainHeight {
+			delete(data, height)
+		}
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Block service
+
+type blockService struct {
+	blocksvc.UnimplementedBlockServiceServer
+	node *Node
+}
+
+func (s *blockService) GetByHeight(_ context.Context, req *blocksvc.GetByHeightRequest) (*blocksvc.GetByHeightResponse, error) {
+	if err := s.node.takeError(MethodGetByHeight); err != nil {
+		return nil, err
+	}
+	s.node.mtx.Lock()
+	defer s.node.mtx.Unlock()
+	res, ok := s.node.blocks[req.Height]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "block at height %d not found", req.Height)
+	}
+	return res, nil
+}
+
+func (s *blockService) GetLatest(context.Context, *blocksvc.GetLatestRequest) (*blocksvc.GetLatestResponse, error) {
+	if err := s.node.takeError(MethodGetLatest); err != nil {
+		return nil, err
+	}
+	s.node.mtx.Lock()
+	defer s.node.mtx.Unlock()
+	res, ok := s.node.blocks[s.node.latest]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "latest block not found")
+	}
+	return &blocksvc.GetLatestResponse{BlockId: res.BlockId, Block: res.Block}, nil
+}
+
+func (s *blockService) GetLatestHeight(_ *blocksvc.GetLatestHeightRequest, stream blocksvc.BlockService_GetLatestHeightServer) error {
+	if err := s.node.takeError(MethodGetLatestHeight); err != nil {
+		return err
+	}
+
+	heightCh := make(chan int64, 1)
+	s.node.mtx.Lock()
+	s.node.streams[heightCh] = struct{}{}
+	s.node.mtx.Unlock()
+
+	defer func() {
+		s.node.mtx.Lock()
+		delete(s.node.streams, heightCh)
+		s.node.mtx.Unlock()
+	}()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case height, ok := <-heightCh:
+			if !ok {
+				return status.Error(codes.Unavailable, "stream disconnected")
+			}
+			if err := stream.Send(&blocksvc.GetLatestHeightResponse{Height: height}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Block results service
+
+type blockResultsService struct {
+	brs.UnimplementedBlockResultsServiceServer
+	node *Node
+}
+
+func (s *blockResultsService) GetBlockResults(_ context.Context, req *brs.GetBlockResultsRequest) (*brs.GetBlockResultsResponse, error) {
+	if err := s.node.takeError(MethodGetBlockResults); err != nil {
+		return nil, err
+	}
+	s.node.mtx.Lock()
+	defer s.node.mtx.Unlock()
+	res, ok := s.node.blockResults[req.Height]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "block results at height %d not found", req.Height)
+	}
+	return res, nil
+}
+
+//----------------------------------------------------------------------------------------------------------------------
+// Pruning service
+
+type pruningService struct {
+	pbsvc.UnimplementedPruningServiceServer
+	node *Node
+}
+
+func (s *pruningService) SetBlockRetainHeight(_ context.Context, req *pbsvc.SetBlockRetainHeightRequest) (*pbsvc.SetBlockRetainHeightResponse, error) {
+	if err := s.node.takeError(MethodSetBlockRetainHeight); err != nil {
+		return nil, err
+	}
+	s.node.mtx.Lock()
+	defer s.node.mtx.Unlock()
+	if req.Height > uint64(s.node.latest) {
+		return nil, status.Errorf(codes.InvalidArgument, "retain height %d is higher than the latest height %d", req.Height, s.node.latest)
+	}
+	s.node.blockRetainHeight = req.Height
+	prune(s.node.blocks, req.Height)
+	return &pbsvc.SetBlockRetainHeightResponse{}, nil
+}
+
+func (s *pruningService) GetBlockRetainHeight(context.Context, *pbsvc.GetBlockRetainHeightRequest) (*pbsvc.GetBlockRetainHeightResponse, error) {
+	if err := s.node.takeError(MethodGetBlockRetainHeight); err != nil {
+		return nil, err
+	}
+	s.node.mtx.Lock()
+	defer s.node.mtx.Unlock()
+	return &pbsvc.GetBlockRetainHeightResponse{
+		AppRetainHeight:            s.node.appRetainHeight,
+		PruningServiceRetainHeight: s.node.blockRetainHeight,
+	}, nil
+}
+
+func (s *pruningService) SetBlockResultsRetainHeight(_ context.Context, req *pbsvc.SetBlockResultsRetainHeightRequest) (*pbsvc.SetBlockResultsRetainHeightResponse, error) {
+	if err := s.node.takeError(MethodSetBlockResultsRetainHeight); err != nil {
+		return nil, err
+	}
+	s.node.mtx.Lock()
+	defer s.node.mtx.Unlock()
+	if req.Height > uint64(s.node.latest) {
+		return nil, status.Errorf(codes.InvalidArgument, "retain height %d is higher than the latest height %d", req.Height, s.node.latest)
+	}
+	s.node.blockResultsRetainHeight = req.Height
+	prune(s.node.blockResults, req.Height)
+	return &pbsvc.SetBlockResultsRetainHeightResponse{}, nil
+}
+
+func (s *pruningService) GetBlockResultsRetainHeight(context.Context, *pbsvc.GetBlockResultsRetainHeightRequest) (*pbsvc.GetBlockResultsRetainHeightResponse, error) {
+	if err := s.node.takeError(MethodGetBlockResultsRetainHeight); err != nil {
+		return nil, err
+	}
+	s.node.mtx.Lock()
+	defer s.node.mtx.Unlock()
+	return &pbsvc.GetBlockResultsRetainHeightResponse{
+		PruningServiceRetainHeight: s.node.blockResultsRetainHeight,
+	}, nil
+}